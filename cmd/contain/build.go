@@ -9,15 +9,20 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/spf13/cobra"
 	"github.com/turbokube/contain/pkg/appender"
 	containcache "github.com/turbokube/contain/pkg/cache"
 	"github.com/turbokube/contain/pkg/contain"
 	containenv "github.com/turbokube/contain/pkg/env"
 	"github.com/turbokube/contain/pkg/layers"
+	"github.com/turbokube/contain/pkg/ocipush"
 	"github.com/turbokube/contain/pkg/pushed"
 	"github.com/turbokube/contain/pkg/pushlock"
+	"github.com/turbokube/contain/pkg/registry"
 	"github.com/turbokube/contain/pkg/run"
 	"github.com/turbokube/contain/pkg/sbom"
 	"github.com/turbokube/contain/pkg/schema"
@@ -45,6 +50,10 @@ var (
 	outputFormat string
 	pushFlag     bool
 	pushLockPath string
+	locked       bool
+	force        bool
+	// buildUpload binds the direct-to-storage upload flags
+	buildUpload ocipush.Options
 )
 
 // newBuildCmd defines the build subcommand and its flags
@@ -70,7 +79,10 @@ built binary:
 
 For every platform present in the base image index, contain first looks
 up pathPerPlatform[<os>/<arch>]; if that is absent it falls back to
-path. If neither resolves, the build fails before any push.`,
+path. If neither resolves, the build fails before any push.
+
+With platforms: auto a platform whose source is missing is dropped
+instead, and the result contains only the platforms that could be built.`,
 		Args: func(cmd *cobra.Command, args []string) error {
 			if len(args) > 1 {
 				return errors.New("too many args: at most one context path")
@@ -86,11 +98,16 @@ path. If neither resolves, the build fails before any push.`,
 	c.Flags().BoolVarP(&watch, "w", "w", false, "watch layers sources and trigger build/run on change")
 	c.Flags().StringVar(&fileOutput, "file-output", "", "produce a builds JSON like Skaffold does")
 	c.Flags().StringVar(&metadataFile, "metadata-file", "", "produce a metadata JSON like buildctl does")
-	c.Flags().BoolVar(&platformsEnv, "platforms-env-require", false, fmt.Sprintf("requires env %s to be set, unless config specifies platforms", envPlatforms))
+	c.Flags().BoolVar(&platformsEnv, "platforms-env-require", false, fmt.Sprintf("requires env %s to be set, unless config specifies platforms or the base is a single manifest", envPlatforms))
 	c.Flags().StringVar(&tarballPath, "tarball", "", "write image as a Docker v2 tarball to this path (shorthand for --output PATH --format tarball)")
 	c.Flags().StringVar(&outputPath, "output", "", "write image to this path (format selected by --format)")
 	c.Flags().StringVar(&outputFormat, "format", "oci", `output format: "oci" or "tarball" (as in crane pull --format)`)
 	c.Flags().BoolVar(&pushFlag, "push", true, "push image to registry")
+	addDirectUploadFlags(c, &buildUpload, "registry")
+	addStagingFlag(c, &buildUpload)
+	addEventsFlag(c)
+	c.Flags().BoolVar(&force, "force", false, "move tags even if they match the config's immutableTags")
+	c.Flags().BoolVar(&locked, "locked", false, "fail if contain.lock is missing or stale, instead of resolving tags at build time")
 	c.Flags().StringVar(&pushLockPath, "push-lock", "", "absolute path to flock file for serializing pushes across processes")
 	c.Flags().StringVar(&sbomInFile, "sbom-in", "", "path to SPDX file for the contents of the build")
 	c.Flags().StringVar(&sbomOutFile, "sbom-out", "", "path to SPDX file to write (same as in to overwrite)")
@@ -136,8 +153,11 @@ func runBuild(cmd *cobra.Command, args []string) error {
 		if !stat.IsDir() {
 			zap.L().Fatal("context path not a directory", zap.String("arg", workdir), zap.String("abs", workdir))
 		}
-		chdir = appender.NewChdir(workdir)
-		defer chdir.Cleanup()
+		chdir, err = appender.NewChdir(workdir)
+		if err != nil {
+			return err
+		}
+		defer chdir.Cleanup() //nolint:errcheck
 	}
 
 	if base == "" && os.Getenv("CONTAIN_BASE") != "" {
@@ -191,11 +211,12 @@ func runBuild(cmd *cobra.Command, args []string) error {
 		zap.L().Debug("env", zap.String("name", envPlatforms), zap.Strings("platforms", p))
 		if len(config.Platforms) == 0 {
 			config.Platforms = p
+		} else if config.Platforms.Auto() {
+			zap.L().Info("platforms auto narrowed by env", zap.String("env", platforms))
+			config.Status.Overrides.AutoPlatforms = p
 		} else if !slices.Equal(config.Platforms, p) {
 			zap.L().Info("platforms not equal, config kept", zap.String("env", platforms), zap.Strings("config", config.Platforms))
 		}
-	} else if platformsEnv {
-		zap.S().Fatalf("%s env required but not found", envPlatforms)
 	}
 
 	aboutConfig := make([]zap.Field, 0)
@@ -212,6 +233,17 @@ func runBuild(cmd *cobra.Command, args []string) error {
 	}
 	zap.L().Info("config", aboutConfig...)
 
+	if runSelector == "" {
+		if err := pinBases(&config, locked); err != nil {
+			return err
+		}
+		if !exists && platformsEnv {
+			if err := requirePlatformsEnv(config); err != nil {
+				return err
+			}
+		}
+	}
+
 	builders, err := contain.RunLayers(config)
 	if err != nil {
 		zap.L().Fatal("layers", zap.Error(err))
@@ -328,12 +360,21 @@ func runBuild(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	emit, closeEvents, err := openEvents()
+	if err != nil {
+		return err
+	}
+	defer closeEvents()
+
 	buildOutput, err := contain.RunAppend(config, builders, contain.WriteOptions{
 		Push:         pushFlag,
 		OutputPath:   effectiveOutput,
 		OutputFormat: effectiveFormat,
 		PushLock:     plock,
 		LayerCache:   lc,
+		Force:        force,
+		Upload:       buildUpload,
+		Events:       emit,
 	})
 	if lc != nil {
 		lc.LogSummary()
@@ -354,7 +395,9 @@ func runBuild(cmd *cobra.Command, args []string) error {
 	}
 
 	if chdir != nil {
-		chdir.Cleanup()
+		if err := chdir.Cleanup(); err != nil {
+			return err
+		}
 	}
 	writeBuildOutput(buildOutput)
 
@@ -432,3 +475,31 @@ func writeBuildOutput(buildOutput *pushed.BuildOutput) {
 		}
 	}
 }
+
+// requirePlatformsEnv is --platforms-env-require for a build without the
+// env. Platforms in the config, or a single manifest base, which has one
+// platform, make the env unnecessary.
+func requirePlatformsEnv(config schemav1.ContainConfig) error {
+	if len(config.Platforms) > 0 {
+		return nil
+	}
+	if config.Base != "" {
+		r, err := registry.New(config)
+		if err != nil {
+			return err
+		}
+		ref, err := name.ParseReference(config.Base)
+		if err != nil {
+			return fmt.Errorf("base %s: %w", config.Base, err)
+		}
+		desc, err := remote.Head(ref, r.CraneOptions.Remote...)
+		if err != nil {
+			return fmt.Errorf("base %s: %w", config.Base, err)
+		}
+		if desc.MediaType == types.OCIManifestSchema1 || desc.MediaType == types.DockerManifestSchema2 {
+			zap.L().Info("platforms env not required for a single manifest base", zap.String("base", config.Base))
+			return nil
+		}
+	}
+	return fmt.Errorf("%s env required but not found", envPlatforms)
+}
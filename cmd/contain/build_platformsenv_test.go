@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	schemav1 "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+func TestRequirePlatformsEnv(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	host := strings.TrimPrefix(s.URL, "http://")
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, err := name.ParseReference(host + "/base/single:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(single, img); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := random.Index(64, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi, err := name.ParseReference(host + "/base/multi:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(multi, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := requirePlatformsEnv(schemav1.ContainConfig{Base: multi.String()}); err == nil || !strings.Contains(err.Error(), "PLATFORMS env required") {
+		t.Errorf("an index base requires the env, got %v", err)
+	}
+	if err := requirePlatformsEnv(schemav1.ContainConfig{Base: multi.String(), Platforms: []string{"linux/amd64"}}); err != nil {
+		t.Errorf("platforms in the config make the env unnecessary, got %v", err)
+	}
+	if err := requirePlatformsEnv(schemav1.ContainConfig{Base: single.String()}); err != nil {
+		t.Errorf("a single manifest base makes the env unnecessary, got %v", err)
+	}
+}
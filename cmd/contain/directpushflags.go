@@ -18,6 +18,14 @@ func addDirectUploadFlags(c *cobra.Command, opts *ocipush.Options, target string
 		fmt.Sprintf("multipart part size in bytes to propose to the %s (0 = registry default)", target))
 }
 
+// addImmutableFlags registers the tag immutability guard for push
+// destinations, which the build command gets from config instead
+func addImmutableFlags(c *cobra.Command, opts *ocipush.Options) {
+	c.Flags().StringSliceVar((*[]string)(&opts.ImmutableTags), "immutable-tags", nil,
+		`glob patterns, such as "v*", for tags that must not move to another digest once they exist`)
+	c.Flags().BoolVar(&opts.Force, "force", false, "move tags even if they match --immutable-tags")
+}
+
 // addStagingFlag registers where blobs are staged on disk, for the commands
 // that stage them. Worth a flag rather than only an env var because the
 // default, the system temp dir, is tmpfs in many container images: staging a
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/turbokube/contain/pkg/events"
+	"go.uber.org/zap"
+)
+
+var eventsTarget string
+
+// addEventsFlag registers --events for the commands that push. Writes to the
+// file are unbuffered, so a fatal exit loses no events.
+func addEventsFlag(c *cobra.Command) {
+	c.Flags().StringVar(&eventsTarget, "events", "",
+		"write NDJSON progress events to this file, or to a file descriptor number such as 3")
+}
+
+// openEvents returns the --events callback, nil without the flag, and how to close it
+func openEvents() (events.Func, func(), error) {
+	if eventsTarget == "" {
+		return nil, func() {}, nil
+	}
+	w, err := events.Open(eventsTarget)
+	if err != nil {
+		return nil, nil, err
+	}
+	return events.NDJSON(w), func() {
+		if err := w.Close(); err != nil {
+			zap.L().Warn("events close", zap.Error(err))
+		}
+	}, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/turbokube/contain/pkg/appender"
+	"github.com/turbokube/contain/pkg/lockfile"
+	"github.com/turbokube/contain/pkg/registry"
+	"github.com/turbokube/contain/pkg/schema"
+	schemav1 "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+func newLockCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "lock [context path]",
+		Short: "Pin tag-only base references to digests in contain.lock",
+		Long: `Resolves every base reference in the config that has a tag but no
+digest, and writes the digests to contain.lock next to the config.
+Builds then use the locked digests. Run it again to update them.
+
+contain build --locked fails if the lock is missing or doesn't match the
+config; without --locked that is a warning and unlocked tags are resolved
+at build time.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return errors.New("too many args: at most one context path")
+			}
+			return nil
+		},
+		RunE: runLock,
+	}
+	c.Flags().StringVarP(&configPath, "c", "c", "contain.yaml", "config file path relative to context dir, or - for stdin")
+	return c
+}
+
+func runLock(cmd *cobra.Command, args []string) error {
+	logger := newLogger()
+	defer logger.Sync() //nolint:errcheck
+	undo := zap.ReplaceGlobals(logger)
+	defer undo()
+
+	if len(args) == 1 {
+		dir, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		chdir, err := appender.NewChdir(dir)
+		if err != nil {
+			return err
+		}
+		defer chdir.Cleanup() //nolint:errcheck
+	}
+	config, err := schema.ParseConfig(configPath)
+	if err != nil {
+		return err
+	}
+	resolve, err := lockResolver(config)
+	if err != nil {
+		return err
+	}
+	lock, err := lockfile.New(config, resolve, zap.L())
+	if err != nil {
+		return err
+	}
+	path := lockfile.Path(configPath)
+	if err := lock.Write(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d base(s) locked\n", path, len(lock.Bases))
+	return nil
+}
+
+// lockResolver resolves tags with the registry config that a build of
+// config would use.
+func lockResolver(config schemav1.ContainConfig) (lockfile.Resolver, error) {
+	r, err := registry.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+	return lockfile.RemoteResolver(r.CraneOptions.Remote...), nil
+}
+
+// pinBases applies contain.lock to a build's config.
+func pinBases(config *schemav1.ContainConfig, locked bool) error {
+	path := lockfile.Path(configPath)
+	lock, err := lockfile.Read(path)
+	if err != nil {
+		return err
+	}
+	resolve, err := lockResolver(*config)
+	if err != nil {
+		return err
+	}
+	return lock.Pin(config, locked, resolve, zap.L())
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/turbokube/contain/pkg/registry"
+	schemav1 "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+func TestLockResolver_RegistriesConfigError(t *testing.T) {
+	t.Setenv(registry.SettingsFileEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	config := schemav1.ContainConfig{Base: "example.net/base:1"}
+	if _, err := lockResolver(config); err == nil || !strings.Contains(err.Error(), registry.SettingsFileEnv) {
+		t.Errorf("expected the registries config error, got %v", err)
+	}
+	if err := pinBases(&config, false); err == nil {
+		t.Error("pinBases should return the error")
+	}
+}
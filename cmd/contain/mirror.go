@@ -29,7 +29,9 @@ when available (see contain push). Credentials resolve like docker/crane.`,
 	c.Flags().BoolVar(&mirrorSrcPlainHTTP, "src-plain-http", false,
 		"use plain http for the source registry (e.g. cluster-internal registries)")
 	addDirectUploadFlags(c, &mirrorDstOptions, "destination")
+	addImmutableFlags(c, &mirrorDstOptions)
 	addStagingFlag(c, &mirrorDstOptions)
+	addEventsFlag(c)
 	return c
 }
 
@@ -39,6 +41,12 @@ func runMirror(cmd *cobra.Command, args []string) error {
 	undo := zap.ReplaceGlobals(logger)
 	defer undo()
 
+	emit, closeEvents, err := openEvents()
+	if err != nil {
+		return err
+	}
+	defer closeEvents()
+	mirrorDstOptions.Events = emit
 	return ocipush.Mirror(cmd.Context(), args[0], args[1], ocipush.MirrorOptions{
 		Src: ocipush.SourceOptions{PlainHTTP: mirrorSrcPlainHTTP},
 		Dst: mirrorDstOptions,
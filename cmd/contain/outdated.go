@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+	"github.com/turbokube/contain/pkg/outdated"
+	"github.com/turbokube/contain/pkg/registry"
+	schemav1 "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+func newOutdatedCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "outdated [dir]",
+		Short: "Report contain.yaml files whose repo:tag@digest base has a newer digest",
+		Long: `Finds contain.yaml files under dir, default the current directory, and
+for each base, including basePerPlatform entries, of the form
+repo:tag@sha256:... resolves the tag again with the config's registries
+settings.
+Prints a JSON summary to stdout, with the latest digest and the platforms
+added or removed for every outdated pin. Bases without a tag or digest are
+reported as skipped. Use contain lock for tag-only bases.`,
+		Args: outdatedArgs,
+		RunE: func(cmd *cobra.Command, args []string) error { return runOutdated(args, false) },
+	}
+}
+
+func newBumpCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "bump [dir]",
+		Short: "Update outdated base digests in contain.yaml files",
+		Long: `Like contain outdated, and rewrites the digest of every outdated base in
+place. Only the base reference changes; comments and formatting are kept.
+Prints the same JSON summary, with bumped set for rewritten configs.`,
+		Args: outdatedArgs,
+		RunE: func(cmd *cobra.Command, args []string) error { return runOutdated(args, true) },
+	}
+}
+
+func outdatedArgs(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		return errors.New("too many args: at most one dir")
+	}
+	return nil
+}
+
+func runOutdated(args []string, bump bool) error {
+	logger := newLogger()
+	defer logger.Sync() //nolint:errcheck
+	undo := zap.ReplaceGlobals(logger)
+	defer undo()
+
+	root := "."
+	if len(args) == 1 {
+		root = args[0]
+	}
+	paths, err := outdated.Find(root)
+	if err != nil {
+		return err
+	}
+	summary := outdated.Check(paths, outdated.RemoteRegistry{
+		Options: func(config schemav1.ContainConfig, ref name.Reference) ([]remote.Option, error) {
+			r, err := registry.New(schemav1.ContainConfig{Base: ref.String(), Registries: config.Registries})
+			if err != nil {
+				return nil, err
+			}
+			return r.CraneOptions.Remote, nil
+		},
+	}, bump)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(summary); err != nil {
+		return err
+	}
+	if summary.Errors > 0 {
+		return fmt.Errorf("%d of %d bases failed", summary.Errors, len(summary.Configs))
+	}
+	return nil
+}
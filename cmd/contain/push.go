@@ -26,6 +26,8 @@ Credentials are resolved like docker/crane (docker login).`,
 		RunE: runPush,
 	}
 	addDirectUploadFlags(c, &pushOptions, "registry")
+	addImmutableFlags(c, &pushOptions)
+	addEventsFlag(c)
 	return c
 }
 
@@ -35,5 +37,11 @@ func runPush(cmd *cobra.Command, args []string) error {
 	undo := zap.ReplaceGlobals(logger)
 	defer undo()
 
+	emit, closeEvents, err := openEvents()
+	if err != nil {
+		return err
+	}
+	defer closeEvents()
+	pushOptions.Events = emit
 	return ocipush.Push(cmd.Context(), args[0], args[1], pushOptions)
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/turbokube/contain/pkg/rebase"
+	"go.uber.org/zap"
+)
+
+var rebaseOptions rebase.Options
+
+func newRebaseCmd() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "rebase <image> --base <new-base@digest>",
+		Short: "Move a contain-built image onto a new base digest",
+		Long: `Replaces the base layers of an image that contain built, without the
+build context. The old base comes from the image's base annotations, or
+--old-base for images that lack the name annotation. Every layer above the
+old base is kept as is, as are env, entrypoint, cmd and workdir where they
+differ from the old base's.
+
+The new base must have every platform the image has. The result keeps the
+image's shape, an index or a single manifest, and is pushed to --tag,
+by default the image reference itself.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runRebase,
+	}
+	c.Flags().StringVar(&rebaseOptions.Base, "base", "", "the new base, with a digest")
+	c.Flags().StringVarP(&rebaseOptions.Tag, "tag", "t", "", "where to push the result, default the image")
+	c.Flags().StringVar(&rebaseOptions.OldBase, "old-base", "", "the old base repository, or reference with digest, if not annotated")
+	c.Flags().StringVar(&rebaseOptions.MediaTypes, "media-types", "", "preserve or oci, for a Docker new base")
+	c.Flags().BoolVar(&rebaseOptions.Push, "push", true, "push the result, false for a dry run")
+	c.Flags().StringVar(&fileOutput, "file-output", "", "produce a builds JSON like Skaffold does")
+	_ = c.MarkFlagRequired("base")
+	return c
+}
+
+func runRebase(cmd *cobra.Command, args []string) error {
+	logger := newLogger()
+	defer logger.Sync() //nolint:errcheck
+	undo := zap.ReplaceGlobals(logger)
+	defer undo()
+
+	rebaseOptions.Image = args[0]
+	rebaseOptions.Context = cmd.Context()
+	rebaseOptions.Logger = logger
+	buildOutput, err := rebase.Rebase(rebaseOptions)
+	if err != nil {
+		return err
+	}
+	buildOutput.Print()
+	writeBuildOutput(buildOutput)
+	return nil
+}
@@ -47,6 +47,7 @@ does not authenticate clients.`,
 	c.Flags().StringVar(&proxyPrefix, "prefix", "", "repository name prefix to add upstream, must end with /")
 	addDirectUploadFlags(c, &proxyOptions, "upstream")
 	addStagingFlag(c, &proxyOptions)
+	addEventsFlag(c)
 	cobra.CheckErr(c.MarkFlagRequired("upstream"))
 	return c
 }
@@ -57,6 +58,12 @@ func runRegistryProxy(cmd *cobra.Command, args []string) error {
 	undo := zap.ReplaceGlobals(logger)
 	defer undo()
 
+	emit, closeEvents, err := openEvents()
+	if err != nil {
+		return err
+	}
+	defer closeEvents()
+	proxyOptions.Events = emit
 	proxy, err := ocipush.NewProxy(proxyUpstream, proxyPrefix, proxyOptions)
 	if err != nil {
 		return err
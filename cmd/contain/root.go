@@ -33,6 +33,10 @@ func init() {
 	rootCmd.AddCommand(newPushCmd())
 	rootCmd.AddCommand(newRegistryProxyCmd())
 	rootCmd.AddCommand(newMirrorCmd())
+	rootCmd.AddCommand(newLockCmd())
+	rootCmd.AddCommand(newOutdatedCmd())
+	rootCmd.AddCommand(newBumpCmd())
+	rootCmd.AddCommand(newRebaseCmd())
 }
 
 // build subcommand is defined in build.go via newBuildCmd()
@@ -39,6 +39,10 @@ require (
 // which no base we know of uses.
 require github.com/containerd/platforms v0.2.1
 
+// Kubernetes quantity syntax for size budgets, see localdir.NewSize. The
+// version is the one skaffold already brings in.
+require k8s.io/apimachinery v0.28.3
+
 require golang.org/x/sync v0.21.0
 
 require (
@@ -149,7 +153,6 @@ require (
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	gotest.tools/v3 v3.5.2 // indirect
 	k8s.io/api v0.28.3 // indirect
-	k8s.io/apimachinery v0.28.3 // indirect
 	k8s.io/klog/v2 v2.120.1 // indirect
 	k8s.io/utils v0.0.0-20230726121419-3b25d923346b // indirect
 	sigs.k8s.io/json v0.0.0-20221116044647-bc3834ca7abd // indirect
@@ -3,7 +3,6 @@
 package appender
 
 import (
-	"context"
 	"fmt"
 	"time"
 
@@ -15,13 +14,14 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/turbokube/contain/pkg/annotate"
 	"github.com/turbokube/contain/pkg/cache"
+	"github.com/turbokube/contain/pkg/ocipush"
 	"github.com/turbokube/contain/pkg/pushlock"
 	"github.com/turbokube/contain/pkg/registry"
 	"go.uber.org/zap"
 )
 
 const (
-	progressReportMinInterval = "1s"
+	progressReportMinInterval = time.Second
 )
 
 // Appender transfers layers AND pushes manifest
@@ -29,6 +29,8 @@ const (
 type Appender struct {
 	baseRef    name.Digest
 	baseConfig *registry.RegistryConfig
+	// pushConfig is nil to push with baseConfig
+	pushConfig *registry.RegistryConfig
 	tagRef     name.Reference
 	annotators []annotate.Annotator
 	// envs holds KEY=VALUE pairs to override/add in resulting image config
@@ -45,6 +47,14 @@ type Appender struct {
 	pushLock pushlock.PushLock
 	// layerCache caches base image layers on disk for reuse across builds
 	layerCache *cache.BaseImageCache
+	// convertToOCI converts a Docker schema2 base and the result to OCI
+	convertToOCI bool
+	// pushByDigest pushes without tagging, for an index's children
+	pushByDigest bool
+	// upload tunes blob uploads, with access from the push config
+	upload ocipush.Options
+	// beforePush, if set, can refuse the result before anything is pushed
+	beforePush func(digest v1.Hash) error
 }
 
 type AppendAnnotate func(partial.WithRawManifest) v1.Image
@@ -78,6 +88,9 @@ type AppendResult struct {
 	Pushed mutate.IndexAddendum
 	// AddedManifestLayers are manifest data for appended and pushed layers
 	AddedManifestLayers []AppendResultLayer
+	// Existing is what the destination had of the result before Append,
+	// Missing unless pushing
+	Existing registry.Existing
 }
 
 var AppendResultNone = AppendResult{
@@ -153,7 +166,50 @@ func (c *Appender) WithCache(lc *cache.BaseImageCache) {
 	c.layerCache = lc
 }
 
+// WithConvertToOCI makes a result with OCI media types from a Docker v2
+// schema2 base. Without it a Docker base's media types are preserved.
+func (c *Appender) WithConvertToOCI(convert bool) {
+	c.convertToOCI = convert
+}
+
+// WithPushConfig sets the registry config for the push, if it differs from
+// the one the base is pulled with.
+func (c *Appender) WithPushConfig(pushConfig *registry.RegistryConfig) {
+	c.pushConfig = pushConfig
+}
+
+// WithPushByDigest pushes the result by digest in tagRef's repository
+// instead of to tagRef, for results that are children of an index
+func (c *Appender) WithPushByDigest(byDigest bool) {
+	c.pushByDigest = byDigest
+}
+
+// WithUploadOptions sets the direct-to-storage threshold, part size and
+// staging dir for pushes. Credentials and transport come from the push config.
+func (c *Appender) WithUploadOptions(upload ocipush.Options) {
+	c.upload = upload
+}
+
+// WithBeforePush sets a check of the result's digest, such as for immutable
+// tags that the caller will push it to, that runs before the push.
+func (c *Appender) WithBeforePush(check func(digest v1.Hash) error) {
+	c.beforePush = check
+}
+
+// uploadOptions are the upload options with the push config's access
+func (c *Appender) uploadOptions() ocipush.Options {
+	return c.getPushConfig().UploadOptions(c.upload)
+}
+
+// log is the base config's logger
+func (c *Appender) log() *zap.Logger {
+	return c.baseConfig.Logger()
+}
+
 func (c *Appender) getPushConfig() *registry.RegistryConfig {
+	if c.pushConfig != nil {
+		return c.pushConfig
+	}
 	return c.baseConfig
 }
 
@@ -170,13 +226,16 @@ func (c *Appender) base() (v1.Image, error) {
 		return nil, fmt.Errorf("getting base image media type: %w", err)
 	}
 	// When starting with an ImageIndex this should not need to happen because all mediaTypes can be validated from the index manifest
-	if mediaType != types.OCIManifestSchema1 {
-		return nil, fmt.Errorf("currently non-OCI manifests are de-supported, got: %s", mediaType)
+	if mediaType != types.OCIManifestSchema1 && mediaType != types.DockerManifestSchema2 {
+		return nil, fmt.Errorf("currently only OCI and Docker v2 schema2 manifests are supported, got: %s", mediaType)
 	}
 
 	if c.layerCache != nil {
 		base = c.layerCache.WrapImage(base)
 	}
+	if c.convertToOCI && mediaType == types.DockerManifestSchema2 {
+		base = newOCIImage(base)
+	}
 
 	return base, nil
 }
@@ -186,25 +245,25 @@ func (c *Appender) Append(layers ...v1.Layer) (AppendResult, error) {
 
 	base, err := c.base()
 	if err != nil {
-		zap.L().Error("Failed to get base image", zap.Error(err))
+		c.log().Error("Failed to get base image", zap.Error(err))
 		return AppendResultNone, err
 	}
 	baseConfig, err := base.ConfigFile()
 	if err != nil {
-		zap.L().Error("get base image config", zap.Error(err))
+		c.log().Error("get base image config", zap.Error(err))
 		return AppendResultNone, err
 	}
 
-	img, err := mutate.AppendLayers(base, layers...)
+	img, err := c.appendLayers(base, layers)
 	if err != nil {
-		zap.L().Error("Failed to append layers", zap.Error(err))
+		c.log().Error("Failed to append layers", zap.Error(err))
 		return AppendResultNone, err
 	}
 	// Apply env/entrypoint/args/workdir overrides before annotations and push
 	if len(c.envs) > 0 || len(c.entrypoint) > 0 || len(c.args) > 0 || c.workdir != "" {
 		cfg, err := img.ConfigFile()
 		if err != nil {
-			zap.L().Error("get image config for mutate", zap.Error(err))
+			c.log().Error("get image config for mutate", zap.Error(err))
 			return AppendResultNone, err
 		}
 		modified := false
@@ -227,7 +286,7 @@ func (c *Appender) Append(layers ...v1.Layer) (AppendResult, error) {
 		if modified {
 			img, err = mutate.Config(img, cfg.Config)
 			if err != nil {
-				zap.L().Error("mutate image config", zap.Error(err))
+				c.log().Error("mutate image config", zap.Error(err))
 				return AppendResultNone, err
 			}
 		}
@@ -237,36 +296,67 @@ func (c *Appender) Append(layers ...v1.Layer) (AppendResult, error) {
 	}
 	imgDigest, err := img.Digest()
 	if err != nil {
-		zap.L().Error("Failed to get result image digest", zap.Error(err))
+		c.log().Error("Failed to get result image digest", zap.Error(err))
 		return AppendResultNone, err
 	}
+	existing := registry.Missing
 	if !c.skipPush {
+		if c.beforePush != nil {
+			if err := c.beforePush(imgDigest); err != nil {
+				return AppendResultNone, err
+			}
+		}
 		if c.pushLock != nil {
-			release, lockErr := c.pushLock.Acquire(context.Background())
+			release, lockErr := c.pushLock.Acquire(c.getPushConfig().Context())
 			if lockErr != nil {
-				zap.L().Error("push lock acquire", zap.Error(lockErr))
+				c.log().Error("push lock acquire", zap.Error(lockErr))
 				return AppendResultNone, lockErr
 			}
 			defer release()
 		}
-		err = c.push(img)
+		ref := c.tagRef
+		if c.pushByDigest {
+			ref = c.tagRef.Context().Digest(imgDigest.String())
+		}
+		existing, err = registry.ExistingPush(ref, imgDigest, c.getPushConfig())
 		if err != nil {
-			zap.L().Error("Failed to push", zap.Error(err))
-			return AppendResultNone, err
+			c.log().Warn("existing check failed, pushing", zap.Error(err))
+			existing = registry.Missing
+		}
+		switch existing {
+		case registry.Tagged:
+			c.log().Info("exists, not pushed", zap.String("digest", imgDigest.String()))
+		case registry.Untagged:
+			err = c.tag(ref, img)
+			if err != nil {
+				c.log().Error("Failed to tag", zap.Error(err))
+				return AppendResultNone, err
+			}
+			c.log().Info("exists, tagged", zap.String("digest", imgDigest.String()))
+		default:
+			err = c.push(ref, img)
+			if err != nil {
+				c.log().Error("Failed to push", zap.Error(err))
+				return AppendResultNone, err
+			}
+			c.log().Info("pushed",
+				zap.String("digest", imgDigest.String()),
+			)
 		}
-		zap.L().Info("pushed",
-			zap.String("digest", imgDigest.String()),
-		)
 	}
 	delta, err := c.getLayersDeltaForImages(base, img)
 	if err != nil {
-		zap.L().Error("layers delta", zap.Error(err))
+		c.log().Error("layers delta", zap.Error(err))
+		return AppendResultNone, err
+	}
+	imgMediaType, err := img.MediaType()
+	if err != nil {
 		return AppendResultNone, err
 	}
 	appendable := mutate.IndexAddendum{
 		Add: img,
 		Descriptor: v1.Descriptor{
-			MediaType: types.OCIManifestSchema1,
+			MediaType: imgMediaType,
 			Digest:    imgDigest,
 			Platform:  baseConfig.Platform(),
 		},
@@ -275,35 +365,40 @@ func (c *Appender) Append(layers ...v1.Layer) (AppendResult, error) {
 		Hash:                imgDigest,
 		Pushed:              appendable,
 		AddedManifestLayers: delta,
+		Existing:            existing,
 	}
 	return result, nil
 }
 
-func (c *Appender) push(image v1.Image) error {
+// tag puts the manifest of image, which ref's repository has, to ref
+func (c *Appender) tag(ref name.Reference, image v1.Image) error {
+	raw, err := image.RawManifest()
+	if err != nil {
+		return err
+	}
 	mediaType, err := image.MediaType()
 	if err != nil {
 		return err
 	}
-	zap.L().Info("pushing", zap.String("mediaType", string(mediaType)))
+	return ocipush.PutManifest(c.getPushConfig().Context(), ref, raw, string(mediaType), c.uploadOptions())
+}
 
-	debounce, err := time.ParseDuration(progressReportMinInterval)
+func (c *Appender) push(ref name.Reference, image v1.Image) error {
+	mediaType, err := image.MediaType()
 	if err != nil {
-		zap.L().Fatal("failed to parse debounce interval", zap.String("value", progressReportMinInterval), zap.Error(err))
+		return err
 	}
+	c.log().Info("pushing", zap.String("mediaType", string(mediaType)))
 
 	progressChan := make(chan v1.Update, 200)
 	errChan := make(chan error, 2)
 
 	go func() {
-		options := append(c.getPushConfig().CraneOptions.Remote, remote.WithProgress(progressChan))
-		errChan <- remote.Write(
-			c.tagRef,
-			image,
-			options...,
-		)
+		errChan <- ocipush.PushImage(c.getPushConfig().Context(), ref, image, c.uploadOptions(), progressChan)
 	}()
 
-	logger := zap.L()
+	logger := c.log()
+	debounce := progressReportMinInterval
 	nextProgress := time.Now().Add(debounce)
 
 	for update := range progressChan {
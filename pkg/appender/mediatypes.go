@@ -0,0 +1,89 @@
+package appender
+
+import (
+	"encoding/json"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ociMediaTypes maps Docker v2 schema2 media types to their OCI equivalents.
+// The blobs are the same, only the descriptors that point to them change.
+var ociMediaTypes = map[types.MediaType]types.MediaType{
+	types.DockerManifestSchema2:   types.OCIManifestSchema1,
+	types.DockerConfigJSON:        types.OCIConfigJSON,
+	types.DockerLayer:             types.OCILayer,
+	types.DockerUncompressedLayer: types.OCIUncompressedLayer,
+	types.DockerForeignLayer:      types.OCIRestrictedLayer,
+}
+
+func toOCIMediaType(mt types.MediaType) types.MediaType {
+	if oci, ok := ociMediaTypes[mt]; ok {
+		return oci
+	}
+	return mt
+}
+
+// appendLayers appends to base, with OCI layer media types if base is OCI
+// because we converted it. An OCI base from a registry keeps the layers'
+// own media types, as it always has, so that its result digests are stable.
+func (c *Appender) appendLayers(base v1.Image, layers []v1.Layer) (v1.Image, error) {
+	if _, converted := base.(*ociImage); !converted {
+		return mutate.AppendLayers(base, layers...)
+	}
+	adds := make([]mutate.Addendum, len(layers))
+	for i, layer := range layers {
+		mt, err := layer.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		adds[i] = mutate.Addendum{Layer: layer, MediaType: toOCIMediaType(mt)}
+	}
+	return mutate.Append(base, adds...)
+}
+
+// ociImage is a Docker v2 schema2 image presented with OCI media types. The
+// manifest digest changes, the config and layer digests do not.
+type ociImage struct {
+	v1.Image
+}
+
+func newOCIImage(img v1.Image) v1.Image {
+	return &ociImage{Image: img}
+}
+
+func (i *ociImage) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+func (i *ociImage) Manifest() (*v1.Manifest, error) {
+	m, err := i.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	m = m.DeepCopy()
+	m.MediaType = toOCIMediaType(m.MediaType)
+	m.Config.MediaType = toOCIMediaType(m.Config.MediaType)
+	for l := range m.Layers {
+		m.Layers[l].MediaType = toOCIMediaType(m.Layers[l].MediaType)
+	}
+	return m, nil
+}
+
+func (i *ociImage) RawManifest() ([]byte, error) {
+	m, err := i.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+func (i *ociImage) Digest() (v1.Hash, error) {
+	return partial.Digest(i)
+}
+
+func (i *ociImage) Size() (int64, error) {
+	return partial.Size(i)
+}
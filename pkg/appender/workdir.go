@@ -1,6 +1,7 @@
 package appender
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -13,25 +14,20 @@ type Chdir struct {
 }
 
 // NewChdir changes current working directory to the path given by the dir arg
-func NewChdir(dir string) *Chdir {
+func NewChdir(dir string) (*Chdir, error) {
 	if !filepath.IsAbs(dir) {
-		zap.L().Fatal("should be absolute", zap.String("dir", dir))
+		return nil, fmt.Errorf("chdir %s: should be absolute", dir)
 	}
 	pwd, err := os.Getwd()
 	if err != nil {
-		zap.L().Fatal("get cwd",
-			zap.Error(err),
-		)
+		return nil, fmt.Errorf("get cwd: %w", err)
 	}
 	if pwd == dir {
 		zap.L().Warn("chdir change to current", zap.String("dir", dir))
 	}
 	err = os.Chdir(dir)
 	if err != nil {
-		zap.L().Fatal("change cwd",
-			zap.String("dir", dir),
-			zap.Error(err),
-		)
+		return nil, fmt.Errorf("change cwd: %w", err)
 	}
 	zap.L().Debug("cwd changed",
 		zap.String("to", dir),
@@ -39,23 +35,25 @@ func NewChdir(dir string) *Chdir {
 	)
 	return &Chdir{
 		pwd: pwd,
-	}
+	}, nil
 }
 
 // cleanup restores working directory based on the result of NewChdir
-func (c *Chdir) Cleanup() {
+func (c *Chdir) Cleanup() error {
 	if c.restored {
-		return
+		return nil
 	}
 	err := os.Chdir(c.pwd)
 	if err != nil {
-		zap.L().Fatal("restore cwd",
+		zap.L().Error("restore cwd",
 			zap.String("to", c.pwd),
 			zap.Error(err),
 		)
+		return fmt.Errorf("restore cwd %s: %w", c.pwd, err)
 	}
 	zap.L().Debug("cwd restored",
 		zap.String("dir", c.pwd),
 	)
 	c.restored = true
+	return nil
 }
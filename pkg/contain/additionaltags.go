@@ -0,0 +1,99 @@
+package contain
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/turbokube/contain/pkg/ocipush"
+	"github.com/turbokube/contain/pkg/pushlock"
+	"github.com/turbokube/contain/pkg/registry"
+	"go.uber.org/zap"
+)
+
+// parseAdditionalTags validates the additionalTags config, dropping those
+// equal to tag, before anything is pushed.
+func parseAdditionalTags(tag name.Reference, additional []string, log *zap.Logger) ([]name.Tag, error) {
+	var tags []name.Tag
+	seen := map[string]bool{tag.String(): true}
+	for i, a := range additional {
+		t, err := name.NewTag(a)
+		if err != nil {
+			return nil, fmt.Errorf("additionalTags[%d]: %w", i, err)
+		}
+		if seen[t.String()] {
+			log.Debug("additional tag repeated", zap.String("tag", t.String()))
+			continue
+		}
+		seen[t.String()] = true
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
+
+// pushAdditionalTags copies the pushed result, by digest, to each tag. The
+// first copy to a registry reads from the result and the later ones from
+// that copy, so blobs are uploaded once per registry and mounted across
+// repositories within it. A tag whose repository has the result already,
+// see registry.ExistingPush, is put without a copy or left as it is, and
+// returned as existed. Copies go through ocipush with upload, and reg's
+// access.
+func pushAdditionalTags(result name.Reference, digest v1.Hash, tags []name.Tag, reg *registry.RegistryConfig, upload ocipush.Options, lock pushlock.PushLock) ([]name.Tag, error) {
+	if lock != nil {
+		release, err := lock.Acquire(reg.Context())
+		if err != nil {
+			reg.Logger().Error("push lock acquire", zap.Error(err))
+			return nil, err
+		}
+		defer release()
+	}
+	sources := map[string]name.Digest{
+		result.Context().RegistryStr(): result.Context().Digest(digest.String()),
+	}
+	var existed []name.Tag
+	for _, t := range tags {
+		existing, err := registry.ExistingPush(t, digest, reg)
+		if err != nil {
+			reg.Logger().Warn("existing additional tag check failed, copying", zap.String("tag", t.String()), zap.Error(err))
+			existing = registry.Missing
+		}
+		src, ok := sources[t.Context().RegistryStr()]
+		if existing == registry.Tagged {
+			reg.Logger().Info("additional tag exists, not pushed", zap.String("tag", t.String()), zap.String("digest", digest.String()))
+		} else {
+			if existing == registry.Untagged {
+				// the repository has the manifest, so the copy is a tag put
+				src = t.Context().Digest(digest.String())
+			} else if !ok {
+				src = sources[result.Context().RegistryStr()]
+			}
+			if err := copyManifest(src, t, reg, upload); err != nil {
+				reg.Logger().Error("additional tag", zap.String("tag", t.String()), zap.String("source", src.String()), zap.Error(err))
+				return nil, fmt.Errorf("additional tag %s: %w", t, err)
+			}
+			reg.Logger().Info("additional tag",
+				zap.String("tag", t.String()),
+				zap.String("source", src.String()),
+				zap.String("digest", digest.String()),
+				zap.Bool("exists", existing == registry.Untagged),
+			)
+		}
+		if existing != registry.Missing {
+			existed = append(existed, t)
+		}
+		if !ok {
+			sources[t.Context().RegistryStr()] = t.Context().Digest(digest.String())
+		}
+	}
+	return existed, nil
+}
+
+// copyManifest writes src, an index or an image, to dst with its manifests
+// as is. Blobs are mounted if dst is in the same registry.
+func copyManifest(src name.Digest, dst name.Tag, reg *registry.RegistryConfig, upload ocipush.Options) error {
+	opts := reg.UploadOptions(upload)
+	return ocipush.Copy(reg.Context(), src, dst, ocipush.MirrorOptions{
+		Src: ocipush.SourceOptions{Auth: opts.Auth, Keychain: opts.Keychain, Transport: opts.Transport},
+		Dst: opts,
+	})
+}
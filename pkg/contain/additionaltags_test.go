@@ -0,0 +1,170 @@
+package contain_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/appender"
+	"github.com/turbokube/contain/pkg/contain"
+	"github.com/turbokube/contain/pkg/events"
+	"github.com/turbokube/contain/pkg/pushed"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+)
+
+func TestAdditionalTags(t *testing.T) {
+	RegisterTestingT(t)
+	// the same registry by another name counts as another registry
+	port := testRegistry[strings.LastIndex(testRegistry, ":")+1:]
+	other := "127.0.0.1:" + port
+	suffix := testcases.RandomHex(4)
+
+	dir := testcases.NewTempDir(t)
+	writeTestFile(t, dir, "payload.txt", "PAYLOAD")
+	cfg := schema.ContainConfig{
+		Base: pushPlatformIndex(t, "contain-test/additionaltags-base"),
+		Tag:  fmt.Sprintf("%s/contain-test/additionaltags:1.2.3-%s", testRegistry, suffix),
+		AdditionalTags: []string{
+			fmt.Sprintf("%s/contain-test/additionaltags:latest-%s", testRegistry, suffix),
+			fmt.Sprintf("%s/contain-test/additionaltags-public:1.2.3-%s", testRegistry, suffix),
+			fmt.Sprintf("%s/contain-test/additionaltags-customer:1.2.3-%s", other, suffix),
+			fmt.Sprintf("%s/contain-test/additionaltags-customer2:1.2.3-%s", other, suffix),
+			// the tag itself is not pushed twice
+			fmt.Sprintf("%s/contain-test/additionaltags:1.2.3-%s", testRegistry, suffix),
+		},
+		Layers: []schema.Layer{{
+			LocalFile: schema.LocalFile{Path: "payload.txt", ContainerPath: "/payload"},
+		}},
+	}
+	chdir, err := appender.NewChdir(dir.Root())
+	Expect(err).NotTo(HaveOccurred())
+	builders, err := contain.RunLayers(cfg)
+	Expect(err).NotTo(HaveOccurred())
+	var mu sync.Mutex
+	puts := map[string]string{}
+	out, err := contain.RunAppend(cfg, builders, contain.WriteOptions{Push: true, Events: func(e events.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Type == events.ManifestPut {
+			puts[e.Ref] = e.Digest
+		}
+	}})
+	chdir.Cleanup()
+	Expect(err).NotTo(HaveOccurred())
+
+	artifact := out.Artifact()
+	digest := artifact.Http().Hash
+	Expect(artifact.AdditionalTags).To(HaveLen(4))
+	for _, tag := range cfg.AdditionalTags[:4] {
+		Expect(puts).To(HaveKeyWithValue(tag, digest.String()))
+	}
+	for i, tag := range cfg.AdditionalTags[:4] {
+		Expect(artifact.AdditionalTags[i]).To(Equal(tag + "@" + digest.String()))
+		ref, err := name.ParseReference(tag)
+		Expect(err).NotTo(HaveOccurred())
+		desc, err := remote.Get(ref, testCraneOptions.Remote...)
+		Expect(err).NotTo(HaveOccurred(), tag)
+		Expect(desc.Digest).To(Equal(digest), tag)
+		index, err := desc.ImageIndex()
+		Expect(err).NotTo(HaveOccurred())
+		manifest, err := index.IndexManifest()
+		Expect(err).NotTo(HaveOccurred())
+		for _, child := range manifest.Manifests {
+			img, err := remote.Image(ref.Context().Digest(child.Digest.String()), testCraneOptions.Remote...)
+			Expect(err).NotTo(HaveOccurred(), tag)
+			layers, err := img.Layers()
+			Expect(err).NotTo(HaveOccurred())
+			for _, l := range layers {
+				_, err := l.Compressed()
+				Expect(err).NotTo(HaveOccurred(), "every blob is in %s", ref.Context())
+			}
+		}
+	}
+	Expect(out.Buildctl.ImageName).To(Equal(strings.Join(append([]string{cfg.Tag}, cfg.AdditionalTags[:4]...), ",")))
+}
+
+func TestAdditionalTags_Invalid(t *testing.T) {
+	RegisterTestingT(t)
+	dir := testcases.NewTempDir(t)
+	writeTestFile(t, dir, "payload.txt", "PAYLOAD")
+	cfg := schema.ContainConfig{
+		Base:           pushPlatformIndex(t, "contain-test/additionaltags-base"),
+		Tag:            fmt.Sprintf("%s/contain-test/additionaltags:invalid", testRegistry),
+		AdditionalTags: []string{fmt.Sprintf("%s/contain-test/additionaltags@sha256:%s", testRegistry, strings.Repeat("0", 64))},
+		Layers: []schema.Layer{{
+			LocalFile: schema.LocalFile{Path: "payload.txt", ContainerPath: "/payload"},
+		}},
+	}
+	chdir, err := appender.NewChdir(dir.Root())
+	Expect(err).NotTo(HaveOccurred())
+	defer chdir.Cleanup()
+	builders, err := contain.RunLayers(cfg)
+	Expect(err).NotTo(HaveOccurred())
+	_, err = contain.RunAppend(cfg, builders, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("additionalTags[0]"))
+}
+
+// A build that isn't pushed doesn't list additional tags, which it didn't go to.
+func TestAdditionalTags_NotPushed(t *testing.T) {
+	RegisterTestingT(t)
+	suffix := testcases.RandomHex(4)
+	cfg, dir := payloadConfig(t, pushPlatformIndex(t, "contain-test/additionaltags-base"),
+		fmt.Sprintf("%s/contain-test/additionaltags:nopush-%s", testRegistry, suffix))
+	cfg.AdditionalTags = []string{fmt.Sprintf("%s/contain-test/additionaltags:nopush-latest-%s", testRegistry, suffix)}
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{})
+	Expect(err).NotTo(HaveOccurred())
+	artifact := out.Artifact()
+	Expect(artifact.AdditionalTags).To(BeEmpty())
+	Expect(out.Buildctl.ImageName).To(Equal(cfg.Tag))
+	ref, err := name.ParseReference(cfg.AdditionalTags[0])
+	Expect(err).NotTo(HaveOccurred())
+	_, err = remote.Head(ref, testCraneOptions.Remote...)
+	Expect(err).To(HaveOccurred())
+}
+
+// An additional tag whose repository has the result is not copied again, and
+// reported in additionalTagsExist as the tag is with reason exists.
+func TestAdditionalTags_Exist(t *testing.T) {
+	RegisterTestingT(t)
+	suffix := testcases.RandomHex(4)
+	dir := testcases.NewTempDir(t)
+	writeTestFile(t, dir, "payload.txt", "PAYLOAD "+suffix)
+	cfg := schema.ContainConfig{
+		Base:           pushPlatformIndex(t, "contain-test/additionaltags-base"),
+		Tag:            fmt.Sprintf("%s/contain-test/additionaltags-exist:1-%s", testRegistry, suffix),
+		AdditionalTags: []string{fmt.Sprintf("%s/contain-test/additionaltags-exist-other:1-%s", testRegistry, suffix)},
+		Layers: []schema.Layer{{
+			LocalFile: schema.LocalFile{Path: "payload.txt", ContainerPath: "/payload"},
+		}},
+	}
+	build := func() pushed.Artifact {
+		chdir, err := appender.NewChdir(dir.Root())
+		Expect(err).NotTo(HaveOccurred())
+		defer chdir.Cleanup()
+		builders, err := contain.RunLayers(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		out, err := contain.RunAppend(cfg, builders, contain.WriteOptions{Push: true})
+		Expect(err).NotTo(HaveOccurred())
+		return out.Artifact()
+	}
+
+	first := build()
+	Expect(first.AdditionalTags).To(HaveLen(1))
+	Expect(first.AdditionalTagsExist).To(BeEmpty())
+
+	// one tag in place, and a new tag in a repository that has the result
+	cfg.AdditionalTags = append(cfg.AdditionalTags, fmt.Sprintf("%s/contain-test/additionaltags-exist-other:2-%s", testRegistry, suffix))
+	second := build()
+	Expect(second.AdditionalTagsExist).To(Equal(second.AdditionalTags))
+	ref, err := name.ParseReference(cfg.AdditionalTags[1])
+	Expect(err).NotTo(HaveOccurred())
+	desc, err := remote.Head(ref, testCraneOptions.Remote...)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(desc.Digest).To(Equal(second.Http().Hash))
+}
@@ -0,0 +1,116 @@
+package contain_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/turbokube/contain/pkg/contain"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+)
+
+// basePerPlatformConfig has no base, tests set base and basePerPlatform.
+func basePerPlatformConfig(t *testing.T, tag string) (schema.ContainConfig, *testcases.TempDir) {
+	t.Helper()
+	return payloadConfig(t, "", fmt.Sprintf("%s/contain-test/baseperplatform:%s", testRegistry, tag))
+}
+
+// childBase returns the first layer digest and the base digest annotation
+// of the result child for p.
+func childBase(t *testing.T, ref name.Reference, p v1.Platform) (v1.Hash, string) {
+	t.Helper()
+	img, err := remote.Image(ref, append(testCraneOptions.Remote, remote.WithPlatform(p))...)
+	Expect(err).NotTo(HaveOccurred())
+	m, err := img.Manifest()
+	Expect(err).NotTo(HaveOccurred())
+	return m.Layers[0].Digest, m.Annotations[specsv1.AnnotationBaseImageDigest]
+}
+
+func firstLayer(t *testing.T, ref string, p v1.Platform) v1.Hash {
+	t.Helper()
+	img, err := remote.Image(mustParseDigest(ref), append(testCraneOptions.Remote, remote.WithPlatform(p))...)
+	Expect(err).NotTo(HaveOccurred())
+	m, err := img.Manifest()
+	Expect(err).NotTo(HaveOccurred())
+	return m.Layers[0].Digest
+}
+
+func TestBasePerPlatform_ReplacesBaseChild(t *testing.T) {
+	RegisterTestingT(t)
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64"}
+	base := pushPlatformIndex(t, "contain-test/baseperplatform-base")
+	vendorArm := pushSingleBase(t)
+
+	cfg, dir := basePerPlatformConfig(t, "merged")
+	cfg.Base = base
+	cfg.BasePerPlatform = map[string]string{"linux/arm64": vendorArm}
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+
+	artifact := out.Artifact()
+	Expect(artifact.Platforms).To(Equal([]v1.Platform{amd64, arm64}), "base children first, then basePerPlatform")
+	layer, digest := childBase(t, artifact.Reference(), amd64)
+	Expect(layer).To(Equal(firstLayer(t, base, amd64)))
+	Expect(digest).To(Equal(mustParseDigest(base).DigestStr()))
+	layer, digest = childBase(t, artifact.Reference(), arm64)
+	Expect(layer).To(Equal(firstLayer(t, vendorArm, arm64)))
+	Expect(digest).To(Equal(mustParseDigest(vendorArm).DigestStr()))
+	Expect(fileInPlatformManifest(t, cfg.Tag, arm64, "/payload")).To(Equal("PAYLOAD"))
+}
+
+func TestBasePerPlatform_WithoutBase(t *testing.T) {
+	RegisterTestingT(t)
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64"}
+	other := pushPlatformIndex(t, "contain-test/baseperplatform-other")
+	vendorArm := pushSingleBase(t)
+
+	cfg, dir := basePerPlatformConfig(t, "nobase")
+	cfg.BasePerPlatform = map[string]string{
+		"linux/arm64": vendorArm,
+		// an index, of which only the amd64 child is used
+		"linux/amd64": other,
+	}
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+	artifact := out.Artifact()
+	Expect(artifact.Platforms).To(Equal([]v1.Platform{{OS: "linux", Architecture: "amd64"}, arm64}))
+	_, digest := childBase(t, artifact.Reference(), arm64)
+	Expect(digest).To(Equal(mustParseDigest(vendorArm).DigestStr()))
+}
+
+func TestBasePerPlatform_ExcludedByPlatforms(t *testing.T) {
+	RegisterTestingT(t)
+	base := pushPlatformIndex(t, "contain-test/baseperplatform-base")
+
+	cfg, dir := basePerPlatformConfig(t, "excluded")
+	cfg.Base = base
+	cfg.Platforms = schema.Platforms{"linux/amd64"}
+	// not fetched, so a reference that doesn't exist is fine
+	cfg.BasePerPlatform = map[string]string{"linux/arm64": mustParseDigest(base).Context().Digest("sha256:" + strings.Repeat("0", 64)).String()}
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(out.Artifact().Platforms).To(Equal([]v1.Platform{{OS: "linux", Architecture: "amd64"}}))
+}
+
+func TestBasePerPlatform_MixedMediaTypes(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := basePerPlatformConfig(t, "mixed")
+	cfg.Base = pushDockerBase(t)
+	cfg.MediaTypes = schema.MediaTypesPreserve
+	cfg.BasePerPlatform = map[string]string{"linux/arm64": pushSingleBase(t)}
+	_, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("mediaTypes: oci"))
+
+	cfg.MediaTypes = schema.MediaTypesOCI
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(out.Artifact().Platforms).To(HaveLen(2))
+}
@@ -0,0 +1,39 @@
+package contain
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/turbokube/contain/pkg/layers"
+	"github.com/turbokube/contain/pkg/pushed"
+	schemav1 "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+// BuildOptions are WriteOptions plus what Build needs instead of process state
+type BuildOptions struct {
+	WriteOptions
+	// Dir is the context dir that relative layer paths are resolved against,
+	// default the current dir, which Build never changes
+	Dir string
+}
+
+// Build is Run for programs that embed contain: it returns errors where
+// the CLI would exit, cancels layer builds, fetches and pushes with ctx, and
+// logs to opts.Logger instead of a zap.ReplaceGlobals logger. Builds may run
+// concurrently with different Dir.
+func Build(ctx context.Context, config schemav1.ContainConfig, opts BuildOptions) (*pushed.BuildOutput, error) {
+	layerOpts := layers.Options{Context: ctx, Logger: opts.logger()}
+	if opts.Dir != "" {
+		dir, err := filepath.Abs(opts.Dir)
+		if err != nil {
+			return nil, err
+		}
+		config = schemav1.InDir(config, dir)
+		layerOpts.ContextDir = dir
+	}
+	builders, err := runLayers(config, layerOpts)
+	if err != nil {
+		return nil, err
+	}
+	return runAppend(ctx, config, builders, opts.WriteOptions)
+}
@@ -0,0 +1,81 @@
+package contain_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/contain"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestBuild(t *testing.T) {
+	RegisterTestingT(t)
+	suffix := testcases.RandomHex(4)
+	dir := testcases.NewTempDir(t)
+	writeTestFile(t, dir, "payload.txt", "BUILD "+suffix)
+	cfg := schema.ContainConfig{
+		Base: pushSingleBase(t),
+		Tag:  fmt.Sprintf("%s/contain-test/build:%s", testRegistry, suffix),
+		Layers: []schema.Layer{{
+			LocalFile: schema.LocalFile{Path: "payload.txt", ContainerPath: "/payload"},
+		}},
+	}
+	cwd, err := os.Getwd()
+	Expect(err).NotTo(HaveOccurred())
+	core, logs := observer.New(zap.InfoLevel)
+
+	out, err := contain.Build(context.Background(), cfg, contain.BuildOptions{
+		WriteOptions: contain.WriteOptions{Push: true, Logger: zap.New(core)},
+		Dir:          dir.Root(),
+	})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(os.Getwd()).To(Equal(cwd), "Build must not chdir")
+	Expect(logs.FilterMessage("pushed").Len()).To(BeNumerically(">", 0), "logs go to the given logger")
+	Expect(logs.FilterMessage("layer buffer created").Len()).To(Equal(1), "layer logs too")
+
+	artifact := out.Artifact()
+	http := artifact.Http()
+	ref, err := name.ParseReference(fmt.Sprintf("%s@%s", cfg.Tag, http.Hash))
+	Expect(err).NotTo(HaveOccurred())
+	img, err := remote.Image(ref, testCraneOptions.Remote...)
+	Expect(err).NotTo(HaveOccurred())
+	layers, err := img.Layers()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(layers).To(HaveLen(2))
+}
+
+func TestBuildCanceled(t *testing.T) {
+	RegisterTestingT(t)
+	dir := testcases.NewTempDir(t)
+	writeTestFile(t, dir, "payload.txt", "CANCELED")
+	cfg := schema.ContainConfig{
+		Base: pushSingleBase(t),
+		Tag:  fmt.Sprintf("%s/contain-test/build:%s", testRegistry, testcases.RandomHex(4)),
+		Layers: []schema.Layer{{
+			LocalFile: schema.LocalFile{Path: "payload.txt", ContainerPath: "/payload"},
+		}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := contain.Build(ctx, cfg, contain.BuildOptions{
+		WriteOptions: contain.WriteOptions{Push: true, Logger: zap.NewNop()},
+		Dir:          dir.Root(),
+	})
+	Expect(err).To(MatchError(context.Canceled))
+}
+
+func TestBuildNoTag(t *testing.T) {
+	RegisterTestingT(t)
+	_, err := contain.Build(context.Background(), schema.ContainConfig{Base: pushSingleBase(t)}, contain.BuildOptions{
+		WriteOptions: contain.WriteOptions{Logger: zap.NewNop()},
+	})
+	Expect(err).To(MatchError("requires config tag"))
+}
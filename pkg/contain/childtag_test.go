@@ -0,0 +1,87 @@
+package contain_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/contain"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+)
+
+// childTagConfig builds the platforms index to tag, with childTag.
+func childTagConfig(t *testing.T, tag string, childTag string) (schema.ContainConfig, *testcases.TempDir) {
+	t.Helper()
+	cfg, dir := payloadConfig(t, pushPlatformIndex(t, "contain-test/childtag-base"), fmt.Sprintf("%s/contain-test/childtag:%s", testRegistry, tag))
+	cfg.ChildTag = childTag
+	return cfg, dir
+}
+
+func TestChildTag(t *testing.T) {
+	RegisterTestingT(t)
+	tag := "v" + testcases.RandomHex(4)
+	cfg, dir := childTagConfig(t, tag, "{{.Tag}}-{{.Arch}}")
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+
+	artifact := out.Artifact()
+	Expect(artifact.Children).To(HaveLen(2))
+	index, err := remote.Index(artifact.Reference(), testCraneOptions.Remote...)
+	Expect(err).NotTo(HaveOccurred())
+	manifest, err := index.IndexManifest()
+	Expect(err).NotTo(HaveOccurred())
+	for i, arch := range []string{"amd64", "arm64"} {
+		child := artifact.Children[i]
+		Expect(child.Platforms).To(Equal([]v1.Platform{{OS: "linux", Architecture: arch}}))
+		childRef, err := name.ParseReference(fmt.Sprintf("%s/contain-test/childtag:%s-%s", testRegistry, tag, arch))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(child.Reference().String()).To(Equal(childRef.String()))
+		desc, err := remote.Head(childRef, testCraneOptions.Remote...)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(desc.Digest).To(Equal(manifest.Manifests[i].Digest), "the child tag is the index child")
+		Expect(child.Http().Hash).To(Equal(desc.Digest))
+		Expect(out.Buildctl.Children[i].ImageName).To(Equal(childRef.String()))
+		Expect(out.Buildctl.Children[i].ContainerImageDescriptor.Platform.Architecture).To(Equal(arch))
+	}
+}
+
+// An immutable child tag refuses a changed result before the index is tagged
+func TestChildTag_Immutable(t *testing.T) {
+	RegisterTestingT(t)
+	tag := "r" + testcases.RandomHex(4)
+	cfg, dir := childTagConfig(t, tag, "{{.Tag}}-{{.Arch}}")
+	cfg.ImmutableTags = []string{"*-amd64"}
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+	first := out.Artifact()
+
+	writeTestFile(t, dir, "payload.txt", "CHANGED")
+	_, err = buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).To(MatchError(ContainSubstring("immutable tag " + cfg.Tag + "-amd64 exists")))
+	ref, err := name.ParseReference(cfg.Tag)
+	Expect(err).NotTo(HaveOccurred())
+	desc, err := remote.Head(ref, testCraneOptions.Remote...)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(desc.Digest).To(Equal(first.Http().Hash), "the index tag must not move")
+}
+
+func TestChildTag_Conflict(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := childTagConfig(t, "conflict", "{{.Tag}}-{{.OS}}")
+	_, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("childTag conflict-linux for linux/arm64 is also the tag of linux/amd64"))
+
+	cfg, dir = childTagConfig(t, "invalid", "{{.Tag}}/{{.Arch}}")
+	_, err = buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("childTag for linux/amd64"))
+
+	cfg, dir = childTagConfig(t, "missing", "{{.Tag}}-{{.Cpu}}")
+	_, err = buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,139 @@
+package contain_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/contain"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+)
+
+// pushDockerBase pushes a Docker manifest list with linux/amd64 and
+// linux/arm64 schema2 children, the shape of many Docker Hub images, and
+// returns its digest ref.
+func pushDockerBase(t *testing.T) string {
+	t.Helper()
+	idx := mutate.IndexMediaType(empty.Index, types.DockerManifestList)
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(64, 1)
+		Expect(err).NotTo(HaveOccurred())
+		cfg, err := img.ConfigFile()
+		Expect(err).NotTo(HaveOccurred())
+		cfg = cfg.DeepCopy()
+		cfg.OS, cfg.Architecture = "linux", arch
+		img, err = mutate.ConfigFile(img, cfg)
+		Expect(err).NotTo(HaveOccurred())
+		img = mutate.ConfigMediaType(mutate.MediaType(img, types.DockerManifestSchema2), types.DockerConfigJSON)
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				MediaType: types.DockerManifestSchema2,
+				Platform:  &v1.Platform{OS: "linux", Architecture: arch},
+			},
+		})
+	}
+	ref, err := name.ParseReference(fmt.Sprintf("%s/contain-test/dockerbase:%s", testRegistry, testcases.RandomHex(8)))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(remote.WriteIndex(ref, idx, testCraneOptions.Remote...)).To(Succeed())
+	digest, err := idx.Digest()
+	Expect(err).NotTo(HaveOccurred())
+	return ref.Context().Digest(digest.String()).String()
+}
+
+func dockerBaseConfig(t *testing.T, base string, mediaTypes string, tag string) (schema.ContainConfig, *testcases.TempDir) {
+	t.Helper()
+	cfg, dir := payloadConfig(t, base, fmt.Sprintf("%s/contain-test/dockerbase:%s", testRegistry, tag))
+	cfg.MediaTypes = mediaTypes
+	return cfg, dir
+}
+
+func TestDockerBase_RequiresMediaTypesChoice(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := dockerBaseConfig(t, pushDockerBase(t), "", "nochoice")
+	_, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("mediaTypes"))
+}
+
+func TestDockerBase_Preserve(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := dockerBaseConfig(t, pushDockerBase(t), schema.MediaTypesPreserve, "preserve")
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+	artifact := out.Artifact()
+	idx, err := remote.Index(artifact.Reference(), testCraneOptions.Remote...)
+	Expect(err).NotTo(HaveOccurred())
+
+	m, err := idx.IndexManifest()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(m.MediaType).To(Equal(types.DockerManifestList))
+	Expect(m.Manifests).To(HaveLen(2))
+	for _, d := range m.Manifests {
+		Expect(d.MediaType).To(Equal(types.DockerManifestSchema2))
+		img, err := idx.Image(d.Digest)
+		Expect(err).NotTo(HaveOccurred())
+		manifest, err := img.Manifest()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.MediaType).To(Equal(types.DockerManifestSchema2))
+		Expect(manifest.Config.MediaType).To(Equal(types.DockerConfigJSON))
+		Expect(manifest.Layers).To(HaveLen(2))
+		for _, l := range manifest.Layers {
+			Expect(l.MediaType).To(Equal(types.DockerLayer))
+		}
+	}
+}
+
+func TestDockerBase_ConvertToOCI(t *testing.T) {
+	RegisterTestingT(t)
+	base := pushDockerBase(t)
+	cfg, dir := dockerBaseConfig(t, base, schema.MediaTypesOCI, "oci")
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+	artifact := out.Artifact()
+	idx, err := remote.Index(artifact.Reference(), testCraneOptions.Remote...)
+	Expect(err).NotTo(HaveOccurred())
+
+	baseIdx, err := remote.Index(mustParseDigest(base), testCraneOptions.Remote...)
+	Expect(err).NotTo(HaveOccurred())
+	baseM, err := baseIdx.IndexManifest()
+	Expect(err).NotTo(HaveOccurred())
+
+	m, err := idx.IndexManifest()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(m.MediaType).To(Equal(types.OCIImageIndex))
+	Expect(m.Manifests).To(HaveLen(2))
+	for i, d := range m.Manifests {
+		Expect(d.MediaType).To(Equal(types.OCIManifestSchema1))
+		img, err := idx.Image(d.Digest)
+		Expect(err).NotTo(HaveOccurred())
+		manifest, err := img.Manifest()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.MediaType).To(Equal(types.OCIManifestSchema1))
+		Expect(manifest.Config.MediaType).To(Equal(types.OCIConfigJSON))
+		Expect(manifest.Layers).To(HaveLen(2))
+		for _, l := range manifest.Layers {
+			Expect(l.MediaType).To(Equal(types.OCILayer))
+		}
+		// the blobs are the base's, only the descriptors are converted
+		baseImg, err := baseIdx.Image(baseM.Manifests[i].Digest)
+		Expect(err).NotTo(HaveOccurred())
+		baseManifest, err := baseImg.Manifest()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Layers[0].Digest).To(Equal(baseManifest.Layers[0].Digest))
+	}
+}
+
+func mustParseDigest(ref string) name.Digest {
+	d, err := name.NewDigest(ref)
+	Expect(err).NotTo(HaveOccurred())
+	return d
+}
@@ -0,0 +1,27 @@
+package contain
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/turbokube/contain/pkg/events"
+)
+
+// emitLayersBuilt reports each layer built for platform p
+func emitLayersBuilt(emit events.Func, p v1.Platform, built []v1.Layer) error {
+	for _, l := range built {
+		digest, err := l.Digest()
+		if err != nil {
+			return err
+		}
+		size, err := l.Size()
+		if err != nil {
+			return err
+		}
+		mediaType, err := l.MediaType()
+		if err != nil {
+			return err
+		}
+		emit.Emit(events.Event{Type: events.LayerBuilt, Platform: p.String(),
+			Digest: digest.String(), Size: size, MediaType: string(mediaType)})
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package contain_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/appender"
+	"github.com/turbokube/contain/pkg/contain"
+	"github.com/turbokube/contain/pkg/events"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+)
+
+func TestEvents(t *testing.T) {
+	RegisterTestingT(t)
+	suffix := testcases.RandomHex(4)
+	dir := testcases.NewTempDir(t)
+	writeTestFile(t, dir, "payload.txt", "PAYLOAD "+suffix)
+	cfg := schema.ContainConfig{
+		Base:     pushPlatformIndex(t, "contain-test/events-base"),
+		Tag:      fmt.Sprintf("%s/contain-test/events:%s", testRegistry, suffix),
+		ChildTag: "{{.Tag}}-{{.Arch}}",
+		Layers: []schema.Layer{{
+			LocalFile: schema.LocalFile{Path: "payload.txt", ContainerPath: "/payload"},
+		}},
+	}
+	var mu sync.Mutex
+	var got []events.Event
+	chdir, err := appender.NewChdir(dir.Root())
+	Expect(err).NotTo(HaveOccurred())
+	builders, err := contain.RunLayers(cfg)
+	Expect(err).NotTo(HaveOccurred())
+	out, err := contain.RunAppend(cfg, builders, contain.WriteOptions{Push: true, Events: func(e events.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, e)
+	}})
+	chdir.Cleanup()
+	Expect(err).NotTo(HaveOccurred())
+
+	var phases []string
+	byType := map[events.Type][]events.Event{}
+	for _, e := range got {
+		byType[e.Type] = append(byType[e.Type], e)
+		if e.Type == events.PhaseEnd {
+			Expect(e.Error).To(BeEmpty())
+			phases = append(phases, e.Phase)
+		}
+	}
+	Expect(phases).To(Equal([]string{"base", "layers", "images"}))
+	Expect(byType[events.LayerBuilt]).To(HaveLen(2), "one layer per platform")
+	Expect(byType[events.LayerBuilt][0].Platform).To(Equal("linux/amd64"))
+	Expect(byType[events.BlobUploaded]).NotTo(BeEmpty())
+	Expect(byType[events.BlobSkip]).NotTo(BeEmpty(), "base layers")
+
+	artifact := out.Artifact()
+	var puts []string
+	for _, e := range byType[events.ManifestPut] {
+		puts = append(puts, e.Ref)
+	}
+	// two children by digest, the index, then its child tags
+	Expect(puts).To(HaveLen(5))
+	Expect(puts[0]).To(HavePrefix(cfg.Tag[:len(cfg.Tag)-len(suffix)-1] + "@sha256:"))
+	Expect(puts[2]).To(Equal(cfg.Tag))
+	Expect(byType[events.ManifestPut][2].Digest).To(Equal(artifact.Http().Hash.String()))
+	Expect(puts[3]).To(Equal(cfg.Tag + "-amd64"))
+	Expect(byType[events.ManifestPut][3].Digest).To(Equal(artifact.Children[0].Http().Hash.String()))
+}
@@ -0,0 +1,92 @@
+package contain_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/appender"
+	"github.com/turbokube/contain/pkg/contain"
+	"github.com/turbokube/contain/pkg/pushed"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+)
+
+func TestExists(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		base func(t *testing.T) string
+	}{
+		{"index", func(t *testing.T) string { return pushPlatformIndex(t, "contain-test/exists-base") }},
+		{"single", pushSingleBase},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			RegisterTestingT(t)
+			suffix := testcases.RandomHex(4)
+			dir := testcases.NewTempDir(t)
+			writeTestFile(t, dir, "payload.txt", "PAYLOAD "+suffix)
+			cfg := schema.ContainConfig{
+				Base: tc.base(t),
+				Tag:  fmt.Sprintf("%s/contain-test/exists-%s:1-%s", testRegistry, tc.name, suffix),
+				Layers: []schema.Layer{{
+					LocalFile: schema.LocalFile{Path: "payload.txt", ContainerPath: "/payload"},
+				}},
+			}
+			build := func() pushed.Artifact {
+				chdir, err := appender.NewChdir(dir.Root())
+				Expect(err).NotTo(HaveOccurred())
+				defer chdir.Cleanup()
+				builders, err := contain.RunLayers(cfg)
+				Expect(err).NotTo(HaveOccurred())
+				out, err := contain.RunAppend(cfg, builders, contain.WriteOptions{Push: true})
+				Expect(err).NotTo(HaveOccurred())
+				return out.Artifact()
+			}
+
+			first := build()
+			Expect(*first.Pushed).To(BeTrue())
+			Expect(first.Reason).To(BeEmpty())
+
+			second := build()
+			Expect(second.Http().Hash).To(Equal(first.Http().Hash))
+			j, err := json.Marshal(second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(j)).To(ContainSubstring(`"pushed":false,"reason":"exists"`))
+
+			// the same result to another tag only moves the tag
+			cfg.Tag = fmt.Sprintf("%s/contain-test/exists-%s:2-%s", testRegistry, tc.name, suffix)
+			third := build()
+			Expect(*third.Pushed).To(BeFalse())
+			Expect(third.Reason).To(Equal(pushed.ReasonExists))
+			ref, err := name.ParseReference(cfg.Tag)
+			Expect(err).NotTo(HaveOccurred())
+			desc, err := remote.Head(ref, testCraneOptions.Remote...)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(desc.Digest).To(Equal(first.Http().Hash))
+		})
+	}
+}
+
+func TestExists_NoPush(t *testing.T) {
+	RegisterTestingT(t)
+	dir := testcases.NewTempDir(t)
+	writeTestFile(t, dir, "payload.txt", "PAYLOAD")
+	cfg := schema.ContainConfig{
+		Base: pushSingleBase(t),
+		Tag:  fmt.Sprintf("%s/contain-test/exists-nopush:%s", testRegistry, testcases.RandomHex(4)),
+		Layers: []schema.Layer{{
+			LocalFile: schema.LocalFile{Path: "payload.txt", ContainerPath: "/payload"},
+		}},
+	}
+	chdir, err := appender.NewChdir(dir.Root())
+	Expect(err).NotTo(HaveOccurred())
+	builders, err := contain.RunLayers(cfg)
+	Expect(err).NotTo(HaveOccurred())
+	out, err := contain.RunAppend(cfg, builders, contain.WriteOptions{Push: false})
+	chdir.Cleanup()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(out.Artifact().Pushed).To(BeNil())
+}
@@ -0,0 +1,93 @@
+package contain_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/appender"
+	"github.com/turbokube/contain/pkg/contain"
+	"github.com/turbokube/contain/pkg/immutable"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+)
+
+func TestImmutableTags(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		base func(t *testing.T) string
+	}{
+		{"index", func(t *testing.T) string { return pushPlatformIndex(t, "contain-test/immutable-base") }},
+		{"single", pushSingleBase},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			RegisterTestingT(t)
+			suffix := testcases.RandomHex(4)
+			dir := testcases.NewTempDir(t)
+			cfg := schema.ContainConfig{
+				Base:          tc.base(t),
+				Tag:           fmt.Sprintf("%s/contain-test/immutable-%s:v1-%s", testRegistry, tc.name, suffix),
+				ImmutableTags: []string{"v*"},
+				Layers: []schema.Layer{{
+					LocalFile: schema.LocalFile{Path: "payload.txt", ContainerPath: "/payload"},
+				}},
+			}
+			build := func(payload string, opts contain.WriteOptions) (string, error) {
+				writeTestFile(t, dir, "payload.txt", payload)
+				chdir, err := appender.NewChdir(dir.Root())
+				Expect(err).NotTo(HaveOccurred())
+				defer chdir.Cleanup()
+				builders, err := contain.RunLayers(cfg)
+				Expect(err).NotTo(HaveOccurred())
+				opts.Push = true
+				out, err := contain.RunAppend(cfg, builders, opts)
+				if err != nil {
+					return "", err
+				}
+				artifact := out.Artifact()
+				return artifact.Http().Hash.String(), nil
+			}
+
+			first, err := build("1", contain.WriteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = build("1", contain.WriteOptions{})
+			Expect(err).NotTo(HaveOccurred(), "the same result")
+
+			_, err = build("2", contain.WriteOptions{})
+			var changed *immutable.ChangedError
+			Expect(errors.As(err, &changed)).To(BeTrue(), "%v", err)
+			Expect(changed.Ref).To(Equal(cfg.Tag))
+			Expect(changed.Existing).To(Equal(first))
+			Expect(err.Error()).To(ContainSubstring("--force"))
+
+			second, err := build("2", contain.WriteOptions{Force: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(second).NotTo(Equal(first))
+
+			// additional tags are checked before any of them is pushed
+			cfg.Tag = fmt.Sprintf("%s/contain-test/immutable-%s:latest-%s", testRegistry, tc.name, suffix)
+			cfg.AdditionalTags = []string{fmt.Sprintf("%s/contain-test/immutable-%s:v1-%s", testRegistry, tc.name, suffix)}
+			_, err = build("3", contain.WriteOptions{})
+			Expect(err).To(MatchError(ContainSubstring("immutable tag " + cfg.AdditionalTags[0] + " exists with digest " + second)))
+			// and before the tag, which only the additional tag's immutability stopped
+			tag, err := name.ParseReference(cfg.Tag)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = remote.Head(tag, testCraneOptions.Remote...)
+			Expect(err).To(HaveOccurred(), "the tag must not be pushed")
+		})
+	}
+}
+
+func TestImmutableTags_Invalid(t *testing.T) {
+	RegisterTestingT(t)
+	cfg := schema.ContainConfig{
+		Base:          pushSingleBase(t),
+		Tag:           fmt.Sprintf("%s/contain-test/immutable-invalid:v1", testRegistry),
+		ImmutableTags: []string{"v["},
+	}
+	_, err := contain.RunAppend(cfg, nil, contain.WriteOptions{Push: true})
+	Expect(err).To(MatchError(ContainSubstring(`immutableTags[0] "v["`)))
+}
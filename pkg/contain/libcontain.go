@@ -1,7 +1,10 @@
 package contain
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -9,8 +12,11 @@ import (
 	"github.com/turbokube/contain/pkg/annotate"
 	"github.com/turbokube/contain/pkg/appender"
 	"github.com/turbokube/contain/pkg/cache"
+	"github.com/turbokube/contain/pkg/events"
 	"github.com/turbokube/contain/pkg/layers"
+	"github.com/turbokube/contain/pkg/localdir"
 	"github.com/turbokube/contain/pkg/multiarch"
+	"github.com/turbokube/contain/pkg/ocipush"
 	"github.com/turbokube/contain/pkg/pushed"
 	"github.com/turbokube/contain/pkg/pushlock"
 	"github.com/turbokube/contain/pkg/registry"
@@ -30,10 +36,27 @@ type WriteOptions struct {
 	PushLock pushlock.PushLock
 	// LayerCache, if non-nil, caches base image layers on disk.
 	LayerCache *cache.BaseImageCache
+	// Force moves tags that the config's immutableTags would refuse to.
+	Force bool
+	// Upload tunes blob uploads, see ocipush.Options. Credentials and
+	// transport come from the registry config.
+	Upload ocipush.Options
+	// Events, if not nil, gets phase, layer and push events, also as
+	// Upload.Events unless that is set.
+	Events events.Func
+	// Logger defaults to zap.L().
+	Logger *zap.Logger
+}
+
+func (o WriteOptions) logger() *zap.Logger {
+	if o.Logger == nil {
+		return zap.L()
+	}
+	return o.Logger
 }
 
 // Run is what you call if you have a complete config and want to push an artifact
-// - Depends on a zap.ReplaceGlobals logger
+// - Depends on a zap.ReplaceGlobals logger and the current dir, see Build
 // - No side effects other than push to config.Tag (and child tags in case of an index)
 // - Not affected by environment, i.e. config defines a repeatable build
 func Run(config schemav1.ContainConfig) (*pushed.Artifact, error) {
@@ -62,20 +85,29 @@ func Run(config schemav1.ContainConfig) (*pushed.Artifact, error) {
 // known. For platform-agnostic layers (localDir, or localFile with only
 // Path set) the builder ignores its argument; for
 // localFile.pathPerPlatform the builder resolves the source per call.
+// A layer config that produces several layers, javaApp or nodeApp, has one
+// builder per layer.
 func RunLayers(config schemav1.ContainConfig) ([]layers.LayerBuilder, error) {
+	return runLayers(config, layers.Options{})
+}
 
-	layerBuilders := make([]layers.LayerBuilder, len(config.Layers))
+func runLayers(config schemav1.ContainConfig, opts layers.Options) ([]layers.LayerBuilder, error) {
+	log := opts.Logger
+	if log == nil {
+		log = zap.L()
+	}
+	layerBuilders := make([]layers.LayerBuilder, 0, len(config.Layers))
 	for i, layerCfg := range config.Layers {
-		b, err := layers.NewLayerBuilder(layerCfg)
+		b, err := layers.NewLayerBuilders(layerCfg, opts)
 		if err != nil {
-			zap.L().Error("Failed to get layer builder",
+			log.Error("Failed to get layer builder",
 				zap.Int("index", i),
 				zap.Any("config", layerCfg),
 				zap.Error(err),
 			)
 			return nil, err
 		}
-		layerBuilders[i] = b
+		layerBuilders = append(layerBuilders, b...)
 	}
 
 	return layerBuilders, nil
@@ -86,30 +118,61 @@ func RunLayers(config schemav1.ContainConfig) ([]layers.LayerBuilder, error) {
 
 // RunAppend is the remote access part of a run
 func RunAppend(config schemav1.ContainConfig, builders []layers.LayerBuilder, opts WriteOptions) (*pushed.BuildOutput, error) {
-	// source repo can differ from destination repo, we should probably struct tag + remote config
-	var baseRegistry *registry.RegistryConfig
-	var tagRegistry *registry.RegistryConfig
+	return runAppend(context.Background(), config, builders, opts)
+}
 
-	baseRegistry, err := registry.New(config)
+func runAppend(ctx context.Context, config schemav1.ContainConfig, builders []layers.LayerBuilder, opts WriteOptions) (output *pushed.BuildOutput, err error) {
+	log := opts.logger()
+	phases := events.NewPhases(opts.Events)
+	defer func() { phases.End(err) }()
+	if opts.Upload.Events == nil {
+		opts.Upload.Events = opts.Events
+	}
+	phases.Start("base")
+	// bases and tags can be in different registries, each with its settings
+	baseRegistry, err := registry.NewWithLogger(config, log)
 	if err != nil {
-		zap.L().Error("registry", zap.Error(err))
+		log.Error("registry", zap.Error(err))
 		return nil, err
 	}
-	tagRegistry = baseRegistry
+	tagRegistry, err := registry.NewPushWithLogger(config, log)
+	if err != nil {
+		log.Error("push registry", zap.Error(err))
+		return nil, err
+	}
+	for _, r := range []*registry.RegistryConfig{baseRegistry, tagRegistry} {
+		r.WithContext(ctx)
+	}
+	if opts.Force {
+		tagRegistry.ImmutableTags = nil
+	}
 
 	if config.Tag == "" {
-		zap.L().Fatal("requires config tag")
+		return nil, errors.New("requires config tag")
 	}
 	buildOutputTag, err := name.ParseReference(config.Tag)
 	if err != nil {
-		zap.L().Error("tag", zap.Error(err))
+		log.Error("tag", zap.Error(err))
+		return nil, err
+	}
+
+	additionalTags, err := parseAdditionalTags(buildOutputTag, config.AdditionalTags, log)
+	if err != nil {
 		return nil, err
 	}
 
-	// currently we assume that config base is an index
+	var maxImageSize int
+	if config.MaxImageSize != "" {
+		maxImageSize, err = localdir.NewSize(config.MaxImageSize)
+		if err != nil {
+			return nil, fmt.Errorf("maxImageSize: %w", err)
+		}
+	}
+
+	// the base is an index, or a single manifest handled as an index of one
 	index, err := multiarch.NewFromMultiArchBase(config, baseRegistry)
 	if err != nil {
-		zap.L().Error("index", zap.Error(err))
+		log.Error("index", zap.Error(err))
 		return nil, err
 	}
 
@@ -122,41 +185,122 @@ func RunAppend(config schemav1.ContainConfig, builders []layers.LayerBuilder, op
 	// the base index, or we would quietly publish a subset. The check this
 	// replaces only compared counts, and only on the single-manifest path, so
 	// asking for three platforms against a two-platform base succeeded.
-	unmatched, err := multiarch.UnmatchedPlatforms(config.Platforms, targetPlatforms)
+	unmatched, err := multiarch.UnmatchedPlatforms(config.Platforms.Requested(), targetPlatforms)
 	if err != nil {
 		return nil, err
 	}
 	if len(unmatched) > 0 {
 		return nil, fmt.Errorf("platforms %v matched no manifest in base %s, which has %v",
-			unmatched, config.Base, index.BasePlatforms())
+			unmatched, strings.Join(config.Bases(), " "), index.BasePlatforms())
+	}
+
+	// With platforms: auto the base proposes and the layer sources on disk
+	// decide: a base platform that some pathPerPlatform has no file for is
+	// dropped here, where a listed platform would fail ValidateLayers below.
+	var platformSelection *pushed.PlatformSelection
+	if config.Platforms.Auto() {
+		platformSelection, err = selectAutoPlatforms(config, index, log)
+		if err != nil {
+			return nil, err
+		}
+		targetPlatforms = index.MatchedPlatforms()
 	}
 
 	// Fail fast before any push if the config shape is broken or if any
 	// platform in the base index has no resolvable localFile source.
-	if err := schemav1.ValidateLayers(config, targetPlatforms); err != nil {
-		zap.L().Error("layers validate", zap.Error(err))
+	if err := layers.ValidateLayers(config, targetPlatforms); err != nil {
+		log.Error("layers validate", zap.Error(err))
 		return nil, err
 	}
 
+	// Child tags are pushed with the index, but a bad template should fail
+	// before anything is
+	childTags, err := index.ChildTags(buildOutputTag)
+	if err != nil {
+		return nil, err
+	}
+
+	// immutableTags are checked for every tag the result goes to, once the
+	// digests are known and before any of the tags is put, so that a
+	// release isn't published half-way. Children are nil for a single
+	// image result, which has no child tags.
+	checkImmutable := func(result v1.Hash, children []v1.Hash) error {
+		if err := registry.CheckImmutable(buildOutputTag, result, tagRegistry); err != nil {
+			return err
+		}
+		for _, t := range additionalTags {
+			if err := registry.CheckImmutable(t, result, tagRegistry); err != nil {
+				return err
+			}
+		}
+		for i, t := range childTags {
+			if children == nil {
+				break
+			}
+			if err := registry.CheckImmutable(t, children[i], tagRegistry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// A javaApp knows how it is started, unless the config says otherwise
+	if len(config.Entrypoint) == 0 {
+		entrypoint, err := layers.JavaAppEntrypoint(config.Layers)
+		if err != nil {
+			return nil, err
+		}
+		if entrypoint != nil {
+			log.Info("javaApp entrypoint", zap.Strings("entrypoint", entrypoint))
+			config.Entrypoint = entrypoint
+		}
+	}
+
 	// Pre-build all layers for all target platforms before any push, so a
 	// filesystem error on one platform does not leave others half-pushed.
+	phases.Start("layers")
 	layersByPlatform := make(map[string][]v1.Layer, len(targetPlatforms))
 	for _, p := range targetPlatforms {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		built, err := layers.Build(builders, p)
 		if err != nil {
-			zap.L().Error("layer builder invocation failed", zap.String("platform", p.String()), zap.Error(err))
+			log.Error("layer builder invocation failed", zap.String("platform", p.String()), zap.Error(err))
 			return nil, err
 		}
 		layersByPlatform[p.String()] = built
+		if opts.Events != nil {
+			if err := emitLayersBuilt(opts.Events, p, built); err != nil {
+				return nil, err
+			}
+		}
 	}
 
+	if maxImageSize > 0 {
+		if err := checkImageSize(config, index, targetPlatforms, layersByPlatform, int64(maxImageSize), log); err != nil {
+			log.Error("image size", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	resultIsIndex := index.SizeAppend() > 1 || config.WrapIndex
+	// existing is by platform, from appending with push
+	existing := make(map[string]registry.Existing)
 	each := func(b name.Digest, t name.Reference, tr *registry.RegistryConfig, platform v1.Platform) (mutate.IndexAddendum, error) {
-		a, err := appender.New(b, tr, t)
+		a, err := appender.New(b, baseRegistry, t)
 		if err != nil {
-			zap.L().Error("appender", zap.Error(err))
+			log.Error("appender", zap.Error(err))
 			return mutate.IndexAddendum{}, err
 		}
+		a.WithPushConfig(tr)
 		a.WithSkipPush(!opts.Push)
+		a.WithPushByDigest(resultIsIndex)
+		if !resultIsIndex {
+			a.WithBeforePush(func(digest v1.Hash) error { return checkImmutable(digest, nil) })
+		}
+		a.WithUploadOptions(opts.Upload)
+		a.WithConvertToOCI(index.ConvertToOCI())
 		if opts.PushLock != nil {
 			a.WithPushLock(opts.PushLock)
 		}
@@ -184,16 +328,17 @@ func RunAppend(config schemav1.ContainConfig, builders []layers.LayerBuilder, op
 			a.WithWorkdir(config.WorkingDir)
 		}
 		// Set base image annotation hints as per crane rebase docs
-		if ann, err := annotate.NewBaseImageAnnotations(config.Base); err == nil {
+		if ann, err := annotate.NewBaseImageAnnotations(schemav1.ResolveBase(config, platform)); err == nil {
 			a.WithAnnotate(ann)
 		} else {
-			zap.L().Error("base image annotations", zap.Error(err))
+			log.Error("base image annotations", zap.Error(err))
 		}
 		r, err := a.Append(layersByPlatform[platform.String()]...)
 		if err != nil {
-			zap.L().Error("append", zap.Error(err))
+			log.Error("append", zap.Error(err))
 			return mutate.IndexAddendum{}, err
 		}
+		existing[platform.String()] = r.Existing
 		return r.Pushed, nil
 	}
 
@@ -201,20 +346,27 @@ func RunAppend(config schemav1.ContainConfig, builders []layers.LayerBuilder, op
 	var resultImg v1.Image
 	var resultIdx v1.ImageIndex
 
-	if index.SizeAppend() > 1 {
+	phases.Start("images")
+
+	if resultIsIndex {
+		index.WithUploadOptions(opts.Upload)
+		index.WithBeforeTag(checkImmutable)
 		resultIdx, result, err = index.BuildWithAppend(each, buildOutputTag, tagRegistry, opts.Push)
 		if err != nil {
-			zap.L().Error("index build", zap.Error(err))
+			log.Error("index build", zap.Error(err))
 			return nil, err
 		}
 	} else {
+		if config.ChildTag != "" {
+			log.Warn("childTag ignored for a single image result, see wrapIndex")
+		}
 		prototypeBase, err := index.GetPrototypeBase()
 		if err != nil {
 			return nil, fmt.Errorf("single platform base: %w", err)
 		}
 		pushedAdd, err := each(prototypeBase, buildOutputTag, tagRegistry, index.PrototypePlatform())
 		if err != nil {
-			zap.L().Error("single image build", zap.Error(err))
+			log.Error("single image build", zap.Error(err))
 			return nil, err
 		}
 		// Build artifact for single-image case
@@ -223,26 +375,51 @@ func RunAppend(config schemav1.ContainConfig, builders []layers.LayerBuilder, op
 		if err != nil {
 			return nil, err
 		}
-		result, err = pushed.NewSingleImage(buildOutputTag.String(), hash, resultImg, pushedAdd.Descriptor.Platform, config.Base)
+		result, err = pushed.NewSingleImage(buildOutputTag.String(), hash, resultImg, pushedAdd.Descriptor.Platform, schemav1.ResolveBase(config, index.PrototypePlatform()))
 		if err != nil {
 			return nil, err
 		}
-		zap.L().Info("single platform", zap.String("tag", buildOutputTag.String()), zap.String("hash", hash.String()))
+		if opts.Push {
+			result.SetPushed(existing[index.PrototypePlatform().String()] != registry.Missing)
+		}
+		log.Info("single platform", zap.String("tag", buildOutputTag.String()), zap.String("hash", hash.String()))
 	}
 
 	if opts.OutputPath != "" {
+		phases.Start("output")
 		format := opts.OutputFormat
 		if format == "" {
 			format = FormatTarball
 		}
-		if err := writeOutput(format, opts.OutputPath, buildOutputTag, resultImg, resultIdx); err != nil {
-			zap.L().Error("output", zap.String("format", string(format)), zap.Error(err))
+		if err := writeOutput(format, opts.OutputPath, buildOutputTag, resultImg, resultIdx, log); err != nil {
+			log.Error("output", zap.String("format", string(format)), zap.Error(err))
 			return nil, err
 		}
 	}
 
 	// Propagate base information from config to the pushed artifact
 	// BaseRef already set by constructors
+	result.PlatformSelection = platformSelection
+	result.LayersPerPlatform = make(map[string]int, len(layersByPlatform))
+	for p, built := range layersByPlatform {
+		result.LayersPerPlatform[p] = len(built)
+	}
+
+	// an unpushed result is at its tag only, if at all
+	if len(additionalTags) > 0 && opts.Push {
+		hash := result.Http().Hash
+		phases.Start("additionalTags")
+		existed, err := pushAdditionalTags(buildOutputTag, hash, additionalTags, tagRegistry, opts.Upload, opts.PushLock)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range existed {
+			result.AdditionalTagsExist = append(result.AdditionalTagsExist, t.String()+"@"+hash.String())
+		}
+		for _, t := range additionalTags {
+			result.AdditionalTags = append(result.AdditionalTags, t.String()+"@"+hash.String())
+		}
+	}
 
 	// todo multi-arch index from prototype result to result index
 	// produces new result hash
@@ -250,10 +427,90 @@ func RunAppend(config schemav1.ContainConfig, builders []layers.LayerBuilder, op
 	// Build output from the produced artifact (includes config digest for single images)
 	buildOutput, err := pushed.NewBuildOutput(buildOutputTag.String(), result)
 	if err != nil {
-		zap.L().Error("buildOutput", zap.Error(err))
+		log.Error("buildOutput", zap.Error(err))
 		return nil, err
 	}
 
 	return buildOutput, nil
 
 }
+
+// checkImageSize compares each platform's base layers plus the layers we
+// built for it against the maxImageSize budget, sizes as in the manifests
+// that will be pushed, and names the largest appended files of any image
+// over budget.
+func checkImageSize(config schemav1.ContainConfig, index *multiarch.IndexManifests, platforms []v1.Platform, layersByPlatform map[string][]v1.Layer, max int64, log *zap.Logger) error {
+	var errs []string
+	for _, p := range platforms {
+		base := index.BaseLayersSize(p)
+		var appended int64
+		for _, l := range layersByPlatform[p.String()] {
+			size, err := l.Size()
+			if err != nil {
+				return err
+			}
+			appended += size
+		}
+		log.Debug("image size",
+			zap.String("platform", p.String()),
+			zap.Int64("base", base),
+			zap.Int64("appended", appended),
+		)
+		if base+appended > max {
+			errs = append(errs, fmt.Sprintf("%s image size %s (base %s + appended %s) exceeds maxImageSize %s, largest appended files: %s",
+				p.String(), localdir.FormatSize(base+appended), localdir.FormatSize(base), localdir.FormatSize(appended),
+				config.MaxImageSize, layers.DescribeLargestFiles(layersByPlatform[p.String()])))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// selectAutoPlatforms drops every matched platform that lacks a per-platform
+// layer source, or that Status.Overrides.AutoPlatforms leaves out, logging
+// each with its reasons, and fails only if nothing is left to build.
+func selectAutoPlatforms(config schemav1.ContainConfig, index *multiarch.IndexManifests, log *zap.Logger) (*pushed.PlatformSelection, error) {
+	selection := &pushed.PlatformSelection{Mode: schemav1.PlatformsAuto}
+	within := config.Status.Overrides.AutoPlatforms
+	unmatched, err := multiarch.UnmatchedPlatforms(within, index.MatchedPlatforms())
+	if err != nil {
+		return nil, err
+	}
+	if len(unmatched) > 0 {
+		return nil, fmt.Errorf("platforms %v to narrow platforms auto to matched no manifest in base %s, which has %v",
+			unmatched, strings.Join(config.Bases(), " "), index.BasePlatforms())
+	}
+	var keep []v1.Platform
+	for _, p := range index.MatchedPlatforms() {
+		missing := layers.MissingSources(config.Layers, p)
+		if len(within) > 0 {
+			if u, _ := multiarch.UnmatchedPlatforms(within, []v1.Platform{p}); len(u) == len(within) {
+				missing = append(missing, fmt.Sprintf("not in %s", strings.Join(within, ",")))
+			}
+		}
+		if len(missing) == 0 {
+			keep = append(keep, p)
+			continue
+		}
+		log.Info("platform dropped by platforms auto",
+			zap.String("platform", p.String()),
+			zap.Strings("reasons", missing),
+		)
+		selection.Dropped = append(selection.Dropped, pushed.DroppedPlatform{
+			Platform: p.String(),
+			Reasons:  missing,
+		})
+	}
+	if len(keep) == 0 {
+		reasons := make([]string, len(selection.Dropped))
+		for i, d := range selection.Dropped {
+			reasons[i] = fmt.Sprintf("%s (%s)", d.Platform, strings.Join(d.Reasons, ", "))
+		}
+		return nil, fmt.Errorf("platforms auto: no platform in base %s has every per-platform layer source: %s",
+			config.Base, strings.Join(reasons, "; "))
+	}
+	index.RetainPlatforms(keep)
+	return selection, nil
+}
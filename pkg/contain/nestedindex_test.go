@@ -0,0 +1,107 @@
+package contain_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/contain"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+)
+
+func platformImage(p v1.Platform) v1.Image {
+	img, err := random.Image(64, 1)
+	Expect(err).NotTo(HaveOccurred())
+	cfg, err := img.ConfigFile()
+	Expect(err).NotTo(HaveOccurred())
+	cfg = cfg.DeepCopy()
+	cfg.OS, cfg.Architecture, cfg.Variant = p.OS, p.Architecture, p.Variant
+	img, err = mutate.ConfigFile(img, cfg)
+	Expect(err).NotTo(HaveOccurred())
+	return mutate.ConfigMediaType(mutate.MediaType(img, types.OCIManifestSchema1), types.OCIConfigJSON)
+}
+
+// pushNestedBase pushes an index whose children are linux/amd64 and a
+// nested index grouping arm variants, where the v7 child has no platform of
+// its own and gets it from the nested index's descriptor.
+func pushNestedBase(t *testing.T) string {
+	t.Helper()
+	arm := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{
+			Add: platformImage(v1.Platform{OS: "linux", Architecture: "arm64"}),
+			Descriptor: v1.Descriptor{
+				MediaType:   types.OCIManifestSchema1,
+				Platform:    &v1.Platform{OS: "linux", Architecture: "arm64"},
+				Annotations: map[string]string{"vendor.example/flavor": "arm64"},
+			},
+		},
+		mutate.IndexAddendum{
+			Add:        platformImage(v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}),
+			Descriptor: v1.Descriptor{MediaType: types.OCIManifestSchema1},
+		},
+	)
+	top := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{
+			Add: platformImage(v1.Platform{OS: "linux", Architecture: "amd64"}),
+			Descriptor: v1.Descriptor{
+				MediaType: types.OCIManifestSchema1,
+				Platform:  &v1.Platform{OS: "linux", Architecture: "amd64"},
+			},
+		},
+		mutate.IndexAddendum{
+			Add: arm,
+			Descriptor: v1.Descriptor{
+				MediaType: types.OCIImageIndex,
+				Platform:  &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+			},
+		},
+	)
+	ref, err := name.ParseReference(fmt.Sprintf("%s/contain-test/nestedbase:%s", testRegistry, testcases.RandomHex(8)))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(remote.WriteIndex(ref, top, testCraneOptions.Remote...)).To(Succeed())
+	digest, err := top.Digest()
+	Expect(err).NotTo(HaveOccurred())
+	return ref.Context().Digest(digest.String()).String()
+}
+
+func nestedBaseConfig(t *testing.T, tag string, platforms []string) (schema.ContainConfig, *testcases.TempDir) {
+	t.Helper()
+	cfg, dir := payloadConfig(t, pushNestedBase(t), fmt.Sprintf("%s/contain-test/nestedbase:%s", testRegistry, tag))
+	cfg.Platforms = platforms
+	return cfg, dir
+}
+
+func TestNestedIndexBase_Flattened(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := nestedBaseConfig(t, "flat", nil)
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+
+	artifact := out.Artifact()
+	idx, err := remote.Index(artifact.Reference(), testCraneOptions.Remote...)
+	Expect(err).NotTo(HaveOccurred())
+	m, err := idx.IndexManifest()
+	Expect(err).NotTo(HaveOccurred())
+	platforms := make([]string, 0, len(m.Manifests))
+	for _, d := range m.Manifests {
+		Expect(d.MediaType).To(Equal(types.OCIManifestSchema1), "the result index is flat")
+		platforms = append(platforms, d.Platform.String())
+	}
+	Expect(platforms).To(Equal([]string{"linux/amd64", "linux/arm64", "linux/arm/v7"}))
+}
+
+func TestNestedIndexBase_UnmatchedShowsPath(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := nestedBaseConfig(t, "unmatched", []string{"linux/s390x"})
+	_, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("linux/arm/v7 (manifests[1].manifests[1])"))
+}
@@ -19,26 +19,26 @@ const (
 	FormatOCI     OutputFormat = "oci"
 )
 
-func writeOutput(format OutputFormat, path string, ref name.Reference, img v1.Image, idx v1.ImageIndex) error {
+func writeOutput(format OutputFormat, path string, ref name.Reference, img v1.Image, idx v1.ImageIndex, log *zap.Logger) error {
 	switch format {
 	case FormatOCI:
-		return writeOCI(path, ref, img, idx)
+		return writeOCI(path, ref, img, idx, log)
 	case FormatTarball:
-		return writeTarball(path, ref, img, idx)
+		return writeTarball(path, ref, img, idx, log)
 	default:
 		return fmt.Errorf("unsupported output format: %s", format)
 	}
 }
 
-func writeTarball(path string, ref name.Reference, img v1.Image, idx v1.ImageIndex) error {
+func writeTarball(path string, ref name.Reference, img v1.Image, idx v1.ImageIndex, log *zap.Logger) error {
 	if idx != nil {
-		return writeIndexTarball(path, ref, idx)
+		return writeIndexTarball(path, ref, idx, log)
 	}
-	zap.L().Info("writing tarball", zap.String("path", path), zap.String("ref", ref.String()))
+	log.Info("writing tarball", zap.String("path", path), zap.String("ref", ref.String()))
 	return tarball.WriteToFile(path, ref, img)
 }
 
-func writeIndexTarball(path string, ref name.Reference, idx v1.ImageIndex) error {
+func writeIndexTarball(path string, ref name.Reference, idx v1.ImageIndex, log *zap.Logger) error {
 	indexManifest, err := idx.IndexManifest()
 	if err != nil {
 		return fmt.Errorf("reading index manifest: %w", err)
@@ -62,15 +62,15 @@ func writeIndexTarball(path string, ref name.Reference, idx v1.ImageIndex) error
 			refToImage[digestRef] = img
 		}
 	}
-	zap.L().Info("writing multi-arch tarball", zap.String("path", path), zap.Int("images", len(refToImage)))
+	log.Info("writing multi-arch tarball", zap.String("path", path), zap.Int("images", len(refToImage)))
 	return tarball.MultiRefWriteToFile(path, refToImage)
 }
 
-func writeOCI(path string, ref name.Reference, img v1.Image, idx v1.ImageIndex) error {
+func writeOCI(path string, ref name.Reference, img v1.Image, idx v1.ImageIndex, log *zap.Logger) error {
 	if idx != nil {
-		return writeIndexOCI(path, ref, idx)
+		return writeIndexOCI(path, ref, idx, log)
 	}
-	zap.L().Info("writing OCI layout", zap.String("path", path), zap.String("ref", ref.String()))
+	log.Info("writing OCI layout", zap.String("path", path), zap.String("ref", ref.String()))
 	lp, err := layout.Write(path, empty.Index)
 	if err != nil {
 		return fmt.Errorf("creating OCI layout at %s: %w", path, err)
@@ -80,8 +80,8 @@ func writeOCI(path string, ref name.Reference, img v1.Image, idx v1.ImageIndex)
 	}))
 }
 
-func writeIndexOCI(path string, ref name.Reference, idx v1.ImageIndex) error {
-	zap.L().Info("writing OCI layout (index)", zap.String("path", path), zap.String("ref", ref.String()))
+func writeIndexOCI(path string, ref name.Reference, idx v1.ImageIndex, log *zap.Logger) error {
+	log.Info("writing OCI layout (index)", zap.String("path", path), zap.String("ref", ref.String()))
 	lp, err := layout.Write(path, empty.Index)
 	if err != nil {
 		return fmt.Errorf("creating OCI layout at %s: %w", path, err)
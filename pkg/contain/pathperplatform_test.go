@@ -95,7 +95,9 @@ func TestPathPerPlatform_DifferentBytesPerArch(t *testing.T) {
 	cfg.Base = fmt.Sprintf("%s/%s", testRegistry, cfg.Base)
 	cfg.Tag = fmt.Sprintf("%s/%s", testRegistry, cfg.Tag)
 
-	chdir := appender.NewChdir(dir.Root())
+	chdir, err := appender.NewChdir(dir.Root())
+
+	Expect(err).NotTo(HaveOccurred())
 	defer chdir.Cleanup()
 
 	builders, err := contain.RunLayers(cfg)
@@ -148,7 +150,9 @@ func TestPathPerPlatform_FallbackPathCoversUnlistedArch(t *testing.T) {
 	cfg.Base = fmt.Sprintf("%s/%s", testRegistry, cfg.Base)
 	cfg.Tag = fmt.Sprintf("%s/%s", testRegistry, cfg.Tag)
 
-	chdir := appender.NewChdir(dir.Root())
+	chdir, err := appender.NewChdir(dir.Root())
+
+	Expect(err).NotTo(HaveOccurred())
 	defer chdir.Cleanup()
 
 	builders, err := contain.RunLayers(cfg)
@@ -187,7 +191,9 @@ func TestPathPerPlatform_MissingPlatformErrorsBeforeAnyPush(t *testing.T) {
 	cfg.Base = fmt.Sprintf("%s/%s", testRegistry, cfg.Base)
 	cfg.Tag = fmt.Sprintf("%s/%s", testRegistry, cfg.Tag)
 
-	chdir := appender.NewChdir(dir.Root())
+	chdir, err := appender.NewChdir(dir.Root())
+
+	Expect(err).NotTo(HaveOccurred())
 	defer chdir.Cleanup()
 
 	builders, err := contain.RunLayers(cfg)
@@ -1,13 +1,21 @@
 package contain_test
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
 	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	. "github.com/onsi/gomega"
 	"github.com/turbokube/contain/pkg/appender"
 	"github.com/turbokube/contain/pkg/contain"
+	"github.com/turbokube/contain/pkg/pushed"
 	schema "github.com/turbokube/contain/pkg/schema/v1"
 	"github.com/turbokube/contain/pkg/testcases"
 )
@@ -17,22 +25,53 @@ import (
 const platformsBase = pathPerPlatformBase
 
 func platformsTestConfig(t *testing.T, tag string, platforms []string) (schema.ContainConfig, *testcases.TempDir) {
+	t.Helper()
+	cfg, dir := payloadConfig(t, fmt.Sprintf("%s/%s", testRegistry, platformsBase), fmt.Sprintf("%s/%s", testRegistry, tag))
+	cfg.Platforms = platforms
+	return cfg, dir
+}
+
+// payloadConfig appends a payload.txt layer, from a new context dir, to base.
+func payloadConfig(t *testing.T, base string, tag string) (schema.ContainConfig, *testcases.TempDir) {
 	t.Helper()
 	dir := testcases.NewTempDir(t)
 	writeTestFile(t, dir, "payload.txt", "PAYLOAD")
-
-	cfg := schema.ContainConfig{
-		Base:      fmt.Sprintf("%s/%s", testRegistry, platformsBase),
-		Tag:       fmt.Sprintf("%s/%s", testRegistry, tag),
-		Platforms: platforms,
+	return schema.ContainConfig{
+		Base: base,
+		Tag:  tag,
 		Layers: []schema.Layer{{
 			LocalFile: schema.LocalFile{
 				Path:          "payload.txt",
 				ContainerPath: "/payload",
 			},
 		}},
+	}, dir
+}
+
+// buildInDir builds cfg with dir as its context, without a chdir.
+func buildInDir(t *testing.T, cfg schema.ContainConfig, dir *testcases.TempDir, opts contain.WriteOptions) (*pushed.BuildOutput, error) {
+	t.Helper()
+	return contain.Build(context.Background(), cfg, contain.BuildOptions{WriteOptions: opts, Dir: dir.Root()})
+}
+
+// pushPlatformIndex pushes a fresh linux/amd64 + linux/arm64 index and
+// returns it as repo:tag@digest.
+func pushPlatformIndex(t *testing.T, repo string) string {
+	t.Helper()
+	idx := v1.ImageIndex(empty.Index)
+	for _, arch := range []string{"amd64", "arm64"} {
+		p := v1.Platform{OS: "linux", Architecture: arch}
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add:        platformImage(p),
+			Descriptor: v1.Descriptor{MediaType: types.OCIManifestSchema1, Platform: &p},
+		})
 	}
-	return cfg, dir
+	ref, err := name.NewTag(fmt.Sprintf("%s/%s:%s", testRegistry, repo, testcases.RandomHex(8)))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(remote.WriteIndex(ref, idx, testCraneOptions.Remote...)).To(Succeed())
+	digest, err := idx.Digest()
+	Expect(err).NotTo(HaveOccurred())
+	return ref.String() + "@" + digest.String()
 }
 
 // A platform in the config that matched nothing in the base index must fail
@@ -46,7 +85,9 @@ func TestPlatformsRequestedButMissingFromBase(t *testing.T) {
 	cfg, dir := platformsTestConfig(t, "contain-test/platforms:missing-"+testcases.RandomHex(8),
 		[]string{"linux/amd64", "linux/arm64", "linux/s390x"})
 
-	chdir := appender.NewChdir(dir.Root())
+	chdir, err := appender.NewChdir(dir.Root())
+
+	Expect(err).NotTo(HaveOccurred())
 	defer chdir.Cleanup()
 
 	builders, err := contain.RunLayers(cfg)
@@ -69,7 +110,9 @@ func TestPlatformsVariantSpellingMatchesBase(t *testing.T) {
 	cfg, dir := platformsTestConfig(t, "contain-test/platforms:variant",
 		[]string{"linux/amd64", "linux/arm64/v8"})
 
-	chdir := appender.NewChdir(dir.Root())
+	chdir, err := appender.NewChdir(dir.Root())
+
+	Expect(err).NotTo(HaveOccurred())
 	defer chdir.Cleanup()
 
 	builders, err := contain.RunLayers(cfg)
@@ -92,7 +135,9 @@ func TestPlatformsAllRequestedArePresent(t *testing.T) {
 	cfg, dir := platformsTestConfig(t, "contain-test/platforms:complete",
 		[]string{"linux/amd64", "linux/arm64"})
 
-	chdir := appender.NewChdir(dir.Root())
+	chdir, err := appender.NewChdir(dir.Root())
+
+	Expect(err).NotTo(HaveOccurred())
 	defer chdir.Cleanup()
 
 	builders, err := contain.RunLayers(cfg)
@@ -115,7 +160,9 @@ func TestPlatformsSubsetOfBase(t *testing.T) {
 	cfg, dir := platformsTestConfig(t, "contain-test/platforms:subset",
 		[]string{"linux/arm64"})
 
-	chdir := appender.NewChdir(dir.Root())
+	chdir, err := appender.NewChdir(dir.Root())
+
+	Expect(err).NotTo(HaveOccurred())
 	defer chdir.Cleanup()
 
 	builders, err := contain.RunLayers(cfg)
@@ -127,3 +174,101 @@ func TestPlatformsSubsetOfBase(t *testing.T) {
 	Expect(artifact.Platforms).To(HaveLen(1))
 	Expect(artifact.Platforms[0].String()).To(Equal("linux/arm64"))
 }
+
+// platforms: auto builds what the base offers minus what the layer sources
+// on disk cannot serve, where an empty platforms list would fail validation
+// for the platform without a pathPerPlatform entry.
+func TestPlatformsAutoDropsPlatformWithoutSource(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := platformsTestConfig(t, "contain-test/platforms:auto",
+		[]string{schema.PlatformsAuto})
+	writeTestFile(t, dir, "amd64.bin", "AMD64-BODY")
+	cfg.Layers = []schema.Layer{{
+		LocalFile: schema.LocalFile{
+			PathPerPlatform: map[string]string{"linux/amd64": "amd64.bin"},
+			ContainerPath:   "/usr/local/bin/mybinary",
+		},
+	}}
+
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+
+	artifact := out.Artifact()
+	Expect(artifact.Platforms).To(HaveLen(1))
+	Expect(artifact.Platforms[0].String()).To(Equal("linux/amd64"))
+	Expect(artifact.PlatformSelection).NotTo(BeNil())
+	Expect(artifact.PlatformSelection.Mode).To(Equal("auto"))
+	Expect(artifact.PlatformSelection.Dropped).To(HaveLen(1))
+	Expect(artifact.PlatformSelection.Dropped[0].Platform).To(Equal("linux/arm64"))
+}
+
+// An override such as the PLATFORMS env narrows auto, and the platforms it
+// leaves out are reported as dropped.
+func TestPlatformsAutoNarrowedByOverride(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := platformsTestConfig(t, "contain-test/platforms:auto-narrowed",
+		[]string{schema.PlatformsAuto})
+	cfg.Status.Overrides.AutoPlatforms = []string{"linux/arm64"}
+
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+
+	artifact := out.Artifact()
+	Expect(artifact.Platforms).To(HaveLen(1))
+	Expect(artifact.Platforms[0].String()).To(Equal("linux/arm64"))
+	Expect(artifact.PlatformSelection.Dropped).To(HaveLen(1))
+	Expect(artifact.PlatformSelection.Dropped[0].Platform).To(Equal("linux/amd64"))
+	Expect(artifact.PlatformSelection.Dropped[0].Reasons).To(ConsistOf("not in linux/arm64"))
+
+	cfg.Status.Overrides.AutoPlatforms = []string{"linux/s390x"}
+	_, err = buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("linux/s390x"))
+}
+
+// With no source for any base platform auto has nothing to build, and says
+// why per platform.
+func TestPlatformsAutoFailsWhenNothingRemains(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := platformsTestConfig(t, "contain-test/platforms:auto-none",
+		[]string{schema.PlatformsAuto})
+	cfg.Layers = []schema.Layer{{
+		LocalFile: schema.LocalFile{
+			PathPerPlatform: map[string]string{"linux/amd64": "missing.bin"},
+			ContainerPath:   "/usr/local/bin/mybinary",
+		},
+	}}
+
+	_, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("missing.bin not found"))
+	Expect(err.Error()).To(ContainSubstring("linux/arm64"))
+}
+
+// A layer with a platforms selector is appended to the selected children
+// only, and a pathPerPlatform inside it needs no entry for the others.
+func TestPlatformsSelectorOnLayer(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := platformsTestConfig(t, "contain-test/platforms:layer-selector", nil)
+	writeTestFile(t, dir, "agent-amd64.so", "AGENT")
+	cfg.Layers = append(cfg.Layers, schema.Layer{
+		Platforms: []string{"linux/amd64"},
+		LocalFile: schema.LocalFile{
+			PathPerPlatform: map[string]string{"linux/amd64": "agent-amd64.so"},
+			ContainerPath:   "/opt/agent.so",
+		},
+	})
+
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+
+	artifact := out.Artifact()
+	Expect(artifact.Platforms).To(HaveLen(2))
+	Expect(artifact.LayersPerPlatform).To(Equal(map[string]int{
+		"linux/amd64": 2,
+		"linux/arm64": 1,
+	}))
+	ref := artifact.Reference().String()
+	Expect(fileInPlatformManifest(t, ref, v1.Platform{OS: "linux", Architecture: "amd64"}, "/opt/agent.so")).To(Equal("AGENT"))
+	Expect(fileInPlatformManifest(t, ref, v1.Platform{OS: "linux", Architecture: "arm64"}, "/payload")).To(Equal("PAYLOAD"))
+}
@@ -0,0 +1,102 @@
+package contain_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/contain"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+)
+
+// pushSingleBase pushes an OCI image manifest, not an index, whose config
+// says linux/arm64, and returns its digest ref.
+func pushSingleBase(t *testing.T) string {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	Expect(err).NotTo(HaveOccurred())
+	cfg, err := img.ConfigFile()
+	Expect(err).NotTo(HaveOccurred())
+	cfg = cfg.DeepCopy()
+	cfg.OS, cfg.Architecture = "linux", "arm64"
+	img, err = mutate.ConfigFile(img, cfg)
+	Expect(err).NotTo(HaveOccurred())
+	img = mutate.ConfigMediaType(mutate.MediaType(img, types.OCIManifestSchema1), types.OCIConfigJSON)
+	ref, err := name.ParseReference(fmt.Sprintf("%s/contain-test/singlebase:%s", testRegistry, testcases.RandomHex(8)))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(remote.Write(ref, img, testCraneOptions.Remote...)).To(Succeed())
+	digest, err := img.Digest()
+	Expect(err).NotTo(HaveOccurred())
+	return ref.Context().Digest(digest.String()).String()
+}
+
+func singleBaseConfig(t *testing.T, tag string) (schema.ContainConfig, *testcases.TempDir) {
+	t.Helper()
+	dir := testcases.NewTempDir(t)
+	writeTestFile(t, dir, "amd64.bin", "AMD64-BODY")
+	writeTestFile(t, dir, "arm64.bin", "ARM64-BODY")
+	return schema.ContainConfig{
+		Base: pushSingleBase(t),
+		Tag:  fmt.Sprintf("%s/contain-test/singlebase:%s", testRegistry, tag),
+		Layers: []schema.Layer{{
+			LocalFile: schema.LocalFile{
+				PathPerPlatform: map[string]string{
+					"linux/amd64": "amd64.bin",
+					"linux/arm64": "arm64.bin",
+				},
+				ContainerPath: "/usr/local/bin/mybinary",
+			},
+		}},
+	}, dir
+}
+
+func TestSingleManifestBase_Image(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := singleBaseConfig(t, "image")
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+
+	artifact := out.Artifact()
+	Expect(artifact.MediaType).To(Equal(types.OCIManifestSchema1))
+	Expect(artifact.Platforms).To(Equal([]v1.Platform{{OS: "linux", Architecture: "arm64"}}))
+	img, err := remote.Image(artifact.Reference(), testCraneOptions.Remote...)
+	Expect(err).NotTo(HaveOccurred())
+	layers, err := img.Layers()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(layers).To(HaveLen(2))
+	Expect(fileInPlatformManifest(t, cfg.Tag, v1.Platform{OS: "linux", Architecture: "arm64"}, "/usr/local/bin/mybinary")).To(Equal("ARM64-BODY"))
+}
+
+func TestSingleManifestBase_WrapIndex(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := singleBaseConfig(t, "wrapindex")
+	cfg.WrapIndex = true
+	out, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+
+	artifact := out.Artifact()
+	idx, err := remote.Index(artifact.Reference(), testCraneOptions.Remote...)
+	Expect(err).NotTo(HaveOccurred())
+	m, err := idx.IndexManifest()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(m.MediaType).To(Equal(types.OCIImageIndex))
+	Expect(m.Manifests).To(HaveLen(1))
+	Expect(m.Manifests[0].Platform.String()).To(Equal("linux/arm64"))
+}
+
+func TestSingleManifestBase_PlatformsMismatch(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := singleBaseConfig(t, "mismatch")
+	cfg.Platforms = []string{"linux/amd64", "linux/arm64"}
+	_, err := buildInDir(t, cfg, dir, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("linux/amd64"))
+	Expect(err.Error()).To(ContainSubstring("[linux/arm64]"))
+}
@@ -0,0 +1,48 @@
+package contain_test
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/appender"
+	"github.com/turbokube/contain/pkg/contain"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"github.com/turbokube/contain/pkg/testcases"
+)
+
+// maxImageSize counts the base's layers too, so a budget the appended layer
+// alone fits in can still fail, and it must fail before anything is pushed.
+func TestMaxImageSizeFailsBeforePush(t *testing.T) {
+	RegisterTestingT(t)
+	cfg, dir := platformsTestConfig(t, "contain-test/size:budget-"+testcases.RandomHex(8), nil)
+	big := make([]byte, 32*1024)
+	_, err := rand.Read(big)
+	Expect(err).NotTo(HaveOccurred())
+	writeTestFile(t, dir, "big.bin", string(big))
+	cfg.Layers = append(cfg.Layers, schema.Layer{
+		LocalFile: schema.LocalFile{Path: "big.bin", ContainerPath: "/big.bin"},
+	})
+	cfg.MaxImageSize = "16Ki"
+
+	chdir, err := appender.NewChdir(dir.Root())
+
+	Expect(err).NotTo(HaveOccurred())
+	defer chdir.Cleanup()
+
+	builders, err := contain.RunLayers(cfg)
+	Expect(err).NotTo(HaveOccurred())
+	_, err = contain.RunAppend(cfg, builders, contain.WriteOptions{Push: true})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("linux/amd64 image size"))
+	Expect(err.Error()).To(ContainSubstring("exceeds maxImageSize 16Ki"))
+	Expect(err.Error()).To(ContainSubstring("largest appended files: /big.bin 32.0Ki, /payload 7B"))
+
+	_, headErr := crane.Head(cfg.Tag, crane.WithAuth(nil))
+	Expect(headErr).To(HaveOccurred(), "nothing may be pushed over budget")
+
+	cfg.MaxImageSize = "10Mi"
+	_, err = contain.RunAppend(cfg, builders, contain.WriteOptions{Push: true})
+	Expect(err).NotTo(HaveOccurred())
+}
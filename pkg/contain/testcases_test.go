@@ -588,7 +588,9 @@ func TestTestcases(t *testing.T) {
 			c.Base = fmt.Sprintf("%s/%s", testRegistry, c.Base)
 			c.Tag = fmt.Sprintf("%s/%s", testRegistry, c.Tag)
 
-			chdir := appender.NewChdir(dir.Root())
+			chdir, err := appender.NewChdir(dir.Root())
+
+			Expect(err).NotTo(HaveOccurred())
 			defer chdir.Cleanup()
 
 			// result, err := contain.Run(c)
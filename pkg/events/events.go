@@ -0,0 +1,154 @@
+// Package events is a machine-readable stream of what a build or push is
+// doing, written as NDJSON by --events and available to library callers as
+// a callback. Logs stay for humans; events are for dashboards and editors.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Type is what happened
+type Type string
+
+const (
+	// PhaseStart and PhaseEnd bracket a Phase, with Error on failure
+	PhaseStart Type = "phase.start"
+	PhaseEnd   Type = "phase.end"
+	// LayerBuilt is an appended layer, per Platform
+	LayerBuilt Type = "layer.built"
+	// BlobProgress is Complete bytes of a blob upload of Size
+	BlobProgress Type = "blob.progress"
+	// BlobSkip is a blob that wasn't uploaded, see Reason
+	BlobSkip Type = "blob.skip"
+	// BlobUploaded is a blob upload that completed
+	BlobUploaded Type = "blob.uploaded"
+	// ManifestPut is a manifest put to Ref
+	ManifestPut Type = "manifest.put"
+	// DirectpushSession is a direct-to-storage upload session with Parts
+	DirectpushSession Type = "directpush.session"
+	// DirectpushPart is one uploaded Part of a session
+	DirectpushPart Type = "directpush.part"
+	// DirectpushCommit is a committed session
+	DirectpushCommit Type = "directpush.commit"
+)
+
+const (
+	// ReasonExists is a blob the repository already has
+	ReasonExists = "exists"
+	// ReasonMounted is a blob mounted from another repository
+	ReasonMounted = "mounted"
+)
+
+// Event is one line in the stream. Fields are set as they apply to Type.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       Type      `json:"type"`
+	Phase      string    `json:"phase,omitempty"`
+	Repository string    `json:"repository,omitempty"`
+	Ref        string    `json:"ref,omitempty"`
+	Platform   string    `json:"platform,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+	MediaType  string    `json:"mediaType,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Complete   int64     `json:"complete,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Part       int       `json:"part,omitempty"`
+	Parts      int       `json:"parts,omitempty"`
+	DurationMs int64     `json:"durationMs,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Func receives events. It may be called from several goroutines.
+type Func func(Event)
+
+// Emit sends e, with Time set if it isn't, and is a no-op on a nil Func
+func (f Func) Emit(e Event) {
+	if f == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	f(e)
+}
+
+// NDJSON writes each event as a line of JSON to w. A failed write is logged
+// once and later events are dropped, as events must not fail a build.
+func NDJSON(w io.Writer) Func {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	failed := false
+	return func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failed {
+			return
+		}
+		if err := enc.Encode(e); err != nil {
+			zap.L().Warn("events write failed, dropping further events", zap.Error(err))
+			failed = true
+		}
+	}
+}
+
+// Open returns the --events destination: a number is an inherited file
+// descriptor, such as 3 for a pipe that a parent process set up, and anything
+// else a file path that is created or truncated.
+func Open(target string) (io.WriteCloser, error) {
+	if fd, err := strconv.ParseUint(target, 10, 32); err == nil {
+		f := os.NewFile(uintptr(fd), "events-fd-"+target)
+		if f == nil {
+			return nil, fmt.Errorf("events: invalid file descriptor %s", target)
+		}
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("events: file descriptor %s: %w", target, err)
+		}
+		return f, nil
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return nil, fmt.Errorf("events: %w", err)
+	}
+	return f, nil
+}
+
+// Phases emits start and end for one phase at a time
+type Phases struct {
+	emit    Func
+	current string
+	started time.Time
+}
+
+// NewPhases returns Phases for emit, which may be nil
+func NewPhases(emit Func) *Phases {
+	return &Phases{emit: emit}
+}
+
+// Start ends the current phase, if any, and starts phase
+func (p *Phases) Start(phase string) {
+	p.End(nil)
+	p.current = phase
+	p.started = time.Now()
+	p.emit.Emit(Event{Type: PhaseStart, Phase: phase})
+}
+
+// End ends the current phase, if any, with err if not nil
+func (p *Phases) End(err error) {
+	if p.current == "" {
+		return
+	}
+	e := Event{Type: PhaseEnd, Phase: p.current, DurationMs: time.Since(p.started).Milliseconds()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	p.emit.Emit(e)
+	p.current = ""
+}
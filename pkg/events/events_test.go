@@ -0,0 +1,61 @@
+package events_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/events"
+)
+
+func TestNDJSON(t *testing.T) {
+	RegisterTestingT(t)
+	var buf bytes.Buffer
+	emit := events.NDJSON(&buf)
+	phases := events.NewPhases(emit)
+	phases.Start("layers")
+	emit.Emit(events.Event{Type: events.LayerBuilt, Platform: "linux/amd64", Digest: "sha256:a", Size: 3})
+	phases.Start("push")
+	phases.End(errors.New("denied"))
+	phases.End(nil)
+
+	var got []events.Event
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e events.Event
+		Expect(json.Unmarshal(scanner.Bytes(), &e)).To(Succeed())
+		Expect(e.Time.IsZero()).To(BeFalse())
+		got = append(got, e)
+	}
+	Expect(got).To(HaveLen(5))
+	Expect(got[0].Type).To(Equal(events.PhaseStart))
+	Expect(got[1].Digest).To(Equal("sha256:a"))
+	Expect(got[2].Type).To(Equal(events.PhaseEnd))
+	Expect(got[2].Phase).To(Equal("layers"))
+	Expect(got[2].Error).To(BeEmpty())
+	Expect(got[3].Phase).To(Equal("push"))
+	Expect(got[4].Error).To(Equal("denied"))
+
+	// nil is a valid Func
+	events.Func(nil).Emit(events.Event{Type: events.BlobSkip})
+}
+
+func TestOpen(t *testing.T) {
+	RegisterTestingT(t)
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	w, err := events.Open(path)
+	Expect(err).NotTo(HaveOccurred())
+	events.NDJSON(w).Emit(events.Event{Type: events.ManifestPut, Ref: "r/app:1"})
+	Expect(w.Close()).To(Succeed())
+	b, err := os.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(string(b)).To(ContainSubstring(`"type":"manifest.put","ref":"r/app:1"`))
+
+	_, err = events.Open("987")
+	Expect(err).To(MatchError(ContainSubstring("file descriptor 987")))
+}
@@ -0,0 +1,56 @@
+// Package immutable guards tags that must not move once they exist, such as
+// release tags, for every path that puts a manifest by tag.
+package immutable
+
+import (
+	"fmt"
+	"path"
+)
+
+// Tags are glob patterns, see path.Match, for tag names like v1.2.3
+// that contain must not move to another digest. Nil guards nothing.
+type Tags []string
+
+// Validate reports the first malformed pattern
+func (t Tags) Validate() error {
+	for i, pattern := range t {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("immutableTags[%d] %q: %w", i, pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match is true if tag, the name after the colon, is immutable
+func (t Tags) Match(tag string) bool {
+	for _, pattern := range t {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns a ChangedError if tag is immutable and existing, empty for
+// a tag that doesn't exist yet, is not digest
+func (t Tags) Check(ref string, tag string, existing string, digest string) error {
+	if existing == "" || existing == digest || !t.Match(tag) {
+		return nil
+	}
+	return &ChangedError{Ref: ref, Existing: existing, Digest: digest}
+}
+
+// ChangedError is a refusal to move an immutable tag
+type ChangedError struct {
+	// Ref is the tag reference
+	Ref string
+	// Existing is the digest the tag points to
+	Existing string
+	// Digest is the digest that would have replaced it
+	Digest string
+}
+
+func (e *ChangedError) Error() string {
+	return fmt.Sprintf("immutable tag %s exists with digest %s, refusing to change it to %s (use --force to override)",
+		e.Ref, e.Existing, e.Digest)
+}
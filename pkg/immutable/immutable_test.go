@@ -0,0 +1,29 @@
+package immutable_test
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/turbokube/contain/pkg/immutable"
+)
+
+func TestCheck(t *testing.T) {
+	RegisterTestingT(t)
+	tags := immutable.Tags{"v*", "release-[0-9]*"}
+	Expect(tags.Validate()).To(Succeed())
+	Expect(tags.Match("v1.2.3")).To(BeTrue())
+	Expect(tags.Match("release-2")).To(BeTrue())
+	Expect(tags.Match("latest")).To(BeFalse())
+
+	Expect(tags.Check("r/app:v1", "v1", "", "sha256:b")).To(Succeed(), "new tag")
+	Expect(tags.Check("r/app:v1", "v1", "sha256:b", "sha256:b")).To(Succeed(), "same digest")
+	Expect(tags.Check("r/app:latest", "latest", "sha256:a", "sha256:b")).To(Succeed(), "mutable")
+	err := tags.Check("r/app:v1", "v1", "sha256:a", "sha256:b")
+	var changed *immutable.ChangedError
+	Expect(errors.As(err, &changed)).To(BeTrue())
+	Expect(err.Error()).To(Equal("immutable tag r/app:v1 exists with digest sha256:a, refusing to change it to sha256:b (use --force to override)"))
+
+	Expect(immutable.Tags(nil).Check("r/app:v1", "v1", "sha256:a", "sha256:b")).To(Succeed())
+	Expect(immutable.Tags{"v["}.Validate()).To(MatchError(ContainSubstring(`immutableTags[0] "v["`)))
+}
@@ -0,0 +1,90 @@
+package layers
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/turbokube/contain/pkg/localdir"
+)
+
+// largestFilesListed is how many files a size budget error names.
+const largestFilesListed = 5
+
+// FileSize is a regular file in a layer with its uncompressed size.
+type FileSize struct {
+	Path string
+	Size int64
+}
+
+// LargestFiles returns the n largest regular files in layers, largest first,
+// for pointing at what to trim when a size budget is exceeded.
+func LargestFiles(layers []v1.Layer, n int) ([]FileSize, error) {
+	var files []FileSize
+	for _, layer := range layers {
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(rc)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				rc.Close() //nolint:errcheck
+				return nil, err
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				files = append(files, FileSize{Path: hdr.Name, Size: hdr.Size})
+			}
+		}
+		if err := rc.Close(); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Size > files[j].Size
+	})
+	if len(files) > n {
+		files = files[:n]
+	}
+	return files, nil
+}
+
+// DescribeLargestFiles is LargestFiles formatted for an error message.
+func DescribeLargestFiles(layers []v1.Layer) string {
+	files, err := LargestFiles(layers, largestFilesListed)
+	if err != nil {
+		return fmt.Sprintf("(failed to list files: %v)", err)
+	}
+	items := make([]string, len(files))
+	for i, f := range files {
+		items[i] = fmt.Sprintf("%s %s", f.Path, localdir.FormatSize(f.Size))
+	}
+	return strings.Join(items, ", ")
+}
+
+// withMaxCompressedSize fails the build of any layer whose compressed size,
+// the size it is pushed and pulled with, exceeds max.
+func withMaxCompressedSize(b LayerBuilder, max int64, config string) LayerBuilder {
+	return func(platform v1.Platform) (v1.Layer, error) {
+		layer, err := b(platform)
+		if err != nil || layer == nil {
+			return layer, err
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return nil, err
+		}
+		if size > max {
+			return nil, fmt.Errorf("compressed size %s exceeds maxCompressedSize %s, largest files: %s",
+				localdir.FormatSize(size), config, DescribeLargestFiles([]v1.Layer{layer}))
+		}
+		return layer, nil
+	}
+}
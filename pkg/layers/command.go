@@ -0,0 +1,262 @@
+package layers
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/turbokube/contain/pkg/localdir"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultCommandTimeout is a command layer's timeout if it has none
+	DefaultCommandTimeout = 10 * time.Minute
+	// commandWaitDelay is how long a timed out command's output may stay
+	// open, for example by a child process, after the command is killed
+	commandWaitDelay = 5 * time.Second
+	// commandErrorLines is how many of the last stderr lines a failed
+	// command's error includes
+	commandErrorLines = 20
+)
+
+// newCommandBuilder returns a builder that runs the command for the
+// requested platform and normalizes its output into a layer. Like goBuild
+// the zero platform, from sync, is linux on the host's architecture.
+func newCommandBuilder(cfg schema.Command, attributes schema.LayerAttributes, opts Options) LayerBuilder {
+	return func(platform v1.Platform) (v1.Layer, error) {
+		if platform.OS == "" {
+			platform = v1.Platform{OS: "linux", Architecture: runtime.GOARCH}
+		}
+		tmp, err := os.MkdirTemp("", "contain-command-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp) //nolint:errcheck
+		out := filepath.Join(tmp, "output")
+		if cfg.Output != schema.CommandOutputTar {
+			if err := os.Mkdir(out, 0o755); err != nil {
+				return nil, err
+			}
+		}
+		if err := runCommand(opts.context(), cfg, platform, out, opts.ContextDir, opts.logger()); err != nil {
+			return nil, err
+		}
+		if cfg.Output == schema.CommandOutputTar {
+			return commandTarLayer(cfg, out, attributes, opts.logger())
+		}
+		b, err := configure(localdir.NewDir(), schema.LocalDir{
+			Path:          out,
+			ContainerPath: cfg.ContainerPath,
+		}, attributes, opts)
+		if err != nil {
+			return nil, err
+		}
+		return b(platform)
+	}
+}
+
+// runCommand runs cfg for platform with output as CONTAIN_OUTPUT and
+// contextDir, the build's, as CONTAIN_CONTEXT_DIR. The command runs in
+// cfg.Dir, default contextDir. Stderr lines are logged at info level and
+// stdout lines at debug, as they arrive. A non-zero exit is an error with
+// the exit code and the last stderr lines, and the command is killed at the
+// timeout or when ctx is done.
+func runCommand(ctx context.Context, cfg schema.Command, platform v1.Platform, output, contextDir string, log *zap.Logger) error {
+	timeout := DefaultCommandTimeout
+	if cfg.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return fmt.Errorf("command timeout: %w", err)
+		}
+	}
+	contextDir, err := filepath.Abs(contextDir)
+	if err != nil {
+		return err
+	}
+	dir := contextDir
+	if cfg.Dir != "" {
+		if dir, err = filepath.Abs(cfg.Dir); err != nil {
+			return err
+		}
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(cmdCtx, cfg.Path, cfg.Args...)
+	cmd.Dir = dir
+	cmd.WaitDelay = commandWaitDelay
+	cmd.Env = os.Environ()
+	for _, e := range cfg.Env {
+		cmd.Env = append(cmd.Env, e.Name+"="+e.Value)
+	}
+	cmd.Env = append(cmd.Env,
+		"CONTAIN_PLATFORM="+platform.String(),
+		"CONTAIN_OUTPUT="+output,
+		"CONTAIN_CONTEXT_DIR="+contextDir,
+	)
+	log = log.With(zap.String("command", cfg.Path), zap.String("platform", platform.String()))
+	stdout := &lineLogger{log: func(line string) { log.Debug("command stdout", zap.String("line", line)) }}
+	stderr := &lineLogger{log: func(line string) { log.Info("command stderr", zap.String("line", line)) }, keep: commandErrorLines}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	log.Info("command", zap.Strings("args", cfg.Args), zap.String("dir", dir), zap.Duration("timeout", timeout))
+	start := time.Now()
+	err = cmd.Run()
+	stdout.flush()
+	stderr.flush()
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("command %s for %s: %w", cfg.Path, platform.String(), err)
+	}
+	if errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("command %s for %s timed out after %s", cfg.Path, platform.String(), timeout)
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return fmt.Errorf("command %s for %s exited with code %d\n%s", cfg.Path, platform.String(), exit.ExitCode(), strings.Join(stderr.tail, "\n"))
+	}
+	if err != nil {
+		return fmt.Errorf("command %s for %s: %w", cfg.Path, platform.String(), err)
+	}
+	log.Info("command done", zap.Duration("duration", time.Since(start)))
+	return nil
+}
+
+// lineLogger passes each complete line written to it to log, keeping the
+// last keep lines.
+type lineLogger struct {
+	mu      sync.Mutex
+	log     func(line string)
+	keep    int
+	partial []byte
+	tail    []string
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.line(string(l.partial[:i]))
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (l *lineLogger) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.partial) > 0 {
+		l.line(string(l.partial))
+		l.partial = nil
+	}
+}
+
+func (l *lineLogger) line(s string) {
+	s = strings.TrimRight(s, "\r")
+	l.log(s)
+	if l.keep == 0 {
+		return
+	}
+	l.tail = append(l.tail, s)
+	if len(l.tail) > l.keep {
+		l.tail = l.tail[1:]
+	}
+}
+
+// commandTarLayer reads the tar a command wrote and builds the layer from
+// its entries' paths, contents, executable bits and in-tree symlinks only,
+// the same as for a directory. A hardlink gets a copy of the regular file
+// it links to, which must be an earlier entry. A path that occurs more than
+// once gets its last entry's content, as it would when extracted.
+func commandTarLayer(cfg schema.Command, file string, attributes schema.LayerAttributes, log *zap.Logger) (v1.Layer, error) {
+	mapper := localdir.NewPathMapperAsIs()
+	if cfg.ContainerPath != "" {
+		var err error
+		mapper, err = localdir.NewPathMapperPrepend(cfg.ContainerPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("command %s wrote no tar: %w", cfg.Path, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	index := map[string]int{}
+	var files []localdir.FileInfo
+	r := tar.NewReader(f)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("command %s output tar: %w", cfg.Path, err)
+		}
+		name := path.Clean(strings.TrimPrefix(h.Name, "/"))
+		if name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("command %s output tar: path outside the layer %q", cfg.Path, h.Name)
+		}
+		info := localdir.FileInfo{Path: mapper(name), Mode: os.FileMode(h.Mode).Perm()}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			info.IsDir = true
+		case tar.TypeReg:
+			info.Content, err = io.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("command %s output tar: %w", cfg.Path, err)
+			}
+		case tar.TypeSymlink:
+			target := path.Clean(path.Join(path.Dir(name), h.Linkname))
+			if path.IsAbs(h.Linkname) || target == ".." || strings.HasPrefix(target, "../") {
+				log.Warn("skipping symlink pointing outside source tree",
+					zap.String("path", name),
+					zap.String("target", h.Linkname),
+				)
+				continue
+			}
+			info.IsSymlink = true
+			info.LinkTarget = h.Linkname
+		case tar.TypeLink:
+			i, ok := index[mapper(path.Clean(strings.TrimPrefix(h.Linkname, "/")))]
+			if !ok || files[i].IsDir || files[i].IsSymlink {
+				return nil, fmt.Errorf("command %s output tar: hardlink %s to %s, which is not an earlier regular file", cfg.Path, h.Name, h.Linkname)
+			}
+			info.Content, info.Mode = files[i].Content, files[i].Mode
+		default:
+			return nil, fmt.Errorf("command %s output tar: unsupported entry type %q for %s", cfg.Path, h.Typeflag, h.Name)
+		}
+		if i, ok := index[info.Path]; ok {
+			files[i] = info
+			continue
+		}
+		index[info.Path] = len(files)
+		files = append(files, info)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("command %s output tar is empty", cfg.Path)
+	}
+	log.Info("layer buffer created", zap.Int("files", len(files)))
+	return localdir.LayerFromFiles(files, attributes)
+}
@@ -0,0 +1,223 @@
+package layers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+func writeScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte("#!/bin/sh\nset -e\n"+body), 0o755); err != nil {
+		t.Fatalf("write %s: %v", p, err)
+	}
+	return p
+}
+
+func commandLayer(t *testing.T, cfg schema.Command, platform v1.Platform) (v1.Layer, error) {
+	t.Helper()
+	b, err := NewLayerBuilder(schema.Layer{Command: cfg}, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilder: %v", err)
+	}
+	return b(platform)
+}
+
+func TestCommand_Reproducible(t *testing.T) {
+	dir := t.TempDir()
+	// each run differs in mtimes, permissions and write order, not content
+	writeScript(t, dir, "gen.sh", `
+n=$(cat runs 2>/dev/null || echo 0)
+n=$((n+1))
+echo $n > runs
+echo "generating for $CONTAIN_PLATFORM" >&2
+cd "$CONTAIN_OUTPUT"
+mkdir -p bin
+if [ $((n%2)) -eq 0 ]; then
+  echo "$CONTAIN_PLATFORM" > platform; printf '#!/bin/sh\n' > bin/run
+  chmod 600 platform; chmod 700 bin/run
+  touch -t 200101010000 platform bin/run bin
+else
+  printf '#!/bin/sh\n' > bin/run; echo "$CONTAIN_PLATFORM" > platform
+  chmod 755 bin/run
+  touch -t 202001010000 platform bin/run bin
+fi
+`)
+	cfg := schema.Command{Path: "./gen.sh", Dir: dir, ContainerPath: "/app"}
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64"}
+
+	first, err := commandLayer(t, cfg, arm64)
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	second, err := commandLayer(t, cfg, arm64)
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	d1, _ := first.Digest()
+	d2, _ := second.Digest()
+	if d1 != d2 {
+		t.Errorf("digests differ for a reproducible command: %s and %s", d1, d2)
+	}
+	files := layerFiles(t, first)
+	if files["/app/platform"] != "linux/arm64\n" {
+		t.Errorf("platform file %q, files %v", files["/app/platform"], files)
+	}
+	if _, ok := files["/app/bin/run"]; !ok {
+		t.Errorf("no /app/bin/run in %v", files)
+	}
+
+	amd64, err := commandLayer(t, cfg, v1.Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatalf("amd64: %v", err)
+	}
+	if d3, _ := amd64.Digest(); d3 == d1 {
+		t.Errorf("amd64 layer has arm64's digest %s", d1)
+	}
+}
+
+func TestCommand_TarOutput(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "gen.sh", `
+src=$(mktemp -d)
+echo one > "$src/a.txt"
+mkdir "$src/sub"
+echo two > "$src/sub/b.txt"
+ln -s ../a.txt "$src/sub/link"
+ln -s /etc/passwd "$src/outside"
+ln "$src/a.txt" "$src/sub/hard"
+tar -cf "$CONTAIN_OUTPUT" -C "$src" --mtime=@$(date +%s) .
+rm -rf "$src"
+`)
+	cfg := schema.Command{Path: "./gen.sh", Dir: dir, Output: schema.CommandOutputTar, ContainerPath: "/data"}
+	layer, err := commandLayer(t, cfg, v1.Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	files := layerFiles(t, layer)
+	if files["/data/a.txt"] != "one\n" || files["/data/sub/b.txt"] != "two\n" {
+		t.Errorf("unexpected files %v", files)
+	}
+	if files["/data/sub/hard"] != "one\n" {
+		t.Errorf("hardlink should be a copy: %v", files)
+	}
+	if _, ok := files["/data/sub/link"]; !ok {
+		t.Errorf("in-tree symlink dropped: %v", files)
+	}
+	if _, ok := files["/data/outside"]; ok {
+		t.Errorf("symlink outside the tree kept: %v", files)
+	}
+
+	again, err := commandLayer(t, cfg, v1.Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatalf("second build: %v", err)
+	}
+	d1, _ := layer.Digest()
+	d2, _ := again.Digest()
+	if d1 != d2 {
+		t.Errorf("digests differ: %s and %s", d1, d2)
+	}
+}
+
+func TestCommand_ContextDir(t *testing.T) {
+	contextDir := t.TempDir()
+	dir := filepath.Join(contextDir, "tools")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeScript(t, dir, "gen.sh", `
+test "$CONTAIN_CONTEXT_DIR" = "`+contextDir+`"
+test "$(pwd)" = "`+dir+`"
+echo ok > "$CONTAIN_OUTPUT/ok"
+`)
+	b, err := NewLayerBuilder(schema.Layer{Command: schema.Command{Path: "./gen.sh", Dir: dir}}, Options{ContextDir: contextDir})
+	if err != nil {
+		t.Fatalf("NewLayerBuilder: %v", err)
+	}
+	if _, err := b(amd64()); err != nil {
+		t.Errorf("CONTAIN_CONTEXT_DIR should be the context dir and the command run in dir: %v", err)
+	}
+}
+
+func TestCommand_TarHardlinkOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "gen.sh", `
+src=$(mktemp -d)
+echo one > "$src/a.txt"
+ln "$src/a.txt" "$src/b.txt"
+tar -cf "$CONTAIN_OUTPUT" -C "$src" ./b.txt ./a.txt
+rm -rf "$src"
+`)
+	layer, err := commandLayer(t, schema.Command{Path: "./gen.sh", Dir: dir, Output: schema.CommandOutputTar}, amd64())
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	files := layerFiles(t, layer)
+	if files["a.txt"] != "one\n" || files["b.txt"] != "one\n" {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestCommand_ExitCode(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "fail.sh", `
+echo "progress"
+echo "something broke" >&2
+exit 3
+`)
+	_, err := commandLayer(t, schema.Command{Path: "./fail.sh", Dir: dir}, v1.Platform{OS: "linux", Architecture: "amd64"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "exited with code 3") || !strings.Contains(err.Error(), "something broke") {
+		t.Errorf("error %q", err)
+	}
+	if strings.Contains(err.Error(), "progress") {
+		t.Errorf("stdout in error %q", err)
+	}
+}
+
+func TestCommand_Timeout(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "slow.sh", "exec sleep 30\n")
+	_, err := commandLayer(t, schema.Command{Path: "./slow.sh", Dir: dir, Timeout: "200ms"}, v1.Platform{OS: "linux", Architecture: "amd64"})
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("error %v", err)
+	}
+}
+
+func TestCommand_NoTar(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "noop.sh", "true\n")
+	_, err := commandLayer(t, schema.Command{Path: "./noop.sh", Dir: dir, Output: schema.CommandOutputTar}, v1.Platform{OS: "linux", Architecture: "amd64"})
+	if err == nil || !strings.Contains(err.Error(), "wrote no tar") {
+		t.Errorf("error %v", err)
+	}
+}
+
+func TestCommand_Canceled(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "slow.sh", "exec sleep 30\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	b, err := NewLayerBuilder(schema.Layer{Command: schema.Command{Path: "./slow.sh", Dir: dir}}, Options{Context: ctx})
+	if err != nil {
+		t.Fatalf("NewLayerBuilder: %v", err)
+	}
+	start := time.Now()
+	_, err = b(v1.Platform{OS: "linux", Architecture: "amd64"})
+	if !errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected the build's deadline, got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("command ran on after the build's context was done")
+	}
+}
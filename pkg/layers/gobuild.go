@@ -0,0 +1,150 @@
+package layers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/turbokube/contain/pkg/localdir"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+// newGoBuildBuilder returns a builder that compiles the package for the
+// requested platform into a temp dir and appends the binary as the layer's
+// only file. The zero platform, from sync, builds for linux on the host's
+// architecture.
+func newGoBuildBuilder(cfg schema.GoBuild, attributes schema.LayerAttributes, opts Options) LayerBuilder {
+	return func(platform v1.Platform) (v1.Layer, error) {
+		if platform.OS == "" {
+			platform = v1.Platform{OS: "linux", Architecture: runtime.GOARCH}
+		}
+		tmp, err := os.MkdirTemp("", "contain-gobuild-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp) //nolint:errcheck
+		out := filepath.Join(tmp, filepath.Base(cfg.ContainerPath))
+		if err := goBuild(opts.context(), cfg, platform, out, opts.logger()); err != nil {
+			return nil, err
+		}
+		return localdir.FromFileMappingsContext(opts.context(), []localdir.FileMapping{
+			{Src: out, Dst: cfg.ContainerPath},
+		}, attributes, opts.logger())
+	}
+}
+
+func goBuild(ctx context.Context, cfg schema.GoBuild, platform v1.Platform, out string, log *zap.Logger) error {
+	env, err := goBuildEnv(cfg, platform)
+	if err != nil {
+		return err
+	}
+	args, err := goBuildArgs(cfg, out)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = append(os.Environ(), env...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	log.Info("go build",
+		zap.String("package", cfg.Package),
+		zap.String("platform", platform.String()),
+		zap.Strings("env", env),
+		zap.Strings("args", args),
+	)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("goBuild %s for %s: %w\n%s", cfg.Package, platform.String(), err, strings.TrimSpace(output.String()))
+	}
+	if output.Len() > 0 {
+		log.Debug("go build output", zap.String("package", cfg.Package), zap.String("output", output.String()))
+	}
+	return nil
+}
+
+// goBuildArgs always empties the build ID, which otherwise differs with the
+// toolchain's cache state, so that the binary depends on sources and flags
+// only.
+func goBuildArgs(cfg schema.GoBuild, out string) ([]string, error) {
+	args := []string{"build", "-o", out}
+	if cfg.Trimpath == nil || *cfg.Trimpath {
+		args = append(args, "-trimpath")
+	}
+	if len(cfg.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(cfg.Tags, ","))
+	}
+	ldflags := make([]string, 0, len(cfg.Ldflags)+1)
+	for i, f := range cfg.Ldflags {
+		q, err := quoteLdflag(f)
+		if err != nil {
+			return nil, fmt.Errorf("goBuild ldflags[%d]: %w", i, err)
+		}
+		ldflags = append(ldflags, q)
+	}
+	ldflags = append(ldflags, "-buildid=")
+	args = append(args, "-ldflags", strings.Join(ldflags, " "))
+	return append(args, cfg.Package), nil
+}
+
+// quoteLdflag quotes an ldflags item for go's flag splitting, which only
+// understands quotes around a whole field. An item is a flag, optionally
+// followed by a space and its value, such as -X main.msg=hello world, or a
+// value on its own, and a value with whitespace is quoted.
+func quoteLdflag(f string) (string, error) {
+	flag, value := "", f
+	if strings.HasPrefix(f, "-") {
+		if i := strings.IndexAny(f, " \t\n\r"); i > 0 {
+			flag, value = f[:i+1], strings.TrimLeft(f[i:], " \t\n\r")
+		} else {
+			return f, nil
+		}
+	}
+	if !strings.ContainsAny(value, " \t\n\r") && !strings.HasPrefix(value, "'") && !strings.HasPrefix(value, "\"") {
+		return flag + value, nil
+	}
+	switch {
+	case !strings.Contains(value, "'"):
+		return flag + "'" + value + "'", nil
+	case !strings.Contains(value, "\""):
+		return flag + "\"" + value + "\"", nil
+	}
+	return "", fmt.Errorf("%q has whitespace and both quote characters", f)
+}
+
+// goBuildEnv maps the platform, including its variant, to the toolchain's
+// target environment.
+func goBuildEnv(cfg schema.GoBuild, platform v1.Platform) ([]string, error) {
+	cgo := "0"
+	if cfg.CgoEnabled {
+		cgo = "1"
+	}
+	env := []string{
+		"GOOS=" + platform.OS,
+		"GOARCH=" + platform.Architecture,
+		"CGO_ENABLED=" + cgo,
+	}
+	if platform.Variant == "" {
+		return env, nil
+	}
+	switch platform.Architecture {
+	case "arm":
+		return append(env, "GOARM="+strings.TrimPrefix(platform.Variant, "v")), nil
+	case "amd64":
+		return append(env, "GOAMD64="+platform.Variant), nil
+	case "arm64":
+		variant := platform.Variant
+		if !strings.Contains(variant, ".") {
+			variant = variant + ".0"
+		}
+		return append(env, "GOARM64="+variant), nil
+	}
+	return nil, fmt.Errorf("goBuild: no Go setting for variant %s of %s", platform.Variant, platform.Architecture)
+}
@@ -0,0 +1,143 @@
+package layers
+
+import (
+	"archive/tar"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+func TestGoBuildEnv(t *testing.T) {
+	for _, c := range []struct {
+		platform v1.Platform
+		expected string
+	}{
+		{v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, "GOARM=7"},
+		{v1.Platform{OS: "linux", Architecture: "amd64", Variant: "v3"}, "GOAMD64=v3"},
+		{v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, "GOARM64=v8.0"},
+	} {
+		env, err := goBuildEnv(schema.GoBuild{}, c.platform)
+		if err != nil {
+			t.Fatalf("%s: %v", c.platform.String(), err)
+		}
+		if len(env) != 4 || env[3] != c.expected {
+			t.Errorf("%s: got %v, expected %s", c.platform.String(), env, c.expected)
+		}
+	}
+	env, _ := goBuildEnv(schema.GoBuild{CgoEnabled: true}, amd64())
+	if env[2] != "CGO_ENABLED=1" {
+		t.Errorf("cgo: got %v", env)
+	}
+}
+
+func TestNewLayerBuilder_GoBuildPerPlatform(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "go.mod", "module example.com/hello\n\ngo 1.22\n")
+	writeFile(t, dir, "main.go", "package main\n\nfunc main() { println(\"hello\") }\n")
+	t.Chdir(dir)
+
+	b, err := NewLayerBuilder(schema.Layer{GoBuild: schema.GoBuild{
+		Package:       ".",
+		ContainerPath: "/app/hello",
+		Ldflags:       []string{"-s", "-w"},
+	}}, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilder: %v", err)
+	}
+	machines := map[string]elf.Machine{"amd64": elf.EM_X86_64, "arm64": elf.EM_AARCH64}
+	for _, p := range []v1.Platform{amd64(), arm64()} {
+		layer, err := b(p)
+		if err != nil {
+			t.Fatalf("%s: %v", p.String(), err)
+		}
+		again, err := b(p)
+		if err != nil {
+			t.Fatalf("%s: %v", p.String(), err)
+		}
+		d1, _ := layer.Digest()
+		d2, _ := again.Digest()
+		if d1 != d2 {
+			t.Errorf("%s: rebuild changed the layer %s %s", p.String(), d1, d2)
+		}
+
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(rc)
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != "/app/hello" || hdr.Mode != 0755 {
+			t.Errorf("%s: got %s mode %o", p.String(), hdr.Name, hdr.Mode)
+		}
+		bin := filepath.Join(t.TempDir(), "hello")
+		f, err := os.Create(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.ReadFrom(tr); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		rc.Close()
+		e, err := elf.Open(bin)
+		if err != nil {
+			t.Fatalf("%s: %v", p.String(), err)
+		}
+		if e.Machine != machines[p.Architecture] {
+			t.Errorf("%s: binary is for %s", p.String(), e.Machine)
+		}
+		e.Close()
+	}
+}
+
+func TestQuoteLdflag(t *testing.T) {
+	for _, c := range []struct{ in, out string }{
+		{"-s", "-s"},
+		{"-X main.version=1.0.0", "-X main.version=1.0.0"},
+		{"-X main.msg=hello world", "-X 'main.msg=hello world'"},
+		{"-X main.msg=it's here", `-X "main.msg=it's here"`},
+		{"main.msg=hello world", "'main.msg=hello world'"},
+		{"-extldflags=-static", "-extldflags=-static"},
+	} {
+		got, err := quoteLdflag(c.in)
+		if err != nil {
+			t.Errorf("%s: %v", c.in, err)
+		} else if got != c.out {
+			t.Errorf("%s: got %s, expected %s", c.in, got, c.out)
+		}
+	}
+	if _, err := quoteLdflag(`-X main.msg=it's "here"`); err == nil {
+		t.Error("expected an error for both quote characters")
+	}
+}
+
+func TestNewLayerBuilder_GoBuildLdflagsWithSpaces(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "go.mod", "module example.com/hello\n\ngo 1.22\n")
+	writeFile(t, dir, "main.go", "package main\n\nvar msg string\n\nfunc main() { println(msg) }\n")
+	t.Chdir(dir)
+
+	b, err := NewLayerBuilder(schema.Layer{GoBuild: schema.GoBuild{
+		Package:       ".",
+		ContainerPath: "/app/hello",
+		Ldflags:       []string{"-s", "-X main.msg=hello spaced world"},
+	}}, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilder: %v", err)
+	}
+	layer, err := b(amd64())
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !strings.Contains(layerFiles(t, layer)["/app/hello"], "hello spaced world") {
+		t.Error("binary lacks the -X value with spaces")
+	}
+}
@@ -0,0 +1,366 @@
+package layers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/turbokube/contain/pkg/localdir"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+const javaAppDefaultContainerPath = "/app"
+
+// javaApp layers, in the order they are appended: least to most frequently
+// changed
+const (
+	javaReleaseDependencies = iota
+	javaSnapshotDependencies
+	javaResources
+	javaClasses
+	javaLayerCount
+)
+
+var javaLayerNames = [javaLayerCount]string{
+	"dependencies", "snapshot dependencies", "resources", "classes",
+}
+
+// javaApp reads the application once, on the first builder invocation, as
+// the split does not depend on platform.
+type javaApp struct {
+	cfg           schema.JavaApp
+	containerPath string
+	opts          Options
+	once          sync.Once
+	layers        [javaLayerCount][]localdir.FileInfo
+	err           error
+}
+
+// newJavaAppBuilders returns one builder per javaApp layer. A builder for a
+// layer that ends up empty, typically snapshot dependencies, returns nil.
+func newJavaAppBuilders(cfg schema.JavaApp, attributes schema.LayerAttributes, opts Options) []LayerBuilder {
+	app := &javaApp{cfg: cfg, containerPath: javaAppContainerPath(cfg), opts: opts}
+	builders := make([]LayerBuilder, javaLayerCount)
+	for i := range builders {
+		builders[i] = func(_ v1.Platform) (v1.Layer, error) {
+			app.once.Do(app.load)
+			if app.err != nil {
+				return nil, app.err
+			}
+			if len(app.layers[i]) == 0 {
+				opts.logger().Debug("javaApp layer empty", zap.String("layer", javaLayerNames[i]))
+				return nil, nil
+			}
+			files := make([]localdir.FileInfo, len(app.layers[i]))
+			copy(files, app.layers[i])
+			return localdir.LayerFromFiles(files, attributes)
+		}
+	}
+	return builders
+}
+
+func javaAppContainerPath(cfg schema.JavaApp) string {
+	if cfg.ContainerPath == "" {
+		return javaAppDefaultContainerPath
+	}
+	return strings.TrimSuffix(cfg.ContainerPath, "/")
+}
+
+func (j *javaApp) load() {
+	if j.cfg.Jar != "" {
+		j.err = j.loadJar(j.cfg.Jar)
+	} else {
+		j.err = j.loadDir(j.cfg.Dir)
+	}
+	if j.err != nil {
+		j.err = fmt.Errorf("javaApp: %w", j.err)
+		return
+	}
+	for i, files := range j.layers {
+		j.opts.logger().Info("javaApp layer",
+			zap.String("layer", javaLayerNames[i]),
+			zap.Int("files", len(files)),
+		)
+	}
+}
+
+// loadJar unpacks a fat jar: a Spring Boot executable jar, recognized by
+// its BOOT-INF/ entries, or a shaded jar whose dependencies are already
+// merged into its classes.
+func (j *javaApp) loadJar(jar string) error {
+	r, err := zip.OpenReader(jar)
+	if err != nil {
+		return err
+	}
+	defer r.Close() //nolint:errcheck
+	boot := false
+	for _, f := range r.File {
+		if strings.HasPrefix(f.Name, "BOOT-INF/") {
+			boot = true
+			break
+		}
+	}
+	for _, f := range r.File {
+		if err := j.opts.context().Err(); err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		content, err := readZipFile(f)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case boot && strings.HasPrefix(f.Name, "BOOT-INF/lib/"):
+			err = j.addDependency(path.Base(f.Name), content, mode)
+		case boot && strings.HasPrefix(f.Name, "BOOT-INF/classes/"):
+			err = j.addClasspathFile(strings.TrimPrefix(f.Name, "BOOT-INF/classes/"), content, mode)
+		case boot:
+			// the launcher, the jar manifest and index files are not needed
+			// to start Start-Class from a plain classpath
+		case f.Name == "META-INF/MANIFEST.MF":
+		default:
+			err = j.addClasspathFile(f.Name, content, mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close() //nolint:errcheck
+	return io.ReadAll(rc)
+}
+
+// loadDir reads an exploded Spring Boot jar or a Maven or Gradle output dir.
+func (j *javaApp) loadDir(dir string) error {
+	classRoots, dependencyDirs := javaDirLayout(dir)
+	if len(classRoots) == 0 && len(dependencyDirs) == 0 {
+		return fmt.Errorf("no BOOT-INF, classes or dependency dir found in %s", dir)
+	}
+	for _, d := range dependencyDirs {
+		entries, err := os.ReadDir(d)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".jar") {
+				continue
+			}
+			content, mode, err := readJavaFile(filepath.Join(d, e.Name()))
+			if err != nil {
+				return err
+			}
+			if err := j.addDependency(e.Name(), content, mode); err != nil {
+				return err
+			}
+		}
+	}
+	for _, root := range classRoots {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			if err := j.opts.context().Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			content, mode, err := readJavaFile(p)
+			if err != nil {
+				return err
+			}
+			return j.addClasspathFile(filepath.ToSlash(rel), content, mode)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// javaDirLayout returns the classpath roots and the dirs of dependency jars
+// that exist in dir.
+func javaDirLayout(dir string) (classRoots []string, dependencyDirs []string) {
+	existing := func(candidates ...string) []string {
+		var found []string
+		for _, c := range candidates {
+			p := filepath.Join(dir, filepath.FromSlash(c))
+			if info, err := os.Stat(p); err == nil && info.IsDir() {
+				found = append(found, p)
+			}
+		}
+		return found
+	}
+	if boot := existing("BOOT-INF"); len(boot) > 0 {
+		return existing("BOOT-INF/classes"), existing("BOOT-INF/lib")
+	}
+	dependencyDirs = existing("dependency", "lib")
+	if gradle := existing("classes/java/main", "classes/kotlin/main", "classes/scala/main", "classes/groovy/main"); len(gradle) > 0 {
+		return append(gradle, existing("resources/main")...), dependencyDirs
+	}
+	return existing("classes"), dependencyDirs
+}
+
+func readJavaFile(p string) ([]byte, os.FileMode, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	content, err := os.ReadFile(p)
+	return content, info.Mode(), err
+}
+
+// addDependency sorts a jar by version: a SNAPSHOT can change without its
+// name changing, so it gets a layer of its own.
+func (j *javaApp) addDependency(name string, content []byte, mode os.FileMode) error {
+	layer := javaReleaseDependencies
+	if strings.Contains(name, "-SNAPSHOT") {
+		layer = javaSnapshotDependencies
+	}
+	to := path.Join(j.containerPath, "libs", name)
+	for _, l := range []int{javaReleaseDependencies, javaSnapshotDependencies} {
+		for _, f := range j.layers[l] {
+			if f.Path == to {
+				return fmt.Errorf("dependency %s found twice", name)
+			}
+		}
+	}
+	j.layers[layer] = append(j.layers[layer], localdir.FileInfo{Path: to, Content: content, Mode: mode})
+	return nil
+}
+
+// addClasspathFile sorts a file from a classpath root into classes or
+// resources, by the file's path relative to that root, which for a jar entry
+// must not lead out of it.
+func (j *javaApp) addClasspathFile(rel string, content []byte, mode os.FileMode) error {
+	clean := path.Clean(rel)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("path outside the classpath %q", rel)
+	}
+	layer, dir := javaResources, "resources"
+	if strings.HasSuffix(rel, ".class") {
+		layer, dir = javaClasses, "classes"
+	}
+	j.layers[layer] = append(j.layers[layer], localdir.FileInfo{
+		Path:    path.Join(j.containerPath, dir, rel),
+		Content: content,
+		Mode:    mode,
+	})
+	return nil
+}
+
+// JavaAppEntrypoint returns the classpath entrypoint for the first javaApp
+// layer in cfg, or nil if there is none. The main class is the layer's
+// mainClass, or else Start-Class or Main-Class from the application's
+// manifest.
+func JavaAppEntrypoint(cfg []schema.Layer) ([]string, error) {
+	for _, layer := range cfg {
+		app := layer.JavaApp
+		if app.Jar == "" && app.Dir == "" {
+			continue
+		}
+		mainClass := app.MainClass
+		if mainClass == "" {
+			manifest, err := readJavaManifest(app)
+			if err != nil {
+				return nil, fmt.Errorf("javaApp manifest: %w", err)
+			}
+			mainClass = manifest["Start-Class"]
+			if mainClass == "" {
+				mainClass = manifest["Main-Class"]
+			}
+		}
+		if mainClass == "" {
+			return nil, errors.New("javaApp: no Start-Class or Main-Class in the manifest, set mainClass or entrypoint")
+		}
+		cp := javaAppContainerPath(app)
+		entrypoint := append([]string{"java"}, app.JvmFlags...)
+		return append(entrypoint,
+			"-cp", strings.Join([]string{cp + "/resources", cp + "/classes", cp + "/libs/*"}, ":"),
+			mainClass,
+		), nil
+	}
+	return nil, nil
+}
+
+// readJavaManifest returns the main attributes of META-INF/MANIFEST.MF, from
+// the jar, or from an exploded dir or its classpath roots. A missing
+// manifest is an empty one.
+func readJavaManifest(app schema.JavaApp) (map[string]string, error) {
+	if app.Jar != "" {
+		r, err := zip.OpenReader(app.Jar)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close() //nolint:errcheck
+		for _, f := range r.File {
+			if f.Name == "META-INF/MANIFEST.MF" {
+				content, err := readZipFile(f)
+				if err != nil {
+					return nil, err
+				}
+				return parseJavaManifest(content), nil
+			}
+		}
+		return map[string]string{}, nil
+	}
+	classRoots, _ := javaDirLayout(app.Dir)
+	for _, dir := range append([]string{app.Dir}, classRoots...) {
+		content, err := os.ReadFile(filepath.Join(dir, "META-INF", "MANIFEST.MF"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return parseJavaManifest(content), nil
+	}
+	return map[string]string{}, nil
+}
+
+// parseJavaManifest reads the main section of a jar manifest, where a line
+// starting with a space continues the previous value.
+func parseJavaManifest(content []byte) map[string]string {
+	attributes := map[string]string{}
+	var last string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, " ") && last != "" {
+			attributes[last] += line[1:]
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		last = strings.TrimSpace(name)
+		attributes[last] = strings.TrimSpace(value)
+	}
+	return attributes
+}
@@ -0,0 +1,163 @@
+package layers
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+func writeJar(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Write([]byte(entries[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJavaApp_SpringBootJar(t *testing.T) {
+	jar := filepath.Join(t.TempDir(), "app.jar")
+	writeJar(t, jar, map[string]string{
+		"META-INF/MANIFEST.MF": "Manifest-Version: 1.0\r\nMain-Class: org.springframework.boot.loader.launch.JarLauncher\r\n" +
+			"Start-Class: com.example.demo.DemoApplicat\r\n ion\r\n\r\n",
+		"org/springframework/boot/loader/launch/JarLauncher.class": "LAUNCHER",
+		"BOOT-INF/classpath.idx":                                   "- x",
+		"BOOT-INF/lib/spring-core-6.1.0.jar":                       "CORE",
+		"BOOT-INF/lib/internal-lib-1.2-SNAPSHOT.jar":               "SNAP",
+		"BOOT-INF/classes/application.yaml":                        "server: {}",
+		"BOOT-INF/classes/com/example/demo/DemoApplication.class":  "CLASS",
+	})
+	cfg := schema.Layer{JavaApp: schema.JavaApp{Jar: jar}}
+	builders, err := NewLayerBuilders(cfg, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilders: %v", err)
+	}
+	if len(builders) != 4 {
+		t.Fatalf("expected 4 builders, got %d", len(builders))
+	}
+	expected := []map[string]string{
+		{"/app/libs/spring-core-6.1.0.jar": "CORE"},
+		{"/app/libs/internal-lib-1.2-SNAPSHOT.jar": "SNAP"},
+		{"/app/resources/application.yaml": "server: {}"},
+		{"/app/classes/com/example/demo/DemoApplication.class": "CLASS"},
+	}
+	for i, b := range builders {
+		layer, err := b(amd64())
+		if err != nil {
+			t.Fatalf("layer %d: %v", i, err)
+		}
+		if got := layerFiles(t, layer); !reflect.DeepEqual(got, expected[i]) {
+			t.Errorf("layer %d: got %v, expected %v", i, got, expected[i])
+		}
+	}
+
+	entrypoint, err := JavaAppEntrypoint([]schema.Layer{{LocalDir: schema.LocalDir{Path: "."}}, cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedEntrypoint := []string{"java", "-cp", "/app/resources:/app/classes:/app/libs/*", "com.example.demo.DemoApplication"}
+	if !reflect.DeepEqual(entrypoint, expectedEntrypoint) {
+		t.Errorf("entrypoint %v", entrypoint)
+	}
+}
+
+func TestJavaApp_JarEntryOutsideClasspath(t *testing.T) {
+	for _, name := range []string{"../../etc/cron.d/x", "BOOT-INF/classes/../../../x.class", "/etc/passwd"} {
+		jar := filepath.Join(t.TempDir(), "app.jar")
+		entries := map[string]string{name: "X"}
+		if strings.HasPrefix(name, "BOOT-INF/") {
+			entries["BOOT-INF/lib/a-1.0.jar"] = "A"
+		}
+		writeJar(t, jar, entries)
+		builders, err := NewLayerBuilders(schema.Layer{JavaApp: schema.JavaApp{Jar: jar}}, Options{})
+		if err != nil {
+			t.Fatalf("NewLayerBuilders: %v", err)
+		}
+		_, err = builders[0](amd64())
+		if err == nil || !strings.Contains(err.Error(), "path outside the classpath") {
+			t.Errorf("%s: expected error, got %v", name, err)
+		}
+	}
+}
+
+func TestJavaApp_MavenDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"classes/com/example", "dependency"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, dir, "classes/com/example/Main.class", "MAIN")
+	writeFile(t, dir, "classes/logback.xml", "<configuration/>")
+	writeFile(t, dir, "dependency/guava-33.0.jar", "GUAVA")
+	writeFile(t, dir, "dependency/README", "not a jar")
+
+	cfg := schema.Layer{JavaApp: schema.JavaApp{Dir: dir, ContainerPath: "/opt/svc/"}}
+	builders, err := NewLayerBuilders(cfg, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilders: %v", err)
+	}
+	layers, err := Build(builders, amd64())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 3 {
+		t.Fatalf("no snapshot dependencies, expected 3 layers, got %d", len(layers))
+	}
+	if files := layerFiles(t, layers[0]); files["/opt/svc/libs/guava-33.0.jar"] != "GUAVA" || len(files) != 1 {
+		t.Errorf("dependencies %v", files)
+	}
+	if files := layerFiles(t, layers[1]); files["/opt/svc/resources/logback.xml"] != "<configuration/>" {
+		t.Errorf("resources %v", files)
+	}
+	if files := layerFiles(t, layers[2]); files["/opt/svc/classes/com/example/Main.class"] != "MAIN" {
+		t.Errorf("classes %v", files)
+	}
+
+	_, err = JavaAppEntrypoint([]schema.Layer{cfg})
+	if err == nil || !strings.Contains(err.Error(), "set mainClass or entrypoint") {
+		t.Errorf("expected missing main class error, got %v", err)
+	}
+	cfg.JavaApp.MainClass = "com.example.Main"
+	cfg.JavaApp.JvmFlags = []string{"-XX:MaxRAMPercentage=75"}
+	entrypoint, err := JavaAppEntrypoint([]schema.Layer{cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(entrypoint, " ") != "java -XX:MaxRAMPercentage=75 -cp /opt/svc/resources:/opt/svc/classes:/opt/svc/libs/* com.example.Main" {
+		t.Errorf("entrypoint %v", entrypoint)
+	}
+}
+
+func TestNewLayerBuilder_RejectsMultiLayerConfig(t *testing.T) {
+	_, err := NewLayerBuilder(schema.Layer{JavaApp: schema.JavaApp{Dir: "."}}, Options{})
+	if err == nil || !strings.Contains(err.Error(), "produces 4 layers") {
+		t.Errorf("expected error, got %v", err)
+	}
+}
@@ -1,56 +1,235 @@
 package layers
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/moby/patternmatcher"
 	"github.com/turbokube/contain/pkg/localdir"
 	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
 )
 
 // LayerBuilder produces a layer for the given platform. Builders for
 // platform-agnostic layers (localDir, localFile with only Path set) ignore
 // the argument; builders for localFile.pathPerPlatform resolve the source
-// path per call.
+// path per call. A builder returns a nil layer and a nil error for a
+// platform its layer's platforms selector excludes.
 type LayerBuilder func(platform v1.Platform) (v1.Layer, error)
 
+// Options are the build's, for the builders of its layers, which use them
+// instead of process state
+type Options struct {
+	// Context cancels builds, for example goBuild's go build and directory
+	// walks. Default context.Background.
+	Context context.Context
+	// Logger defaults to zap.L()
+	Logger *zap.Logger
+	// ContextDir is the build context, that relative paths in the config
+	// are relative to. Default the current dir.
+	ContextDir string
+}
+
+func (o Options) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+func (o Options) logger() *zap.Logger {
+	if o.Logger == nil {
+		return zap.L()
+	}
+	return o.Logger
+}
+
 // Build invokes every builder for platform and returns the resulting
-// layer slice. Callers that do not need per-platform resolution (for
+// layer slice, without the layers that do not apply to platform, so
+// children of one config can differ in their layer sets. Callers that do not need per-platform resolution (for
 // example sync to a running container) may pass the zero v1.Platform;
-// that works for localDir and for localFile configs that only set Path.
+// that works for localDir and for localFile configs that only set Path,
+// and includes every layer regardless of its platforms selector.
 func Build(builders []LayerBuilder, platform v1.Platform) ([]v1.Layer, error) {
-	out := make([]v1.Layer, len(builders))
+	out := make([]v1.Layer, 0, len(builders))
 	for i, b := range builders {
 		layer, err := b(platform)
 		if err != nil {
 			return nil, fmt.Errorf("layer %d for %s: %w", i, platform.String(), err)
 		}
-		out[i] = layer
+		if layer == nil {
+			continue
+		}
+		out = append(out, layer)
 	}
 	return out, nil
 }
 
-func NewLayerBuilder(cfg schema.Layer) (LayerBuilder, error) {
-	hasLocalFile := cfg.LocalFile.Path != "" || len(cfg.LocalFile.PathPerPlatform) > 0
-	if hasLocalFile {
-		if cfg.LocalDir.Path != "" {
-			return nil, errors.New("each layer item must have exactly one type, got localFile and localDir")
+// MissingSources returns why the per-platform layer sources in cfg are not
+// all present on disk for platform, one entry per missing source, or nil if
+// every one of them exists. Layers without per-platform configuration serve
+// every platform alike and are not checked: a missing localDir is a build
+// error, not a reason to drop a platform. Nor are layers whose platforms
+// selector excludes platform.
+func MissingSources(cfg []schema.Layer, platform v1.Platform) []string {
+	var missing []string
+	for i, layer := range cfg {
+		if !schema.LayerAppliesTo(layer, platform) {
+			continue
+		}
+		if len(layer.LocalFile.PathPerPlatform) > 0 {
+			resolved := schema.ResolveLocalFilePath(layer.LocalFile, platform)
+			if resolved == "" {
+				missing = append(missing, fmt.Sprintf("layers[%d].localFile: no path for platform", i))
+			} else if _, err := os.Stat(resolved); err != nil {
+				missing = append(missing, fmt.Sprintf("layers[%d].localFile: %s not found", i, resolved))
+			}
+		}
+		if len(layer.NodeApp.NodeModulesPerPlatform) > 0 {
+			resolved := filepath.Join(layer.NodeApp.Dir, schema.ResolveNodeModules(layer.NodeApp, platform))
+			if _, err := os.Stat(resolved); err != nil {
+				missing = append(missing, fmt.Sprintf("layers[%d].nodeApp: %s not found", i, resolved))
+			}
+		}
+		for j, f := range layer.Files {
+			if len(f.PathPerPlatform) == 0 {
+				continue
+			}
+			resolved := schema.ResolveFileMappingSrc(f, platform)
+			if resolved == "" {
+				missing = append(missing, fmt.Sprintf("layers[%d].files[%d]: no src for platform", i, j))
+			} else if matches, _ := filepath.Glob(resolved); len(matches) == 0 {
+				missing = append(missing, fmt.Sprintf("layers[%d].files[%d]: %s not found", i, j, resolved))
+			}
+		}
+		for name := range layer.Extensions {
+			if t, err := registered(name); err == nil && t.MissingSources != nil {
+				for _, m := range t.MissingSources(layer, platform) {
+					missing = append(missing, fmt.Sprintf("layers[%d].%s: %s", i, name, m))
+				}
+			}
+		}
+	}
+	return missing
+}
+
+// NewLayerBuilder returns the builder for one layer config, which builds
+// nothing for platforms outside the config's platforms selector and fails
+// for layers over the config's maxCompressedSize. For layer types that
+// produce more than one layer, javaApp and nodeApp, use NewLayerBuilders.
+func NewLayerBuilder(cfg schema.Layer, opts Options) (LayerBuilder, error) {
+	builders, err := NewLayerBuilders(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(builders) != 1 {
+		return nil, fmt.Errorf("layer config produces %d layers", len(builders))
+	}
+	return builders[0], nil
+}
+
+// NewLayerBuilders returns the builders for one layer config, in layer
+// order. A javaApp or nodeApp config produces several layers, every other
+// type one.
+func NewLayerBuilders(cfg schema.Layer, opts Options) ([]LayerBuilder, error) {
+	opts.Context, opts.Logger = opts.context(), opts.logger()
+	if opts.ContextDir == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		opts.ContextDir = dir
+	}
+	builders, err := newTypeBuilders(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxCompressedSize != "" {
+		max, err := localdir.NewSize(cfg.MaxCompressedSize)
+		if err != nil {
+			return nil, fmt.Errorf("maxCompressedSize: %w", err)
+		}
+		for i, b := range builders {
+			builders[i] = withMaxCompressedSize(b, int64(max), cfg.MaxCompressedSize)
 		}
-		return newLocalFileBuilder(cfg.LocalFile, cfg.Attributes)
 	}
-	if cfg.LocalDir.Path != "" {
-		return configure(localdir.NewDir(), cfg.LocalDir, cfg.Attributes)
+	if len(cfg.Platforms) == 0 {
+		return builders, nil
+	}
+	for i, b := range builders {
+		builders[i] = func(platform v1.Platform) (v1.Layer, error) {
+			if !schema.LayerAppliesTo(cfg, platform) {
+				return nil, nil
+			}
+			return b(platform)
+		}
+	}
+	return builders, nil
+}
+
+func newTypeBuilders(cfg schema.Layer, opts Options) ([]LayerBuilder, error) {
+	types := schema.LayerTypes(cfg)
+	if len(types) > 1 {
+		return nil, fmt.Errorf("each layer item must have exactly one type, got %s", strings.Join(types, " and "))
+	}
+	if len(types) == 0 {
+		return nil, errors.New("no layer builder config found")
+	}
+	t, err := registered(types[0])
+	if err != nil {
+		return nil, err
+	}
+	builders, err := t.New(cfg, opts)
+	if err != nil || !t.Cache {
+		return builders, err
+	}
+	for i, b := range builders {
+		builders[i] = cached(b)
+	}
+	return builders, nil
+}
+
+// cached returns a builder that invokes b once, for the first platform,
+// and returns that result for every platform
+func cached(b LayerBuilder) LayerBuilder {
+	var once sync.Once
+	var layer v1.Layer
+	var err error
+	return func(platform v1.Platform) (v1.Layer, error) {
+		once.Do(func() {
+			layer, err = b(platform)
+		})
+		return layer, err
+	}
+}
+
+// newFilesBuilder returns a builder that resolves each entry's source for
+// the requested platform and puts all of them in one layer.
+func newFilesBuilder(files []schema.FileMapping, attributes schema.LayerAttributes, opts Options) LayerBuilder {
+	return func(platform v1.Platform) (v1.Layer, error) {
+		mappings := make([]localdir.FileMapping, len(files))
+		for i, f := range files {
+			src := schema.ResolveFileMappingSrc(f, platform)
+			if src == "" {
+				return nil, fmt.Errorf("files[%d]: no src for platform %s", i, platform.String())
+			}
+			mappings[i] = localdir.FileMapping{Src: src, Dst: f.Dst, Mode: f.Mode}
+		}
+		return localdir.FromFileMappingsContext(opts.context(), mappings, attributes, opts.logger())
 	}
-	return nil, errors.New("no layer builder config found")
 }
 
 // newLocalFileBuilder returns a builder that resolves the source path for
 // the requested platform on each invocation. This is the per-arch
 // localFile path; for localFile configs with only Path set the closure
 // still works (ResolveLocalFilePath returns Path regardless of platform).
-func newLocalFileBuilder(lf schema.LocalFile, attributes schema.LayerAttributes) (LayerBuilder, error) {
+func newLocalFileBuilder(lf schema.LocalFile, attributes schema.LayerAttributes, opts Options) (LayerBuilder, error) {
 	return func(platform v1.Platform) (v1.Layer, error) {
 		resolved := schema.ResolveLocalFilePath(lf, platform)
 		if resolved == "" {
@@ -60,7 +239,7 @@ func newLocalFileBuilder(lf schema.LocalFile, attributes schema.LayerAttributes)
 			Path:          resolved,
 			ContainerPath: lf.ContainerPath,
 			MaxSize:       lf.MaxSize,
-		}, attributes)
+		}, attributes, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -68,10 +247,16 @@ func newLocalFileBuilder(lf schema.LocalFile, attributes schema.LayerAttributes)
 	}, nil
 }
 
-func configure(dir localdir.From, cfg schema.LocalDir, attributes schema.LayerAttributes) (LayerBuilder, error) {
+func configure(dir localdir.From, cfg schema.LocalDir, attributes schema.LayerAttributes, opts Options) (LayerBuilder, error) {
 	dir.Path = cfg.Path
+	dir.Context = opts.context()
+	dir.Log = opts.logger()
 	if cfg.ContainerPath != "" {
-		dir.ContainerPath = localdir.NewPathMapperPrepend(cfg.ContainerPath)
+		var err error
+		dir.ContainerPath, err = localdir.NewPathMapperPrepend(cfg.ContainerPath)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if len(cfg.Ignore) > 0 {
 		var err error
@@ -2,6 +2,9 @@ package layers
 
 import (
 	"archive/tar"
+	"context"
+	"crypto/rand"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -10,6 +13,8 @@ import (
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 // layerFiles returns a name->contents map extracted from the layer's
@@ -65,7 +70,7 @@ func TestNewLayerBuilder_LocalFilePathPerPlatform(t *testing.T) {
 		},
 		ContainerPath: "/bin/mybinary",
 	}}
-	b, err := NewLayerBuilder(cfg)
+	b, err := NewLayerBuilder(cfg, Options{})
 	if err != nil {
 		t.Fatalf("NewLayerBuilder: %v", err)
 	}
@@ -107,7 +112,7 @@ func TestNewLayerBuilder_FallbackPathUsedWhenPlatformMissing(t *testing.T) {
 		},
 		ContainerPath: "/bin/x",
 	}}
-	b, err := NewLayerBuilder(cfg)
+	b, err := NewLayerBuilder(cfg, Options{})
 	if err != nil {
 		t.Fatalf("NewLayerBuilder: %v", err)
 	}
@@ -125,7 +130,7 @@ func TestNewLayerBuilder_ErrorWhenNoPathResolvesForPlatform(t *testing.T) {
 	cfg := schema.Layer{LocalFile: schema.LocalFile{
 		PathPerPlatform: map[string]string{"linux/amd64": "unused"},
 	}}
-	b, err := NewLayerBuilder(cfg)
+	b, err := NewLayerBuilder(cfg, Options{})
 	if err != nil {
 		t.Fatalf("NewLayerBuilder: %v", err)
 	}
@@ -141,7 +146,7 @@ func TestNewLayerBuilder_LocalDirIgnoresPlatform(t *testing.T) {
 	writeFile(t, dir, "a.txt", "A")
 
 	cfg := schema.Layer{LocalDir: schema.LocalDir{Path: dir, ContainerPath: "/app"}}
-	b, err := NewLayerBuilder(cfg)
+	b, err := NewLayerBuilder(cfg, Options{})
 	if err != nil {
 		t.Fatalf("NewLayerBuilder: %v", err)
 	}
@@ -160,19 +165,47 @@ func TestNewLayerBuilder_LocalDirIgnoresPlatform(t *testing.T) {
 	}
 }
 
+func TestNewLayerBuilder_Options(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a.txt", "A")
+	cfg := schema.Layer{LocalDir: schema.LocalDir{Path: dir, ContainerPath: "/app"}}
+
+	core, logs := observer.New(zap.InfoLevel)
+	b, err := NewLayerBuilder(cfg, Options{Logger: zap.New(core)})
+	if err != nil {
+		t.Fatalf("NewLayerBuilder: %v", err)
+	}
+	if _, err := b(amd64()); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if logs.FilterMessage("layer buffer created").Len() != 1 {
+		t.Errorf("expected the layer logged to the given logger, got %v", logs.All())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b, err = NewLayerBuilder(cfg, Options{Context: ctx})
+	if err != nil {
+		t.Fatalf("NewLayerBuilder: %v", err)
+	}
+	if _, err := b(amd64()); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the build canceled, got %v", err)
+	}
+}
+
 func TestNewLayerBuilder_RejectsBothLocalFileAndLocalDir(t *testing.T) {
 	cfg := schema.Layer{
 		LocalFile: schema.LocalFile{Path: "a"},
 		LocalDir:  schema.LocalDir{Path: "b"},
 	}
-	_, err := NewLayerBuilder(cfg)
+	_, err := NewLayerBuilder(cfg, Options{})
 	if err == nil || !strings.Contains(err.Error(), "exactly one type") {
 		t.Errorf("expected 'exactly one type' error, got %v", err)
 	}
 }
 
 func TestNewLayerBuilder_RejectsEmptyConfig(t *testing.T) {
-	_, err := NewLayerBuilder(schema.Layer{})
+	_, err := NewLayerBuilder(schema.Layer{}, Options{})
 	if err == nil || !strings.Contains(err.Error(), "no layer builder config found") {
 		t.Errorf("expected 'no layer builder config found' error, got %v", err)
 	}
@@ -185,7 +218,7 @@ func TestNewLayerBuilder_LocalFilePropagatesConfigureError(t *testing.T) {
 		Path:    "/dev/null",
 		MaxSize: "not-a-size",
 	}}
-	b, err := NewLayerBuilder(cfg)
+	b, err := NewLayerBuilder(cfg, Options{})
 	if err != nil {
 		t.Fatalf("NewLayerBuilder: %v", err)
 	}
@@ -226,3 +259,146 @@ func TestBuild_WrapsErrorWithIndexAndPlatform(t *testing.T) {
 		t.Errorf("error should include index and platform, got %q", err.Error())
 	}
 }
+
+func TestBuild_PlatformsSelectorOmitsLayer(t *testing.T) {
+	dir := t.TempDir()
+	all := writeFile(t, dir, "all.txt", "ALL")
+	agent := writeFile(t, dir, "agent.so", "AGENT")
+
+	var builders []LayerBuilder
+	for _, cfg := range []schema.Layer{
+		{LocalFile: schema.LocalFile{Path: all, ContainerPath: "/all.txt"}},
+		{
+			Platforms: []string{"linux/amd64"},
+			LocalFile: schema.LocalFile{Path: agent, ContainerPath: "/agent.so"},
+		},
+	} {
+		b, err := NewLayerBuilder(cfg, Options{})
+		if err != nil {
+			t.Fatalf("NewLayerBuilder: %v", err)
+		}
+		builders = append(builders, b)
+	}
+
+	amd, err := Build(builders, amd64())
+	if err != nil {
+		t.Fatalf("amd64: %v", err)
+	}
+	if len(amd) != 2 {
+		t.Fatalf("amd64 is selected, expected 2 layers, got %d", len(amd))
+	}
+	if files := layerFiles(t, amd[1]); files["/agent.so"] != "AGENT" {
+		t.Errorf("amd64 second layer should be the agent, got %v", files)
+	}
+	arm, err := Build(builders, arm64())
+	if err != nil {
+		t.Fatalf("arm64: %v", err)
+	}
+	if len(arm) != 1 {
+		t.Fatalf("arm64 is not selected, expected 1 layer, got %d", len(arm))
+	}
+	sync, err := Build(builders, v1.Platform{})
+	if err != nil {
+		t.Fatalf("zero platform: %v", err)
+	}
+	if len(sync) != 2 {
+		t.Errorf("zero platform selects every layer, got %d", len(sync))
+	}
+}
+
+func TestNewLayerBuilder_FilesPathPerPlatform(t *testing.T) {
+	dir := t.TempDir()
+	conf := writeFile(t, dir, "app.conf", "CONF")
+	writeFile(t, dir, "amd64.bin", "AMD")
+	writeFile(t, dir, "arm64.bin", "ARM")
+
+	b, err := NewLayerBuilder(schema.Layer{Files: []schema.FileMapping{
+		{Src: conf, Dst: "/etc/app.conf"},
+		{
+			PathPerPlatform: map[string]string{
+				"linux/amd64": filepath.Join(dir, "amd64.bin"),
+				"linux/arm64": filepath.Join(dir, "arm64.bin"),
+			},
+			Dst: "/usr/local/bin/app",
+		},
+	}}, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilder: %v", err)
+	}
+	for _, c := range []struct {
+		platform v1.Platform
+		body     string
+	}{{amd64(), "AMD"}, {arm64(), "ARM"}} {
+		layer, err := b(c.platform)
+		if err != nil {
+			t.Fatalf("%s: %v", c.platform.String(), err)
+		}
+		files := layerFiles(t, layer)
+		if len(files) != 2 || files["/etc/app.conf"] != "CONF" || files["/usr/local/bin/app"] != c.body {
+			t.Errorf("%s: unexpected layer content %v", c.platform.String(), files)
+		}
+	}
+}
+
+func TestMissingSources_OnlyPerPlatformLayersAreChecked(t *testing.T) {
+	dir := t.TempDir()
+	amd := writeFile(t, dir, "amd64.bin", "AMD")
+
+	cfg := []schema.Layer{
+		// a missing localDir is not a per-platform source
+		{LocalDir: schema.LocalDir{Path: filepath.Join(dir, "nonexistent")}},
+		{LocalFile: schema.LocalFile{
+			PathPerPlatform: map[string]string{
+				"linux/amd64": amd,
+				"linux/s390x": filepath.Join(dir, "s390x.bin"),
+			},
+			ContainerPath: "/bin/x",
+		}},
+	}
+	if got := MissingSources(cfg, amd64()); got != nil {
+		t.Errorf("amd64 has its source, got %v", got)
+	}
+	got := MissingSources(cfg, arm64())
+	if len(got) != 1 || !strings.Contains(got[0], "layers[1]") || !strings.Contains(got[0], "no path") {
+		t.Errorf("arm64 has no key, got %v", got)
+	}
+	got = MissingSources(cfg, v1.Platform{OS: "linux", Architecture: "s390x"})
+	if len(got) != 1 || !strings.Contains(got[0], "s390x.bin not found") {
+		t.Errorf("s390x key points to a missing file, got %v", got)
+	}
+}
+
+func TestNewLayerBuilder_MaxCompressedSize(t *testing.T) {
+	dir := t.TempDir()
+	big := make([]byte, 64*1024)
+	if _, err := rand.Read(big); err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile(t, dir, "big.bin", string(big))
+	cfg := schema.Layer{
+		MaxCompressedSize: "16Ki",
+		LocalFile:         schema.LocalFile{Path: path, ContainerPath: "/big.bin"},
+	}
+	b, err := NewLayerBuilder(cfg, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilder: %v", err)
+	}
+	_, err = b(amd64())
+	if err == nil || !strings.Contains(err.Error(), "exceeds maxCompressedSize 16Ki") || !strings.Contains(err.Error(), "/big.bin 64.0Ki") {
+		t.Errorf("expected budget error naming the file, got %v", err)
+	}
+
+	cfg.MaxCompressedSize = "1Mi"
+	b, err = NewLayerBuilder(cfg, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilder: %v", err)
+	}
+	if _, err = b(amd64()); err != nil {
+		t.Errorf("within budget, got %v", err)
+	}
+
+	cfg.MaxCompressedSize = "1 MB"
+	if _, err = NewLayerBuilder(cfg, Options{}); err == nil || !strings.Contains(err.Error(), "maxCompressedSize") {
+		t.Errorf("expected parse error, got %v", err)
+	}
+}
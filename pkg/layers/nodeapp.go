@@ -0,0 +1,271 @@
+package layers
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/turbokube/contain/pkg/localdir"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+const nodeAppDefaultContainerPath = "/app"
+
+// nodeApp dependency layers, in the order they are appended, before the app
+const (
+	nodeDependencies = iota
+	nodeNativeDependencies
+	nodeDependencyLayerCount
+)
+
+var nodeLayerNames = [nodeDependencyLayerCount]string{"dependencies", "native dependencies"}
+
+// nodeApp reads the lockfile once and the dependency files once per
+// platform, for the two dependency builders to share.
+type nodeApp struct {
+	cfg           schema.NodeApp
+	containerPath string
+	opts          Options
+	lockOnce      sync.Once
+	lock          nodeLock
+	lockErr       error
+	mu            sync.Mutex
+	byPlatform    map[string][nodeDependencyLayerCount][]localdir.FileInfo
+}
+
+// newNodeAppBuilders returns builders for the dependencies, the native
+// dependencies and the app. A dependency builder with nothing to add for a
+// platform returns nil.
+func newNodeAppBuilders(cfg schema.NodeApp, attributes schema.LayerAttributes, opts Options) ([]LayerBuilder, error) {
+	app := &nodeApp{
+		cfg:           cfg,
+		containerPath: nodeAppContainerPath(cfg),
+		opts:          opts,
+		byPlatform:    make(map[string][nodeDependencyLayerCount][]localdir.FileInfo),
+	}
+	builders := make([]LayerBuilder, 0, nodeDependencyLayerCount+1)
+	for i := range nodeDependencyLayerCount {
+		builders = append(builders, func(platform v1.Platform) (v1.Layer, error) {
+			deps, err := app.dependencies(platform)
+			if err != nil {
+				return nil, fmt.Errorf("nodeApp: %w", err)
+			}
+			if len(deps[i]) == 0 {
+				opts.logger().Debug("nodeApp layer empty", zap.String("layer", nodeLayerNames[i]), zap.String("platform", platform.String()))
+				return nil, nil
+			}
+			files := make([]localdir.FileInfo, len(deps[i]))
+			copy(files, deps[i])
+			return localdir.LayerFromFiles(files, attributes)
+		})
+	}
+	ignore := []string{"node_modules"}
+	for _, p := range append([]string{cfg.NodeModules}, mapValues(cfg.NodeModulesPerPlatform)...) {
+		if p != "" {
+			ignore = append(ignore, filepath.ToSlash(filepath.Clean(p)))
+		}
+	}
+	sources, err := configure(localdir.NewDir(), schema.LocalDir{
+		Path:          cfg.Dir,
+		ContainerPath: app.containerPath,
+		Ignore:        append(ignore, cfg.Ignore...),
+	}, attributes, opts)
+	if err != nil {
+		return nil, err
+	}
+	return append(builders, sources), nil
+}
+
+func mapValues(m map[string]string) []string {
+	values := make([]string, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
+
+func nodeAppContainerPath(cfg schema.NodeApp) string {
+	if cfg.ContainerPath == "" {
+		return nodeAppDefaultContainerPath
+	}
+	return strings.TrimSuffix(cfg.ContainerPath, "/")
+}
+
+// dependencies returns the files of both dependency layers for platform.
+// The zero platform, from sync, is linux on the host's architecture.
+func (n *nodeApp) dependencies(platform v1.Platform) ([nodeDependencyLayerCount][]localdir.FileInfo, error) {
+	var out [nodeDependencyLayerCount][]localdir.FileInfo
+	if platform.OS == "" {
+		platform = v1.Platform{OS: "linux", Architecture: runtime.GOARCH}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if cached, ok := n.byPlatform[platform.String()]; ok {
+		return cached, nil
+	}
+	n.lockOnce.Do(func() {
+		n.lock, n.lockErr = readNodeLock(n.cfg.Dir)
+	})
+	if n.lockErr != nil {
+		return out, n.lockErr
+	}
+
+	defaultRoot := filepath.Join(n.cfg.Dir, schema.ResolveNodeModules(schema.NodeApp{NodeModules: n.cfg.NodeModules}, platform))
+	platformRoot := filepath.Join(n.cfg.Dir, schema.ResolveNodeModules(n.cfg, platform))
+	packages, err := n.lock.packages(platformRoot)
+	if err != nil {
+		return out, err
+	}
+	seen := [nodeDependencyLayerCount]map[string]bool{{}, {}}
+	for _, p := range packages {
+		if !nodePlatformMatches(p, platform) {
+			n.opts.logger().Debug("nodeApp package not for platform", zap.String("package", p.path), zap.String("platform", platform.String()))
+			continue
+		}
+		layer, root := nodeDependencies, defaultRoot
+		if p.native {
+			layer, root = nodeNativeDependencies, platformRoot
+		} else if _, err := os.Lstat(filepath.Join(root, filepath.FromSlash(p.path))); err != nil {
+			root = platformRoot
+		}
+		src := filepath.Join(root, filepath.FromSlash(p.path))
+		if _, err := os.Lstat(src); err != nil {
+			if p.optional {
+				n.opts.logger().Warn("nodeApp optional package not installed", zap.String("package", p.path), zap.String("nodeModules", root))
+				continue
+			}
+			return out, fmt.Errorf("%s is in the lockfile but not installed in %s", p.path, root)
+		}
+		files, err := n.packageFiles(src, p)
+		if err != nil {
+			return out, err
+		}
+		for _, f := range files {
+			for dir := path.Dir(f.Path); strings.HasPrefix(dir, n.containerPath+"/node_modules"); dir = path.Dir(dir) {
+				if !seen[layer][dir] {
+					seen[layer][dir] = true
+					out[layer] = append(out[layer], localdir.FileInfo{Path: dir, Mode: os.ModeDir | 0o755, IsDir: true})
+				}
+			}
+			if f.IsDir {
+				if seen[layer][f.Path] {
+					continue
+				}
+				seen[layer][f.Path] = true
+			}
+			out[layer] = append(out[layer], f)
+		}
+	}
+	for i, files := range out {
+		n.opts.logger().Info("nodeApp layer",
+			zap.String("layer", nodeLayerNames[i]),
+			zap.String("platform", platform.String()),
+			zap.Int("entries", len(files)),
+		)
+	}
+	n.byPlatform[platform.String()] = out
+	return out, nil
+}
+
+// packageFiles reads an installed package. An npm package's own
+// node_modules is left out, as the lockfile lists what is in there
+// separately, with dev packages excluded.
+func (n *nodeApp) packageFiles(src string, p nodePackage) ([]localdir.FileInfo, error) {
+	base := path.Join(n.containerPath, "node_modules", p.path)
+	if p.link {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return nil, err
+		}
+		return []localdir.FileInfo{{Path: base, IsSymlink: true, LinkTarget: target}}, nil
+	}
+	pnpmStore := strings.HasPrefix(p.path, ".pnpm/")
+	var files []localdir.FileInfo
+	err := filepath.WalkDir(src, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := n.opts.context().Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		to := path.Join(base, filepath.ToSlash(rel))
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			if !pnpmStore && rel == "node_modules" {
+				return filepath.SkipDir
+			}
+			files = append(files, localdir.FileInfo{Path: to, Mode: info.Mode(), IsDir: true})
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			if filepath.IsAbs(target) {
+				n.opts.logger().Warn("skipping symlink with absolute target", zap.String("path", file), zap.String("target", target))
+				return nil
+			}
+			files = append(files, localdir.FileInfo{Path: to, Mode: info.Mode(), IsSymlink: true, LinkTarget: target})
+		default:
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			files = append(files, localdir.FileInfo{Path: to, Content: content, Mode: info.Mode()})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// nodePlatformMatches applies a package's os and cpu fields, which use
+// Node's process.platform and process.arch names, to platform.
+func nodePlatformMatches(p nodePackage, platform v1.Platform) bool {
+	nodeOS := platform.OS
+	if nodeOS == "windows" {
+		nodeOS = "win32"
+	}
+	nodeCPU := platform.Architecture
+	switch nodeCPU {
+	case "amd64":
+		nodeCPU = "x64"
+	case "386":
+		nodeCPU = "ia32"
+	case "ppc64le":
+		nodeCPU = "ppc64"
+	}
+	return nodeListMatches(p.os, nodeOS) && nodeListMatches(p.cpu, nodeCPU)
+}
+
+// nodeListMatches is npm's os/cpu semantics: no list allows everything,
+// !value excludes value, and any plain entry makes the list an allow list.
+func nodeListMatches(list []string, value string) bool {
+	allowList := false
+	for _, e := range list {
+		if excluded, ok := strings.CutPrefix(e, "!"); ok {
+			if excluded == value {
+				return false
+			}
+			continue
+		}
+		allowList = true
+		if e == value {
+			return true
+		}
+	}
+	return !allowList
+}
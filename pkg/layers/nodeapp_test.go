@@ -0,0 +1,259 @@
+package layers
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fileNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+const npmLockfile = `{
+  "name": "app",
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "app"},
+    "node_modules/express": {"version": "4.0.0"},
+    "node_modules/express/node_modules/nested": {"version": "1.0.0"},
+    "node_modules/jest": {"version": "29.0.0", "dev": true},
+    "node_modules/bcrypt": {"version": "5.0.0", "hasInstallScript": true},
+    "node_modules/@img/sharp-linux-x64": {"version": "0.33.0", "optional": true, "os": ["linux"], "cpu": ["x64"]},
+    "node_modules/@img/sharp-linux-arm64": {"version": "0.33.0", "optional": true, "os": ["linux"], "cpu": ["arm64"]},
+    "node_modules/fsevents": {"version": "2.3.3", "optional": true, "os": ["darwin"]}
+  }
+}`
+
+func TestNodeApp_NpmProductionAndNativePerPlatform(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"package.json":                  `{"name": "app"}`,
+		"package-lock.json":             npmLockfile,
+		"server.js":                     "SERVER",
+		"node_modules/express/index.js": "EXPRESS",
+		"node_modules/express/node_modules/nested/index.js":    "NESTED",
+		"node_modules/express/node_modules/jest-dev/x.js":      "NOT IN LOCKFILE",
+		"node_modules/jest/index.js":                           "JEST",
+		"node_modules/bcrypt/build/Release/bcrypt.node":        "BCRYPT-X64",
+		"node_modules/@img/sharp-linux-x64/sharp.node":         "SHARP-X64",
+		"node_modules-arm64/bcrypt/build/Release/bcrypt.node":  "BCRYPT-ARM64",
+		"node_modules-arm64/@img/sharp-linux-arm64/sharp.node": "SHARP-ARM64",
+	})
+	builders, err := NewLayerBuilders(schema.Layer{NodeApp: schema.NodeApp{
+		Dir:                    dir,
+		NodeModulesPerPlatform: map[string]string{"linux/arm64": "node_modules-arm64"},
+	}}, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilders: %v", err)
+	}
+	if len(builders) != 3 {
+		t.Fatalf("expected 3 builders, got %d", len(builders))
+	}
+
+	amd, err := Build(builders, amd64())
+	if err != nil {
+		t.Fatalf("amd64: %v", err)
+	}
+	arm, err := Build(builders, arm64())
+	if err != nil {
+		t.Fatalf("arm64: %v", err)
+	}
+	if len(amd) != 3 || len(arm) != 3 {
+		t.Fatalf("expected 3 layers per platform, got %d and %d", len(amd), len(arm))
+	}
+
+	deps := layerFiles(t, amd[0])
+	expectedDeps := []string{
+		"/app/node_modules", "/app/node_modules/express", "/app/node_modules/express/index.js",
+		"/app/node_modules/express/node_modules", "/app/node_modules/express/node_modules/nested",
+		"/app/node_modules/express/node_modules/nested/index.js",
+	}
+	if got := fileNames(deps); !equalStrings(got, expectedDeps) {
+		t.Errorf("dependencies %v", got)
+	}
+	amdDeps, _ := amd[0].Digest()
+	armDeps, _ := arm[0].Digest()
+	if amdDeps != armDeps {
+		t.Errorf("the dependencies layer should not depend on platform")
+	}
+
+	if native := layerFiles(t, amd[1]); native["/app/node_modules/bcrypt/build/Release/bcrypt.node"] != "BCRYPT-X64" ||
+		native["/app/node_modules/@img/sharp-linux-x64/sharp.node"] != "SHARP-X64" ||
+		native["/app/node_modules/@img/sharp-linux-arm64/sharp.node"] != "" {
+		t.Errorf("amd64 native %v", native)
+	}
+	if native := layerFiles(t, arm[1]); native["/app/node_modules/bcrypt/build/Release/bcrypt.node"] != "BCRYPT-ARM64" ||
+		native["/app/node_modules/@img/sharp-linux-arm64/sharp.node"] != "SHARP-ARM64" ||
+		native["/app/node_modules/@img/sharp-linux-x64/sharp.node"] != "" {
+		t.Errorf("arm64 native %v", native)
+	}
+
+	if got := fileNames(layerFiles(t, amd[2])); !equalStrings(got, []string{"/app", "/app/package-lock.json", "/app/package.json", "/app/server.js"}) {
+		t.Errorf("app %v", got)
+	}
+
+	missing := MissingSources([]schema.Layer{{NodeApp: schema.NodeApp{
+		Dir:                    dir,
+		NodeModulesPerPlatform: map[string]string{"linux/arm64": "node_modules-arm64/v8"},
+	}}}, arm64())
+	if len(missing) != 1 {
+		t.Errorf("expected the arm64 node_modules to be missing, got %v", missing)
+	}
+}
+
+func TestNodeApp_NpmDevOptional(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"package.json": `{"name": "app"}`,
+		"package-lock.json": `{
+  "name": "app",
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "app"},
+    "node_modules/chokidar": {"version": "3.0.0"},
+    "node_modules/jest": {"version": "29.0.0", "dev": true},
+    "node_modules/anymatch": {"version": "3.0.0", "devOptional": true},
+    "node_modules/readdirp": {"version": "3.0.0", "devOptional": true}
+  }
+}`,
+		"node_modules/chokidar/index.js": "CHOKIDAR",
+		"node_modules/jest/index.js":     "JEST",
+		"node_modules/anymatch/index.js": "ANYMATCH",
+	})
+	builders, err := NewLayerBuilders(schema.Layer{NodeApp: schema.NodeApp{Dir: dir}}, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilders: %v", err)
+	}
+	built, err := Build(builders, amd64())
+	if err != nil {
+		t.Fatalf("a devOptional package that is not installed is optional: %v", err)
+	}
+	deps := layerFiles(t, built[0])
+	if deps["/app/node_modules/anymatch/index.js"] != "ANYMATCH" {
+		t.Errorf("devOptional packages are kept by npm install --omit=dev, got %v", fileNames(deps))
+	}
+	if _, ok := deps["/app/node_modules/jest/index.js"]; ok {
+		t.Errorf("dev packages should be left out, got %v", fileNames(deps))
+	}
+}
+
+func TestNodeApp_Pnpm(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"package.json": `{"name": "app"}`,
+		"pnpm-lock.yaml": `lockfileVersion: '9.0'
+importers:
+  .:
+    dependencies:
+      a:
+        specifier: ^1.0.0
+        version: 1.0.0
+    devDependencies:
+      d:
+        specifier: ^1.0.0
+        version: 1.0.0
+packages:
+  a@1.0.0: {}
+  b@2.0.0: {}
+  d@1.0.0: {}
+`,
+		"index.js": "APP",
+		"node_modules/.pnpm/a@1.0.0/node_modules/a/package.json": `{"name": "a", "version": "1.0.0"}`,
+		"node_modules/.pnpm/b@2.0.0/node_modules/b/package.json": `{"name": "b", "version": "2.0.0"}`,
+		"node_modules/.pnpm/d@1.0.0/node_modules/d/package.json": `{"name": "d", "version": "1.0.0"}`,
+	})
+	nm := filepath.Join(dir, "node_modules")
+	symlink(t, ".pnpm/a@1.0.0/node_modules/a", filepath.Join(nm, "a"))
+	symlink(t, ".pnpm/d@1.0.0/node_modules/d", filepath.Join(nm, "d"))
+	symlink(t, "../../b@2.0.0/node_modules/b", filepath.Join(nm, ".pnpm/a@1.0.0/node_modules/b"))
+
+	builders, err := NewLayerBuilders(schema.Layer{NodeApp: schema.NodeApp{Dir: dir}}, Options{})
+	if err != nil {
+		t.Fatalf("NewLayerBuilders: %v", err)
+	}
+	layers, err := Build(builders, amd64())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 2 {
+		t.Fatalf("no native dependencies, expected 2 layers, got %d", len(layers))
+	}
+	expected := []string{
+		"/app/node_modules", "/app/node_modules/.pnpm",
+		"/app/node_modules/.pnpm/a@1.0.0", "/app/node_modules/.pnpm/a@1.0.0/node_modules",
+		"/app/node_modules/.pnpm/a@1.0.0/node_modules/a", "/app/node_modules/.pnpm/a@1.0.0/node_modules/a/package.json",
+		"/app/node_modules/.pnpm/a@1.0.0/node_modules/b",
+		"/app/node_modules/.pnpm/b@2.0.0", "/app/node_modules/.pnpm/b@2.0.0/node_modules",
+		"/app/node_modules/.pnpm/b@2.0.0/node_modules/b", "/app/node_modules/.pnpm/b@2.0.0/node_modules/b/package.json",
+		"/app/node_modules/a",
+	}
+	if got := fileNames(layerFiles(t, layers[0])); !equalStrings(got, expected) {
+		t.Errorf("dependencies %v", got)
+	}
+}
+
+func TestNodeListMatches(t *testing.T) {
+	for _, c := range []struct {
+		list     []string
+		value    string
+		expected bool
+	}{
+		{nil, "x64", true},
+		{[]string{"x64", "arm64"}, "arm64", true},
+		{[]string{"x64"}, "arm64", false},
+		{[]string{"!win32"}, "linux", true},
+		{[]string{"!win32"}, "win32", false},
+	} {
+		if got := nodeListMatches(c.list, c.value); got != c.expected {
+			t.Errorf("%v %s: got %v", c.list, c.value, got)
+		}
+	}
+	if nodePlatformMatches(nodePackage{cpu: []string{"x64"}}, v1.Platform{OS: "linux", Architecture: "arm64"}) {
+		t.Error("x64 is not arm64")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,310 @@
+package layers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/invopop/yaml"
+)
+
+// nodePackage is a production package from a lockfile, where path is
+// relative to node_modules: foo or foo/node_modules/bar for npm, and the
+// store dir .pnpm/foo@1.0.0 for pnpm.
+type nodePackage struct {
+	path     string
+	optional bool
+	os       []string
+	cpu      []string
+	native   bool
+	// link is installed as a symlink, which is copied as such: an npm
+	// workspace package, or a pnpm top-level dependency
+	link bool
+}
+
+// nodeLock lists the production packages of an installed node_modules.
+type nodeLock interface {
+	packages(nodeModules string) ([]nodePackage, error)
+}
+
+// readNodeLock reads the lockfile in dir, preferring npm's if both exist.
+func readNodeLock(dir string) (nodeLock, error) {
+	for _, name := range []string{"npm-shrinkwrap.json", "package-lock.json"} {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var lock npmLock
+		if err := json.Unmarshal(content, &lock); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if lock.LockfileVersion < 2 {
+			return nil, fmt.Errorf("%s: lockfileVersion %d is not supported, run npm install with npm 7 or later", name, lock.LockfileVersion)
+		}
+		return &lock, nil
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "pnpm-lock.yaml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no package-lock.json or pnpm-lock.yaml in %s", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lock pnpmLock
+	if err := yaml.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("pnpm-lock.yaml: %w", err)
+	}
+	if v, err := strconv.ParseFloat(fmt.Sprint(lock.LockfileVersion), 64); err != nil || v < 6 {
+		return nil, fmt.Errorf("pnpm-lock.yaml: lockfileVersion %v is not supported, run pnpm install with pnpm 8 or later", lock.LockfileVersion)
+	}
+	return &lock, nil
+}
+
+type npmLock struct {
+	LockfileVersion int                           `json:"lockfileVersion"`
+	Packages        map[string]npmLockPackageMeta `json:"packages"`
+}
+
+type npmLockPackageMeta struct {
+	Dev              bool     `json:"dev"`
+	DevOptional      bool     `json:"devOptional"`
+	Optional         bool     `json:"optional"`
+	Link             bool     `json:"link"`
+	HasInstallScript bool     `json:"hasInstallScript"`
+	OS               []string `json:"os"`
+	CPU              []string `json:"cpu"`
+}
+
+// packages takes everything but dev dependencies from the lockfile, as
+// npm's lockfile mirrors the node_modules tree. DevOptional packages are
+// dev dependencies that are also optional dependencies of production ones,
+// which npm install --omit=dev keeps, so they are taken as optional.
+func (l *npmLock) packages(_ string) ([]nodePackage, error) {
+	var out []nodePackage
+	for key, p := range l.Packages {
+		if !strings.HasPrefix(key, "node_modules/") || p.Dev {
+			continue
+		}
+		out = append(out, nodePackage{
+			path:     strings.TrimPrefix(key, "node_modules/"),
+			optional: p.Optional || p.DevOptional,
+			os:       p.OS,
+			cpu:      p.CPU,
+			native:   p.HasInstallScript || len(p.OS) > 0 || len(p.CPU) > 0,
+			link:     p.Link,
+		})
+	}
+	return out, nil
+}
+
+type pnpmLock struct {
+	LockfileVersion any                            `json:"lockfileVersion"`
+	Importers       map[string]pnpmImporter        `json:"importers"`
+	Packages        map[string]pnpmLockPackageMeta `json:"packages"`
+	// lockfileVersion 6 without workspaces has the root importer here
+	pnpmImporter
+}
+
+type pnpmImporter struct {
+	Dependencies         map[string]any `json:"dependencies"`
+	OptionalDependencies map[string]any `json:"optionalDependencies"`
+}
+
+type pnpmLockPackageMeta struct {
+	Optional      bool     `json:"optional"`
+	RequiresBuild bool     `json:"requiresBuild"`
+	OS            []string `json:"os"`
+	CPU           []string `json:"cpu"`
+}
+
+// packages follows the symlinks of pnpm's node_modules from the root
+// importer's production dependencies, as the lockfile's package keys do not
+// name store dirs reliably across versions.
+func (l *pnpmLock) packages(nodeModules string) ([]nodePackage, error) {
+	root := l.pnpmImporter
+	if importer, ok := l.Importers["."]; ok {
+		root = importer
+	}
+	meta := make(map[string]pnpmLockPackageMeta, len(l.Packages))
+	for key, p := range l.Packages {
+		key = strings.TrimPrefix(key, "/")
+		if i := strings.Index(key, "("); i > 0 {
+			key = key[:i]
+		}
+		meta[key] = p
+	}
+
+	var out []nodePackage
+	seen := make(map[string]bool)
+	// visitStore adds a store dir, and the store dirs of its dependencies
+	var visitStore func(store string, optional bool) error
+	visitStore = func(store string, optional bool) error {
+		if seen[store] {
+			return nil
+		}
+		seen[store] = true
+		name, version, err := pnpmStorePackage(nodeModules, store)
+		if err != nil {
+			return err
+		}
+		m := meta[name+"@"+version]
+		_, gyp := os.Stat(filepath.Join(nodeModules, store, "node_modules", filepath.FromSlash(name), "binding.gyp"))
+		out = append(out, nodePackage{
+			path:     filepath.ToSlash(store),
+			optional: optional || m.Optional,
+			os:       m.OS,
+			cpu:      m.CPU,
+			native:   m.RequiresBuild || len(m.OS) > 0 || len(m.CPU) > 0 || gyp == nil,
+		})
+		links, err := pnpmStoreLinks(filepath.Join(nodeModules, store, "node_modules"), name)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			dep, err := pnpmStoreDir(nodeModules, link)
+			if errors.Is(err, fs.ErrNotExist) && (optional || m.Optional) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if dep == "" {
+				continue
+			}
+			if err := visitStore(dep, optional || m.Optional); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// visitTop adds the top-level symlink for a dependency of the app, which
+	// points into the store or, for a workspace package, elsewhere
+	visitTop := func(name string, optional bool) error {
+		link := filepath.Join(nodeModules, filepath.FromSlash(name))
+		store, err := pnpmStoreDir(nodeModules, link)
+		if errors.Is(err, fs.ErrNotExist) && optional {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		out = append(out, nodePackage{path: name, optional: optional, link: true})
+		if store == "" {
+			return nil
+		}
+		return visitStore(store, optional)
+	}
+	for name := range root.Dependencies {
+		if err := visitTop(name, false); err != nil {
+			return nil, err
+		}
+	}
+	for name := range root.OptionalDependencies {
+		if err := visitTop(name, true); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// pnpmStoreDir returns the store dir, .pnpm/<dir>, that the symlink at link
+// points into, or "" if it points elsewhere.
+func pnpmStoreDir(nodeModules string, link string) (string, error) {
+	target, err := os.Readlink(link)
+	if err != nil {
+		if _, statErr := os.Lstat(link); statErr != nil {
+			return "", statErr
+		}
+		return "", fmt.Errorf("%s is not a symlink, only pnpm's default isolated node_modules is supported", link)
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(link), target)
+	}
+	rel, err := filepath.Rel(nodeModules, target)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) < 2 || parts[0] != ".pnpm" {
+		return "", nil
+	}
+	return filepath.Join(parts[0], parts[1]), nil
+}
+
+// pnpmStorePackage reads name and version of the package a store dir holds.
+func pnpmStorePackage(nodeModules string, store string) (string, string, error) {
+	dir := filepath.Join(nodeModules, store, "node_modules")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for _, e := range entries {
+		candidates := []string{e.Name()}
+		if strings.HasPrefix(e.Name(), "@") {
+			scoped, err := os.ReadDir(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return "", "", err
+			}
+			candidates = candidates[:0]
+			for _, s := range scoped {
+				candidates = append(candidates, e.Name()+"/"+s.Name())
+			}
+		}
+		for _, c := range candidates {
+			p := filepath.Join(dir, filepath.FromSlash(c))
+			if info, err := os.Lstat(p); err != nil || info.Mode()&os.ModeSymlink != 0 {
+				continue
+			}
+			var pkg struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			}
+			content, err := os.ReadFile(filepath.Join(p, "package.json"))
+			if err != nil {
+				return "", "", err
+			}
+			if err := json.Unmarshal(content, &pkg); err != nil {
+				return "", "", fmt.Errorf("%s: %w", p, err)
+			}
+			return pkg.Name, pkg.Version, nil
+		}
+	}
+	return "", "", fmt.Errorf("no package in %s", dir)
+}
+
+// pnpmStoreLinks returns the symlinks next to package name in a store dir's
+// node_modules, which are the package's dependencies.
+func pnpmStoreLinks(dir string, name string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		if strings.HasPrefix(e.Name(), "@") && e.IsDir() {
+			scoped, err := os.ReadDir(p)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range scoped {
+				if s.Type()&os.ModeSymlink != 0 && e.Name()+"/"+s.Name() != name {
+					links = append(links, filepath.Join(p, s.Name()))
+				}
+			}
+			continue
+		}
+		if e.Type()&os.ModeSymlink != 0 && e.Name() != name {
+			links = append(links, p)
+		}
+	}
+	return links, nil
+}
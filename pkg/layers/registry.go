@@ -0,0 +1,168 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/turbokube/contain/pkg/localdir"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+// Type is a layer type. The built in ones are in builtinTypes, and others,
+// for example one that reads from an artifact store, are added with
+// Register. A layers item selects a registered type with a key of the name
+// it is registered with, and the item's raw value for that key is in
+// schema.Layer.Extensions. Items of a registered type take layerAttributes,
+// platforms and maxCompressedSize like built in ones do.
+type Type struct {
+	// New returns the builders for an item, in layer order. Each builder is
+	// invoked per platform, like those of built in types, and should use
+	// opts, with Context and Logger set, for cancellation and logging.
+	// Relative paths in Extensions are relative to opts.ContextDir, which
+	// schema.InDir doesn't apply to extensions. Required.
+	New func(layer schema.Layer, opts Options) ([]LayerBuilder, error)
+	// Cache, if true, says that an item's layers are the same for every
+	// platform, so that each builder runs once per build and its layer, or
+	// error, is reused for the other platforms.
+	Cache bool
+	// Validate, if set, checks an item for the platforms about to be built,
+	// before anything is built or pushed.
+	Validate func(layer schema.Layer, platforms []v1.Platform) error
+	// MissingSources, if set, says why an item can't be built for platform,
+	// so that platforms: auto drops the platform. See MissingSources.
+	MissingSources func(layer schema.Layer, platform v1.Platform) []string
+}
+
+var (
+	registeredMu    sync.RWMutex
+	registeredTypes = map[string]Type{}
+)
+
+// builtinTypes are the types of schema.Layer's fields, by key
+var builtinTypes = map[string]Type{
+	"localDir": {New: func(cfg schema.Layer, opts Options) ([]LayerBuilder, error) {
+		return one(configure(localdir.NewDir(), cfg.LocalDir, cfg.Attributes, opts))
+	}},
+	"localFile": {New: func(cfg schema.Layer, opts Options) ([]LayerBuilder, error) {
+		return one(newLocalFileBuilder(cfg.LocalFile, cfg.Attributes, opts))
+	}},
+	"files": {New: func(cfg schema.Layer, opts Options) ([]LayerBuilder, error) {
+		return one(newFilesBuilder(cfg.Files, cfg.Attributes, opts), nil)
+	}},
+	"goBuild": {New: func(cfg schema.Layer, opts Options) ([]LayerBuilder, error) {
+		return one(newGoBuildBuilder(cfg.GoBuild, cfg.Attributes, opts), nil)
+	}},
+	"command": {New: func(cfg schema.Layer, opts Options) ([]LayerBuilder, error) {
+		return one(newCommandBuilder(cfg.Command, cfg.Attributes, opts), nil)
+	}},
+	"javaApp": {New: func(cfg schema.Layer, opts Options) ([]LayerBuilder, error) {
+		return newJavaAppBuilders(cfg.JavaApp, cfg.Attributes, opts), nil
+	}},
+	"nodeApp": {New: func(cfg schema.Layer, opts Options) ([]LayerBuilder, error) {
+		return newNodeAppBuilders(cfg.NodeApp, cfg.Attributes, opts)
+	}},
+}
+
+func one(b LayerBuilder, err error) ([]LayerBuilder, error) {
+	if err != nil {
+		return nil, err
+	}
+	return []LayerBuilder{b}, nil
+}
+
+// Register adds a layer type for items with the key name. It fails for
+// the keys of built in types and other layer fields, for names that look
+// like a typo of one, which configs can't use, and for a name that is
+// registered already. Register from an init func or before any build.
+func Register(name string, t Type) error {
+	if name == "" {
+		return errors.New("layer type name is empty")
+	}
+	if schema.IsBuiltinLayerKey(name) {
+		return fmt.Errorf("layer type %q is a layers item field", name)
+	}
+	if field := schema.ClosestLayerKey(name); field != "" {
+		return fmt.Errorf("layer type %q is too close to the layers item field %q", name, field)
+	}
+	if t.New == nil {
+		return fmt.Errorf("layer type %q has no New", name)
+	}
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	if _, ok := registeredTypes[name]; ok {
+		return fmt.Errorf("layer type %q is registered already", name)
+	}
+	registeredTypes[name] = t
+	return nil
+}
+
+// Registered returns the names of registered layer types, sorted
+func Registered() []string {
+	registeredMu.RLock()
+	defer registeredMu.RUnlock()
+	return sortedKeys(registeredTypes)
+}
+
+// registered returns the type for a layer type key, built in or registered
+func registered(name string) (Type, error) {
+	if t, ok := builtinTypes[name]; ok {
+		return t, nil
+	}
+	registeredMu.RLock()
+	defer registeredMu.RUnlock()
+	t, ok := registeredTypes[name]
+	if !ok {
+		if len(registeredTypes) == 0 {
+			return Type{}, fmt.Errorf("unknown layer type %q", name)
+		}
+		return Type{}, fmt.Errorf("unknown layer type %q, registered are %s", name, strings.Join(sortedKeys(registeredTypes), ", "))
+	}
+	return t, nil
+}
+
+func sortedKeys(m map[string]Type) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ValidateLayers is schema.ValidateLayers, followed by the checks of
+// registered types, and fails for an item with an unregistered type.
+func ValidateLayers(config schema.ContainConfig, platforms []v1.Platform) error {
+	if err := schema.ValidateLayers(config, platforms); err != nil {
+		return err
+	}
+	var errs []string
+	for i, layer := range config.Layers {
+		for name := range layer.Extensions {
+			t, err := registered(name)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("layers[%d]: %v", i, err))
+				continue
+			}
+			if t.Validate == nil {
+				continue
+			}
+			var applies []v1.Platform
+			for _, p := range platforms {
+				if schema.LayerAppliesTo(layer, p) {
+					applies = append(applies, p)
+				}
+			}
+			if err := t.Validate(layer, applies); err != nil {
+				errs = append(errs, fmt.Sprintf("layers[%d].%s: %v", i, name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
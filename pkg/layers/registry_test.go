@@ -0,0 +1,216 @@
+package layers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/invopop/yaml"
+	"github.com/turbokube/contain/pkg/localdir"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+// bundle is a test layer type that writes one generated file per platform
+type bundle struct {
+	Name string `json:"name"`
+}
+
+func registerBundle(t *testing.T) {
+	t.Helper()
+	registeredMu.Lock()
+	delete(registeredTypes, "bundle")
+	registeredMu.Unlock()
+	t.Cleanup(func() {
+		registeredMu.Lock()
+		delete(registeredTypes, "bundle")
+		registeredMu.Unlock()
+	})
+	parse := func(layer schema.Layer) (bundle, error) {
+		var b bundle
+		err := json.Unmarshal(layer.Extensions["bundle"], &b)
+		return b, err
+	}
+	err := Register("bundle", Type{
+		New: func(layer schema.Layer, opts Options) ([]LayerBuilder, error) {
+			b, err := parse(layer)
+			if err != nil {
+				return nil, err
+			}
+			return []LayerBuilder{func(platform v1.Platform) (v1.Layer, error) {
+				src := filepath.Join(t.TempDir(), b.Name)
+				if err := os.WriteFile(src, []byte(platform.Architecture), 0o644); err != nil {
+					return nil, err
+				}
+				return localdir.FromFileMappingsContext(opts.Context, []localdir.FileMapping{{Src: src, Dst: "/bundles/" + b.Name}}, layer.Attributes, opts.Logger)
+			}}, nil
+		},
+		Validate: func(layer schema.Layer, platforms []v1.Platform) error {
+			b, err := parse(layer)
+			if err != nil {
+				return err
+			}
+			if b.Name == "" {
+				return errors.New("name is required")
+			}
+			return nil
+		},
+		MissingSources: func(layer schema.Layer, platform v1.Platform) []string {
+			if platform.Architecture == "s390x" {
+				return []string{"no bundle for s390x"}
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func parseLayers(t *testing.T, config string) schema.ContainConfig {
+	t.Helper()
+	var c schema.ContainConfig
+	if err := yaml.Unmarshal([]byte(config), &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRegisteredType(t *testing.T) {
+	registerBundle(t)
+	c := parseLayers(t, `
+layers:
+- bundle:
+    name: protos.tar
+  platforms: [linux/arm64]
+  layerAttributes:
+    uid: 65532
+`)
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64"}
+	if err := ValidateLayers(c, []v1.Platform{amd64, arm64}); err != nil {
+		t.Fatal(err)
+	}
+	builders, err := NewLayerBuilders(c.Layers[0], Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	built, err := Build(builders, arm64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(built) != 1 {
+		t.Fatalf("got %d layers for arm64", len(built))
+	}
+	if got := layerFiles(t, built[0])["/bundles/protos.tar"]; got != "arm64" {
+		t.Errorf("got %q", got)
+	}
+	if built, _ := Build(builders, amd64); len(built) != 0 {
+		t.Errorf("platforms selector should exclude amd64, got %d layers", len(built))
+	}
+	if got := MissingSources(c.Layers, v1.Platform{OS: "linux", Architecture: "s390x"}); len(got) != 0 {
+		t.Errorf("s390x is not in the platforms selector, got %v", got)
+	}
+
+	invalid := parseLayers(t, "layers:\n- bundle: {}\n")
+	if err := ValidateLayers(invalid, []v1.Platform{amd64}); err == nil || !strings.Contains(err.Error(), "layers[0].bundle: name is required") {
+		t.Errorf("got %v", err)
+	}
+	both := parseLayers(t, "layers:\n- bundle: {name: x}\n  localDir: {path: .}\n")
+	if err := ValidateLayers(both, []v1.Platform{amd64}); err == nil || !strings.Contains(err.Error(), "exactly one type") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRegisteredTypeMissingSources(t *testing.T) {
+	registerBundle(t)
+	c := parseLayers(t, "layers:\n- bundle: {name: x}\n")
+	got := MissingSources(c.Layers, v1.Platform{OS: "linux", Architecture: "s390x"})
+	if fmt.Sprint(got) != "[layers[0].bundle: no bundle for s390x]" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestUnregisteredType(t *testing.T) {
+	c := parseLayers(t, "layers:\n- artifactStore: {id: 1}\n")
+	if _, err := NewLayerBuilders(c.Layers[0], Options{}); err == nil || !strings.Contains(err.Error(), `unknown layer type "artifactStore"`) {
+		t.Errorf("got %v", err)
+	}
+	if err := ValidateLayers(c, nil); err == nil || !strings.Contains(err.Error(), `layers[0]: unknown layer type "artifactStore"`) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	registerBundle(t)
+	noop := func(schema.Layer, Options) ([]LayerBuilder, error) { return nil, nil }
+	for _, name := range []string{"localDir", "platforms", "layerAttributes", "localdir", "localFiles", "bundle", ""} {
+		if err := Register(name, Type{New: noop}); err == nil {
+			t.Errorf("Register %q should fail", name)
+		}
+	}
+	if err := Register("other", Type{}); err == nil {
+		t.Error("Register without New should fail")
+	}
+}
+
+func TestRegisteredTypeCache(t *testing.T) {
+	registeredMu.Lock()
+	delete(registeredTypes, "generated")
+	registeredMu.Unlock()
+	t.Cleanup(func() {
+		registeredMu.Lock()
+		delete(registeredTypes, "generated")
+		registeredMu.Unlock()
+	})
+	var contextDir string
+	calls := 0
+	err := Register("generated", Type{
+		Cache: true,
+		New: func(layer schema.Layer, opts Options) ([]LayerBuilder, error) {
+			contextDir = opts.ContextDir
+			return []LayerBuilder{func(platform v1.Platform) (v1.Layer, error) {
+				calls++
+				src := filepath.Join(t.TempDir(), "gen")
+				if err := os.WriteFile(src, []byte(platform.Architecture), 0o644); err != nil {
+					return nil, err
+				}
+				return localdir.FromFileMappingsContext(opts.Context, []localdir.FileMapping{{Src: src, Dst: "/gen"}}, layer.Attributes, opts.Logger)
+			}}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := parseLayers(t, "layers:\n- generated: {}\n")
+	dir := t.TempDir()
+	builders, err := NewLayerBuilders(c.Layers[0], Options{ContextDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contextDir != dir {
+		t.Errorf("got context dir %q", contextDir)
+	}
+	a, err := Build(builders, amd64())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Build(builders, arm64())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || a[0] != b[0] {
+		t.Errorf("expected one build for both platforms, got %d", calls)
+	}
+
+	if _, err := NewLayerBuilders(c.Layers[0], Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if wd, _ := os.Getwd(); contextDir != wd {
+		t.Errorf("context dir should default to the current dir, got %q", contextDir)
+	}
+}
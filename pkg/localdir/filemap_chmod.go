@@ -34,6 +34,9 @@ type FileInfo struct {
 	IsDir      bool
 	IsSymlink  bool
 	LinkTarget string
+	// ModeOverride, if non-zero, is the mode for this file regardless of
+	// attributes and of the source's executable bit
+	ModeOverride int64
 }
 
 // Layer creates a layer from a single file map. These layers are reproducible and consistent.
@@ -115,10 +118,13 @@ func LayerFromFiles(files []FileInfo, attributes schema.LayerAttributes) (v1.Lay
 // calculateFileMode determines the appropriate file mode based on requirements:
 // - Use 0644 for files and 0755 for directories by default
 // - Preserve executable bit from source files
-// - Allow override via layer attributes
+// - Allow override via layer attributes, or per file via ModeOverride
 func calculateFileMode(file FileInfo, attributes schema.LayerAttributes) int64 {
 	var mode int64
 
+	if file.ModeOverride != 0 {
+		return file.ModeOverride
+	}
 	if file.IsDir {
 		mode = defaultDirMode
 		if attributes.DirMode != 0 {
@@ -0,0 +1,118 @@
+package localdir
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+// FileMapping is a files layer entry with its source resolved for the
+// platform being built.
+type FileMapping struct {
+	// Src is a file path or a glob
+	Src string
+	// Dst is the container path, a directory if it ends with a slash or if
+	// Src is a glob
+	Dst string
+	// Mode overrides layer attributes for this entry's files if non-zero
+	Mode int32
+}
+
+// FromFileMappings creates one reproducible layer holding every file the
+// mappings select. Entries are independent of each other, but two files
+// may not map to the same container path. The layer contains files only;
+// parent directories are left to the base image, as for a localFile layer.
+func FromFileMappings(mappings []FileMapping, attributes schema.LayerAttributes) (v1.Layer, error) {
+	return FromFileMappingsContext(context.Background(), mappings, attributes, zap.L())
+}
+
+// FromFileMappingsContext is FromFileMappings that stops once ctx is done
+// and logs to log
+func FromFileMappingsContext(ctx context.Context, mappings []FileMapping, attributes schema.LayerAttributes, log *zap.Logger) (v1.Layer, error) {
+	var files []FileInfo
+	sources := make(map[string]string)
+	for i, m := range mappings {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		srcs, dstIsDir, err := expandSrc(m, log)
+		if err != nil {
+			return nil, fmt.Errorf("files[%d]: %w", i, err)
+		}
+		for _, src := range srcs {
+			to := m.Dst
+			if dstIsDir {
+				to = path.Join(m.Dst, filepath.Base(src))
+			}
+			if prev, ok := sources[to]; ok {
+				return nil, fmt.Errorf("files[%d]: %s and %s both map to %s", i, prev, src, to)
+			}
+			sources[to] = src
+			info, err := os.Stat(src)
+			if err != nil {
+				return nil, fmt.Errorf("files[%d]: %w", i, err)
+			}
+			content, err := os.ReadFile(src)
+			if err != nil {
+				return nil, fmt.Errorf("files[%d]: %w", i, err)
+			}
+			files = append(files, FileInfo{
+				Path:         to,
+				Content:      content,
+				Mode:         info.Mode(),
+				ModeOverride: int64(m.Mode),
+			})
+			log.Debug("added file",
+				zap.String("from", src),
+				zap.String("to", to),
+				zap.Int("size", len(content)),
+			)
+		}
+	}
+	return LayerFromFiles(files, attributes)
+}
+
+// expandSrc returns the regular files a mapping selects, in sorted order,
+// and whether Dst is to be read as a directory.
+func expandSrc(m FileMapping, log *zap.Logger) ([]string, bool, error) {
+	if !strings.HasPrefix(m.Dst, "/") {
+		return nil, false, fmt.Errorf("dst must be an absolute path, got %q", m.Dst)
+	}
+	if !strings.ContainsAny(m.Src, "*?[") {
+		info, err := os.Stat(m.Src)
+		if err != nil {
+			return nil, false, err
+		}
+		if info.IsDir() {
+			return nil, false, fmt.Errorf("src %s is a directory, use a glob or a localDir layer", m.Src)
+		}
+		return []string{m.Src}, strings.HasSuffix(m.Dst, "/"), nil
+	}
+	matches, err := filepath.Glob(m.Src)
+	if err != nil {
+		return nil, false, fmt.Errorf("src %s: %w", m.Src, err)
+	}
+	var srcs []string
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, false, err
+		}
+		if info.IsDir() {
+			log.Debug("glob match is a directory, skipped", zap.String("src", m.Src), zap.String("match", match))
+			continue
+		}
+		srcs = append(srcs, match)
+	}
+	if len(srcs) == 0 {
+		return nil, false, fmt.Errorf("src %s matched no files", m.Src)
+	}
+	return srcs, true, nil
+}
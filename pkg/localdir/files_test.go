@@ -0,0 +1,83 @@
+package localdir_test
+
+import (
+	"archive/tar"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/turbokube/contain/pkg/localdir"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+func TestFromFileMappings(t *testing.T) {
+	mappings := []localdir.FileMapping{
+		{Src: "./testdata/reproducible/script.sh", Dst: "/usr/local/bin/run"},
+		{Src: "./testdata/reproducible/*.txt", Dst: "/etc/app", Mode: 0600},
+		{Src: "./testdata/dir1/a.txt", Dst: "/srv/"},
+	}
+	layer, err := localdir.FromFileMappings(mappings, schema.LayerAttributes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	var names []string
+	entries := make(map[string]*tar.Header)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+		entries[header.Name] = header
+	}
+	expected := "/etc/app/normal.txt,/etc/app/symlink.txt,/srv/a.txt,/usr/local/bin/run"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("entries %v, expected %s", names, expected)
+	}
+	if entries["/usr/local/bin/run"].Mode != 0755 {
+		t.Errorf("run should keep the executable bit, got %o", entries["/usr/local/bin/run"].Mode)
+	}
+	if entries["/etc/app/normal.txt"].Mode != 0600 {
+		t.Errorf("normal.txt should have the entry's mode 0600, got %o", entries["/etc/app/normal.txt"].Mode)
+	}
+	if entries["/etc/app/symlink.txt"].Typeflag != tar.TypeReg {
+		t.Errorf("symlinks are followed, got type %c", entries["/etc/app/symlink.txt"].Typeflag)
+	}
+
+	again, err := localdir.FromFileMappings(mappings, schema.LayerAttributes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d1, _ := layer.Digest()
+	d2, _ := again.Digest()
+	if d1 != d2 {
+		t.Errorf("digest differs between builds: %s %s", d1, d2)
+	}
+}
+
+func TestFromFileMappingsErrors(t *testing.T) {
+	cases := map[string][]localdir.FileMapping{
+		"both map to /etc/a.txt": {
+			{Src: "./testdata/dir1/a.txt", Dst: "/etc/"},
+			{Src: "./testdata/dir1/subdir/b.txt", Dst: "/etc/a.txt"},
+		},
+		"matched no files":             {{Src: "./testdata/dir1/*.none", Dst: "/etc"}},
+		"is a directory":               {{Src: "./testdata/dir1", Dst: "/etc"}},
+		"dst must be an absolute path": {{Src: "./testdata/dir1/a.txt", Dst: "etc/a.txt"}},
+	}
+	for expected, mappings := range cases {
+		_, err := localdir.FromFileMappings(mappings, schema.LayerAttributes{})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error containing %q, got %v", expected, err)
+		}
+	}
+}
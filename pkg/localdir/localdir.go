@@ -1,10 +1,10 @@
 package localdir
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,6 +24,17 @@ type From struct {
 	Ignore        *patternmatcher.PatternMatcher
 	MaxFiles      int
 	MaxSize       int
+	// Context, if set, stops the walk once it is done
+	Context context.Context
+	// Log defaults to zap.L()
+	Log *zap.Logger
+}
+
+func (dir From) log() *zap.Logger {
+	if dir.Log == nil {
+		return zap.L()
+	}
+	return dir.Log
 }
 
 func NewFile() From {
@@ -40,19 +51,19 @@ func NewDir() From {
 	}
 }
 
-func NewPathMapperPrepend(prependDir string) PathMapper {
+func NewPathMapperPrepend(prependDir string) (PathMapper, error) {
 	if !strings.HasPrefix(prependDir, "/") {
-		log.Fatalf("prependDir must have leading slash, got: %s", prependDir)
+		return nil, fmt.Errorf("prependDir must have leading slash, got: %s", prependDir)
 	}
 	if strings.HasSuffix(prependDir, "/") {
-		log.Fatalf("prependDir should be a path without trailing slash, got: %s", prependDir)
+		return nil, fmt.Errorf("prependDir should be a path without trailing slash, got: %s", prependDir)
 	}
 	return func(original string) string {
 		if original == "." {
 			return prependDir
 		}
 		return fmt.Sprintf("%s/%s", prependDir, original)
-	}
+	}, nil
 }
 
 func NewPathMapperAsIs() PathMapper {
@@ -90,11 +101,15 @@ func FromFilesystemWithMetadata(dir From, attributes schema.LayerAttributes) (v1
 	// Directories we've seen, to ensure we add them to the tar
 	seenDirs := make(map[string]bool)
 
+	log := dir.log()
 	add := func(path string, d fs.DirEntry, err error) error {
+		if dir.Context != nil && dir.Context.Err() != nil {
+			return dir.Context.Err()
+		}
 		if err != nil {
-			zap.L().Error("walk", zap.String("dir", dir.Path), zap.String("path", path), zap.Error(err))
+			log.Error("walk", zap.String("dir", dir.Path), zap.String("path", path), zap.Error(err))
 			if path == "." && d == nil && !dir.isFile {
-				zap.L().Info("To add a single file use a localFile layer instead of localDir", zap.String("path", dir.Path))
+				log.Info("To add a single file use a localFile layer instead of localDir", zap.String("path", dir.Path))
 				return errors.New("localDir configured for what looks like a file: " + dir.Path)
 			}
 			return err
@@ -105,7 +120,7 @@ func FromFilesystemWithMetadata(dir From, attributes schema.LayerAttributes) (v1
 			return err
 		}
 		if ignore {
-			zap.L().Debug("ignored", zap.String("path", path))
+			log.Debug("ignored", zap.String("path", path))
 			return nil
 		}
 
@@ -163,13 +178,13 @@ func FromFilesystemWithMetadata(dir From, attributes schema.LayerAttributes) (v1
 					IsSymlink:  true,
 					LinkTarget: linkTarget,
 				})
-				zap.L().Debug("added symlink",
+				log.Debug("added symlink",
 					zap.String("from", path),
 					zap.String("to", topath),
 					zap.String("target", linkTarget),
 				)
 			} else {
-				zap.L().Warn("skipping symlink pointing outside source tree",
+				log.Warn("skipping symlink pointing outside source tree",
 					zap.String("path", path),
 					zap.String("target", linkTarget),
 				)
@@ -200,7 +215,7 @@ func FromFilesystemWithMetadata(dir From, attributes schema.LayerAttributes) (v1
 			IsSymlink: false,
 		})
 
-		zap.L().Debug("added file",
+		log.Debug("added file",
 			zap.String("from", path),
 			zap.String("to", topath),
 			zap.Int("size", len(file)),
@@ -220,13 +235,13 @@ func FromFilesystemWithMetadata(dir From, attributes schema.LayerAttributes) (v1
 	}
 
 	if err != nil {
-		zap.L().Error("layer buffer failed", zap.Int("files", len(files)), zap.Int("bytes", bytesTotal), zap.Error(err))
+		log.Error("layer buffer failed", zap.Int("files", len(files)), zap.Int("bytes", bytesTotal), zap.Error(err))
 		return nil, err
 	}
-	zap.L().Info("layer buffer created", zap.Int("files", len(files)), zap.Int("bytes", bytesTotal))
+	log.Info("layer buffer created", zap.Int("files", len(files)), zap.Int("bytes", bytesTotal))
 
 	if len(files) == 0 {
-		return nil, fmt.Errorf("dir resulted in empty layer: %v", dir)
+		return nil, fmt.Errorf("dir resulted in empty layer: %s", dir.Path)
 	}
 
 	return LayerFromFiles(files, attributes)
@@ -80,7 +80,7 @@ func TestFromFilesystemDir1(t *testing.T) {
 
 	expectDigest(localdir.From{
 		Path:          "./testdata/dir1",
-		ContainerPath: localdir.NewPathMapperPrepend("/app"),
+		ContainerPath: prepend(t, "/app"),
 	}, "sha256:fe7dfab2d0a720ae7271d16ff803544d01bfa08ed87c613383a2664b45e88125", t)
 
 	ignoreA, err := patternmatcher.New([]string{"a.*"})
@@ -89,7 +89,7 @@ func TestFromFilesystemDir1(t *testing.T) {
 	}
 	expectDigest(localdir.From{
 		Path:          "./testdata/dir1",
-		ContainerPath: localdir.NewPathMapperPrepend("/app"),
+		ContainerPath: prepend(t, "/app"),
 		Ignore:        ignoreA,
 	}, "sha256:befccdb1423b50fdf5691e8126c80b875d449340c31ef5efd9a97cd1a0ee707c", t)
 
@@ -99,7 +99,7 @@ func TestFromFilesystemDir1(t *testing.T) {
 	}
 	result, err := localdir.FromFilesystem(localdir.From{
 		Path:          "./testdata/dir1",
-		ContainerPath: localdir.NewPathMapperPrepend("/app"),
+		ContainerPath: prepend(t, "/app"),
 		Ignore:        ignoreAll,
 	}, schema.LayerAttributes{})
 	if err == nil {
@@ -135,9 +135,21 @@ func TestPathMapperAsIs(t *testing.T) {
 
 func TestNewPathMapperPrepend(t *testing.T) {
 	RegisterTestingT(t)
-	mapper := localdir.NewPathMapperPrepend("/prep")
+	mapper := prepend(t, "/prep")
 	Expect(mapper("t")).To(Equal("/prep/t"))
 	Expect(mapper(".")).To(Equal("/prep"))
+	_, err := localdir.NewPathMapperPrepend("prep")
+	Expect(err).To(HaveOccurred())
+	_, err = localdir.NewPathMapperPrepend("/prep/")
+	Expect(err).To(HaveOccurred())
+}
+
+func prepend(t *testing.T, dir string) localdir.PathMapper {
+	mapper, err := localdir.NewPathMapperPrepend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mapper
 }
 
 func TestReproducibleBuilds(t *testing.T) {
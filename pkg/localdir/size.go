@@ -2,14 +2,36 @@ package localdir
 
 import (
 	"fmt"
-	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
-// NewSize should eventually support https://kubernetes.io/docs/concepts/configuration/manage-resources-containers/#meaning-of-memory
+// NewSize parses a size in bytes using Kubernetes quantity syntax, see
+// https://kubernetes.io/docs/concepts/configuration/manage-resources-containers/#meaning-of-memory
+// so 100Mi is 100*1024*1024, 100M is 100*1000*1000 and plain digits are bytes.
 func NewSize(config string) (int, error) {
-	s, err := strconv.ParseInt(config, 10, 0)
+	q, err := resource.ParseQuantity(config)
 	if err != nil {
-		return 0, fmt.Errorf("maxSize only supports numeric bytes syntax at the moment, got: %s", config)
+		return 0, fmt.Errorf("size must be bytes or a quantity such as 100Mi, got: %s", config)
+	}
+	s := q.Value()
+	if s < 0 || q.CmpInt64(s) != 0 {
+		return 0, fmt.Errorf("size must be a whole, non-negative number of bytes, got: %s", config)
+	}
+	return int(s), nil
+}
+
+// FormatSize renders bytes for humans in binary units, for messages about
+// sizes configured with NewSize syntax.
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
 	}
-	return int(s), err
+	return fmt.Sprintf("%.1f%ci", float64(bytes)/float64(div), "KMGTP"[exp])
 }
@@ -1,7 +1,7 @@
 package localdir_test
 
 import (
-	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/turbokube/contain/pkg/localdir"
@@ -21,11 +21,43 @@ func TestParse(t *testing.T) {
 	if err == nil {
 		t.Errorf("should reject no bytes notation, got %d", s)
 	}
+	if !strings.Contains(err.Error(), "100Mi") {
+		t.Errorf("should clarify supported format, got: %v", err)
+	}
+
+	s, err = localdir.NewSize("100Mi")
+	if err != nil || s != 100*1024*1024 {
+		t.Errorf("binary suffix %d %v", s, err)
+	}
 
-	_, err = localdir.NewSize("100M")
-	scopedout := fmt.Sprintf("%v", err)
-	if scopedout != "maxSize only supports numeric bytes syntax at the moment, got: 100M" {
-		t.Errorf("should clarify supported format, got: %s", scopedout)
+	s, err = localdir.NewSize("100M")
+	if err != nil || s != 100*1000*1000 {
+		t.Errorf("decimal suffix %d %v", s, err)
 	}
 
+	s, err = localdir.NewSize("1.5Gi")
+	if err != nil || s != 1536*1024*1024 {
+		t.Errorf("fraction of a unit %d %v", s, err)
+	}
+
+	if _, err = localdir.NewSize("0.5"); err == nil {
+		t.Error("should reject fractional bytes")
+	}
+	if _, err = localdir.NewSize("-1Ki"); err == nil {
+		t.Error("should reject negative sizes")
+	}
+
+}
+
+func TestFormatSize(t *testing.T) {
+	for in, expected := range map[int64]string{
+		12:                "12B",
+		2048:              "2.0Ki",
+		100 * 1024 * 1024: "100.0Mi",
+		3 << 30:           "3.0Gi",
+	} {
+		if got := localdir.FormatSize(in); got != expected {
+			t.Errorf("%d: got %s, expected %s", in, got, expected)
+		}
+	}
 }
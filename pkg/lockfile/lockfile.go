@@ -0,0 +1,217 @@
+// Package lockfile pins tag-only base references to digests, in a
+// contain.lock file next to contain.yaml, so that builds are repeatable
+// without digests in the config.
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/invopop/yaml"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+// Name is the lockfile's name, in the config's directory
+const Name = "contain.lock"
+
+const version = 1
+
+const header = "# Written by contain lock. Base references pinned to digests.\n"
+
+// Lock is the content of contain.lock
+type Lock struct {
+	Version int `json:"version"`
+	// Bases maps references as written in config to what they resolved to
+	Bases map[string]Entry `json:"bases"`
+}
+
+// Entry is a pinned reference
+type Entry struct {
+	Digest string `json:"digest"`
+}
+
+// Resolver returns the digest a tag currently points to
+type Resolver func(ref name.Reference) (v1.Hash, error)
+
+// Path returns the lockfile path for a config path. A config from stdin
+// has its lockfile in the working directory.
+func Path(configPath string) string {
+	if configPath == "-" || configPath == "" {
+		return Name
+	}
+	return filepath.Join(filepath.Dir(configPath), Name)
+}
+
+// Read returns the lock at path, or nil if there is no such file.
+func Read(path string) (*Lock, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l Lock
+	if err := yaml.Unmarshal(content, &l); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if l.Version != version {
+		return nil, fmt.Errorf("%s: version %d is not supported, run contain lock", path, l.Version)
+	}
+	return &l, nil
+}
+
+// Write writes the lock to path.
+func (l *Lock) Write(path string) error {
+	content, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(header), content...), 0o644)
+}
+
+// Refs returns the references in config that have no digest, sorted.
+func Refs(config schema.ContainConfig) ([]string, error) {
+	var refs []string
+	for _, ref := range config.Bases() {
+		parsed, err := name.ParseReference(ref)
+		if err != nil {
+			return nil, fmt.Errorf("base %s: %w", ref, err)
+		}
+		if _, ok := parsed.(name.Tag); ok {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return slices.Compact(refs), nil
+}
+
+// New resolves every reference in config that has no digest, logging each
+// to log.
+func New(config schema.ContainConfig, resolve Resolver, log *zap.Logger) (*Lock, error) {
+	refs, err := Refs(config)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lock{Version: version, Bases: make(map[string]Entry, len(refs))}
+	for _, ref := range refs {
+		digest, err := resolveRef(ref, resolve)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("locked", zap.String("ref", ref), zap.String("digest", digest.String()))
+		l.Bases[ref] = Entry{Digest: digest.String()}
+	}
+	return l, nil
+}
+
+func resolveRef(ref string, resolve Resolver) (v1.Hash, error) {
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	digest, err := resolve(parsed)
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("resolve %s: %w", ref, err)
+	}
+	return digest, nil
+}
+
+// Stale returns the problems that make l out of date for config: references
+// that are not locked, and locked references that config no longer has.
+// A nil lock is stale if config has any reference without a digest.
+func (l *Lock) Stale(config schema.ContainConfig) ([]string, error) {
+	refs, err := Refs(config)
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	used := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		used[ref] = true
+		if l == nil {
+			stale = append(stale, fmt.Sprintf("%s is not locked", ref))
+			continue
+		}
+		if _, ok := l.Bases[ref]; !ok {
+			stale = append(stale, fmt.Sprintf("%s is not locked", ref))
+		}
+	}
+	if l != nil {
+		locked := make([]string, 0, len(l.Bases))
+		for ref := range l.Bases {
+			locked = append(locked, ref)
+		}
+		sort.Strings(locked)
+		for _, ref := range locked {
+			if !used[ref] {
+				stale = append(stale, fmt.Sprintf("%s is locked but not in config", ref))
+			}
+		}
+	}
+	return stale, nil
+}
+
+// Pin replaces references without a digest in config with the tagged
+// digest from the lock. With locked a missing or stale lock is an error,
+// else it is logged to log and references that aren't locked are resolved
+// now.
+func (l *Lock) Pin(config *schema.ContainConfig, locked bool, resolve Resolver, log *zap.Logger) error {
+	stale, err := l.Stale(*config)
+	if err != nil {
+		return err
+	}
+	if len(stale) > 0 {
+		if locked {
+			return fmt.Errorf("%s is missing or stale, run contain lock: %v", Name, stale)
+		}
+		log.Warn("lockfile missing or stale, run contain lock", zap.Strings("stale", stale))
+	}
+	refs, err := Refs(*config)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		var digest string
+		if l != nil {
+			digest = l.Bases[ref].Digest
+		}
+		if digest == "" {
+			resolved, err := resolveRef(ref, resolve)
+			if err != nil {
+				return err
+			}
+			digest = resolved.String()
+			log.Warn("resolved tag without lock", zap.String("ref", ref), zap.String("digest", digest))
+		}
+		if config.Base == ref {
+			config.Base = ref + "@" + digest
+		}
+		for k, base := range config.BasePerPlatform {
+			if base == ref {
+				config.BasePerPlatform[k] = ref + "@" + digest
+			}
+		}
+	}
+	return nil
+}
+
+// RemoteResolver resolves tags with a registry HEAD request.
+func RemoteResolver(options ...remote.Option) Resolver {
+	return func(ref name.Reference) (v1.Hash, error) {
+		desc, err := remote.Head(ref, options...)
+		if err != nil {
+			return v1.Hash{}, err
+		}
+		return desc.Digest, nil
+	}
+}
@@ -0,0 +1,132 @@
+package lockfile
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+const digestA = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+const digestB = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+func fixedResolver(t *testing.T, digests map[string]string) Resolver {
+	return func(ref name.Reference) (v1.Hash, error) {
+		d, ok := digests[ref.String()]
+		if !ok {
+			t.Errorf("unexpected resolve of %s", ref)
+			return v1.Hash{}, errors.New("not found")
+		}
+		return v1.NewHash(d)
+	}
+}
+
+func TestPath(t *testing.T) {
+	for configPath, expected := range map[string]string{
+		"contain.yaml":          "contain.lock",
+		"svc/a/contain.yaml":    "svc/a/contain.lock",
+		"-":                     "contain.lock",
+		"/abs/dir/contain.yaml": "/abs/dir/contain.lock",
+	} {
+		if got := Path(configPath); got != filepath.FromSlash(expected) {
+			t.Errorf("%s: got %s", configPath, got)
+		}
+	}
+}
+
+func TestRefs_OnlyTagOnly(t *testing.T) {
+	refs, err := Refs(schema.ContainConfig{Base: "example.net/base:1@" + digestA})
+	if err != nil || len(refs) != 0 {
+		t.Errorf("a digest base needs no lock, got %v %v", refs, err)
+	}
+	refs, err = Refs(schema.ContainConfig{Base: "example.net/base:1"})
+	if err != nil || len(refs) != 1 || refs[0] != "example.net/base:1" {
+		t.Errorf("got %v %v", refs, err)
+	}
+}
+
+func TestRefs_BasePerPlatform(t *testing.T) {
+	refs, err := Refs(schema.ContainConfig{
+		Base: "example.net/base:1",
+		BasePerPlatform: map[string]string{
+			"linux/arm64":   "example.net/vendor-arm:1",
+			"linux/riscv64": "example.net/base:1",
+			"linux/s390x":   "example.net/base:2@" + digestA,
+		},
+	})
+	if err != nil || len(refs) != 2 || refs[0] != "example.net/base:1" || refs[1] != "example.net/vendor-arm:1" {
+		t.Errorf("got %v %v", refs, err)
+	}
+}
+
+func TestNewWriteRead(t *testing.T) {
+	config := schema.ContainConfig{Base: "example.net/base:1"}
+	lock, err := New(config, fixedResolver(t, map[string]string{"example.net/base:1": digestA}), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), Name)
+	if err := lock.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Bases["example.net/base:1"].Digest != digestA {
+		t.Errorf("got %v", read.Bases)
+	}
+	missing, err := Read(filepath.Join(t.TempDir(), Name))
+	if err != nil || missing != nil {
+		t.Errorf("a missing lock is nil, got %v %v", missing, err)
+	}
+}
+
+func TestPin(t *testing.T) {
+	lock := &Lock{Version: version, Bases: map[string]Entry{"example.net/base:1": {Digest: digestA}}}
+	config := schema.ContainConfig{Base: "example.net/base:1"}
+	if err := lock.Pin(&config, true, fixedResolver(t, nil), zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	if config.Base != "example.net/base:1@"+digestA {
+		t.Errorf("got %s", config.Base)
+	}
+}
+
+func TestPin_Stale(t *testing.T) {
+	lock := &Lock{Version: version, Bases: map[string]Entry{"example.net/base:1": {Digest: digestA}}}
+	config := schema.ContainConfig{Base: "example.net/base:2"}
+	err := lock.Pin(&config, true, fixedResolver(t, nil), zap.NewNop())
+	if err == nil {
+		t.Fatal("expected --locked to fail on a stale lock")
+	}
+	for _, expected := range []string{"example.net/base:2 is not locked", "example.net/base:1 is locked but not in config"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %v", expected, err)
+		}
+	}
+
+	if err := lock.Pin(&config, false, fixedResolver(t, map[string]string{"example.net/base:2": digestB}), zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	if config.Base != "example.net/base:2@"+digestB {
+		t.Errorf("without --locked the tag is resolved, got %s", config.Base)
+	}
+}
+
+func TestPin_MissingLock(t *testing.T) {
+	var lock *Lock
+	config := schema.ContainConfig{Base: "example.net/base:1"}
+	if err := lock.Pin(&config, true, fixedResolver(t, nil), zap.NewNop()); err == nil {
+		t.Error("expected --locked to fail without a lock")
+	}
+	pinned := schema.ContainConfig{Base: "example.net/base@" + digestA}
+	if err := lock.Pin(&pinned, true, fixedResolver(t, nil), zap.NewNop()); err != nil {
+		t.Errorf("a config with digests needs no lock, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package multiarch
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ChildTagData is what a childTag template can refer to
+type ChildTagData struct {
+	// Tag is the tag of the index, latest if the config tag has none
+	Tag string
+	OS  string
+	// Arch is the architecture, amd64 or arm64 for example
+	Arch    string
+	Variant string
+	// Platform is os-arch[-variant], which is valid in a tag
+	Platform string
+}
+
+// childTags renders tags for index children in the index's repository
+type childTags struct {
+	template *template.Template
+}
+
+// newChildTags parses the childTag config, nil if it is empty
+func newChildTags(text string) (*childTags, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New("childTag").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("childTag: %w", err)
+	}
+	return &childTags{template: t}, nil
+}
+
+// render returns the child tag for p in the repository of index
+func (c *childTags) render(index name.Reference, p v1.Platform) (name.Tag, error) {
+	data := ChildTagData{
+		Tag:      "latest",
+		OS:       p.OS,
+		Arch:     p.Architecture,
+		Variant:  p.Variant,
+		Platform: strings.Join(nonEmpty(p.OS, p.Architecture, p.Variant), "-"),
+	}
+	if t, ok := index.(name.Tag); ok {
+		data.Tag = t.TagStr()
+	}
+	var b strings.Builder
+	if err := c.template.Execute(&b, data); err != nil {
+		return name.Tag{}, fmt.Errorf("childTag for %s: %w", p.String(), err)
+	}
+	// validated separately, Context().Tag would accept anything
+	if _, err := name.NewTag(index.Context().String() + ":" + b.String()); err != nil {
+		return name.Tag{}, fmt.Errorf("childTag for %s: %w", p.String(), err)
+	}
+	return index.Context().Tag(b.String()), nil
+}
+
+// renderAll returns a child tag per platform, or an error if two are equal
+// or one is the index tag.
+func (c *childTags) renderAll(index name.Reference, platforms []v1.Platform) ([]name.Tag, error) {
+	tags := make([]name.Tag, len(platforms))
+	seen := map[string]string{index.Identifier(): "the index"}
+	for i, p := range platforms {
+		t, err := c.render(index, p)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[t.TagStr()]; ok {
+			return nil, fmt.Errorf("childTag %s for %s is also the tag of %s", t.TagStr(), p.String(), other)
+		}
+		seen[t.TagStr()] = p.String()
+		tags[i] = t
+	}
+	return tags, nil
+}
+
+func nonEmpty(s ...string) []string {
+	out := make([]string, 0, len(s))
+	for _, v := range s {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -2,13 +2,17 @@ package multiarch
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/match"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/turbokube/contain/pkg/ocipush"
 	"github.com/turbokube/contain/pkg/platform"
 	"github.com/turbokube/contain/pkg/pushed"
 	"github.com/turbokube/contain/pkg/registry"
@@ -24,9 +28,52 @@ type IndexManifests struct {
 	// basePlatforms is every platform the base index declares, in index
 	// order, including those the platforms config excluded. Kept so that
 	// errors about an unmatched request can show what the base does offer.
+	// Platforms from a nested index are followed by their path.
 	basePlatforms []string
 	indexStart    v1.ImageIndex
 	prototype     *ToAppend
+	// convertToOCI is set for a Docker base with mediaTypes: oci
+	convertToOCI bool
+	// childTags is nil unless children are also tagged
+	childTags *childTags
+	// upload is how manifests are put, see WithUploadOptions
+	upload ocipush.Options
+	// beforeTag, if set, can refuse the result before any tag is put
+	beforeTag func(index v1.Hash, children []v1.Hash) error
+	log       *zap.Logger
+}
+
+// WithUploadOptions sets the options, such as Events, for the index and
+// child tag puts. Credentials, transport and immutable tags come from the
+// tag registry.
+func (m *IndexManifests) WithUploadOptions(upload ocipush.Options) {
+	m.upload = upload
+}
+
+// WithBeforeTag sets a check of the index digest and the children's, in
+// ChildTags order, that runs once the children are pushed by digest and
+// before the index or any child is tagged
+func (m *IndexManifests) WithBeforeTag(check func(index v1.Hash, children []v1.Hash) error) {
+	m.beforeTag = check
+}
+
+// manifest is what putManifest needs of a taggable
+type manifest interface {
+	RawManifest() ([]byte, error)
+	MediaType() (types.MediaType, error)
+}
+
+// putManifest puts t's manifest, whose blobs or children are pushed, to ref
+func (m *IndexManifests) putManifest(ref name.Reference, t manifest, tagRegistry *registry.RegistryConfig) error {
+	raw, err := t.RawManifest()
+	if err != nil {
+		return err
+	}
+	mediaType, err := t.MediaType()
+	if err != nil {
+		return err
+	}
+	return ocipush.PutManifest(tagRegistry.Context(), ref, raw, string(mediaType), tagRegistry.UploadOptions(m.upload))
 }
 
 type ToAppend struct {
@@ -55,6 +102,25 @@ func newToAppend(baseRef name.Digest, manifestMeta v1.Descriptor) ToAppend {
 type EachAppend func(baseRef name.Digest, tagRef name.Reference, tagRegistry *registry.RegistryConfig, platform v1.Platform) (mutate.IndexAddendum, error)
 
 func NewFromMultiArchBase(config schema.ContainConfig, baseRegistry *registry.RegistryConfig) (*IndexManifests, error) {
+	childTags, err := newChildTags(config.ChildTag)
+	if err != nil {
+		return nil, err
+	}
+	var index *IndexManifests
+	if len(config.BasePerPlatform) > 0 {
+		index, err = newFromBasePerPlatform(config, baseRegistry)
+	} else {
+		index, err = newFromBase(config, baseRegistry)
+	}
+	if err != nil {
+		return nil, err
+	}
+	index.childTags = childTags
+	return index, nil
+}
+
+func newFromBase(config schema.ContainConfig, baseRegistry *registry.RegistryConfig) (*IndexManifests, error) {
+	log := baseRegistry.Logger()
 	matchPlatforms, err := MatchPlatformsForAppend(config)
 	if err != nil {
 		return nil, err
@@ -70,16 +136,24 @@ func NewFromMultiArchBase(config schema.ContainConfig, baseRegistry *registry.Re
 		return nil, fmt.Errorf("base without digest is currently de-supported, got %s", config.Base)
 	}
 
-	zap.L().Info("fetching", zap.Any("base", baseRef))
+	log.Info("fetching", zap.Any("base", baseRef))
 	base, err := remote.Get(baseRef, baseRegistry.CraneOptions.Remote...)
 	if err != nil {
 		return nil, err
 	}
 
-	// We parse index for single-platform builds as well, to make platform handling explicit.
-	// To add support for non-index bases we'll probably use the default go-containerregistry behavior instead.
-	if base.MediaType != types.OCIImageIndex {
-		return nil, fmt.Errorf("currently only supports OCI index, got %s for %s", base.MediaType, config.Base)
+	convertToOCI, err := mediaTypesConvert(config.MediaTypes, base.MediaType)
+	if err != nil {
+		return nil, fmt.Errorf("base %s: %w", config.Base, err)
+	}
+
+	// A single-manifest base is handled as an index of one, with the
+	// platform from its config, so that platform handling stays explicit.
+	if base.MediaType == types.OCIManifestSchema1 || base.MediaType == types.DockerManifestSchema2 {
+		return newFromSingleManifestBase(config, baseRef, base, matchPlatforms, convertToOCI, log)
+	}
+	if base.MediaType != types.OCIImageIndex && base.MediaType != types.DockerManifestList {
+		return nil, fmt.Errorf("currently only supports OCI or Docker index and image manifests, got %s for %s", base.MediaType, config.Base)
 	}
 
 	baseIndex, err := base.ImageIndex()
@@ -93,62 +167,27 @@ func NewFromMultiArchBase(config schema.ContainConfig, baseRegistry *registry.Re
 	}
 
 	index := &IndexManifests{
-		baseRef:  baseRef,
-		toAppend: make([]ToAppend, 0),
+		baseRef:       baseRef,
+		toAppend:      make([]ToAppend, 0),
+		basePlatforms: make([]string, 0),
+		convertToOCI:  convertToOCI,
+		log:           log,
 	}
 
-	basePlatforms := make([]string, 0)
 	requireMediaType := types.OCIManifestSchema1
-	for i, d := range baseIndexManifest.Manifests {
-		zap.L().Debug("child descriptor",
-			zap.Int("item", i),
-			zap.String("mediaType", string(d.MediaType)),
-			zap.String("platform", platform.String(d.Platform)),
-		)
-		if d.Platform == nil {
-			zap.L().Info("skipping manifest without platform",
-				zap.String("mediaType", string(d.MediaType)),
-				zap.String("digest", d.Digest.String()),
-			)
-			continue
-		} else {
-			basePlatforms = append(basePlatforms, d.Platform.String())
-		}
-		if !matchPlatforms(d) {
-			zap.L().Info("skipping manifest excluded by platforms config",
-				zap.String("platform", d.Platform.String()),
-				zap.Strings("config", config.Platforms),
-			)
-			continue
-		}
-		if d.MediaType != requireMediaType {
-			zap.L().Warn("skipping unsupported media type",
-				zap.String("got", string(d.MediaType)),
-				zap.String("supported", string(requireMediaType)),
-			)
-			continue
-		}
-		if d.Annotations != nil {
-			if d.Platform.String() == pushed.AttestationPlatform && d.Annotations[pushed.ReferenceTypeAnnotation] == pushed.ReferenceTypeAttestation {
-				zap.L().Info("skipping attestation manifest",
-					zap.String("reference", d.Annotations[pushed.ReferenceDigestAnnotation]),
-				)
-				continue
-			}
-		}
-		base := newToAppend(index.baseRef, d)
-		// we probably don't need prototype or pending (child manifests) given the deprecations below
-		if index.prototype == nil {
-			index.prototype = &base
-		}
-		base.baseManifest, err = index.getChildManifest(index.baseRef, d, baseRegistry)
-		if err != nil {
-			zap.L().Error("index descriptor to manifest", zap.Error(err))
-			return nil, err
-		}
-		index.toAppend = append(index.toAppend, base)
+	if base.MediaType == types.DockerManifestList {
+		requireMediaType = types.DockerManifestSchema2
+	}
+	d := discovery{
+		config:           config,
+		matchPlatforms:   matchPlatforms,
+		registry:         baseRegistry,
+		indexMediaType:   base.MediaType,
+		requireMediaType: requireMediaType,
+	}
+	if err := index.discover(d, baseIndexManifest.Manifests, "", nil); err != nil {
+		return nil, err
 	}
-	index.basePlatforms = basePlatforms
 
 	// prototype and toAppend are populated together, so this covers both
 	// "nothing in the index is usable" and "the platforms config excluded
@@ -159,36 +198,371 @@ func NewFromMultiArchBase(config schema.ContainConfig, baseRegistry *registry.Re
 		if err != nil {
 			return nil, fmt.Errorf("raw manifest for debugging %v", err)
 		}
-		zap.L().Error("manifest search",
+		log.Error("manifest search",
 			zap.Any("fetched", baseRef),
 			zap.Strings("wanted", config.Platforms),
 			zap.ByteString("raw", raw),
 		)
 		return nil, fmt.Errorf("no manifest of type %s in index %s matched: wanted %v, index has %v",
-			requireMediaType, baseRef, config.Platforms, basePlatforms)
+			requireMediaType, baseRef, config.Platforms, index.basePlatforms)
 	}
 
 	// found no clone method on v1.ImageIndex so let's reuse the fetched one
 	// (because empty.Index caused err at Push due to Image(Hash), i.e. manifest lookup, not implemented)
 	// If reusing the original index turns out to be a bad idea we could start from empty.Index
 	index.indexStart = mutate.RemoveManifests(baseIndex, func(desc v1.Descriptor) bool {
-		zap.L().Debug("index entry clear",
+		log.Debug("index entry clear",
 			zap.String("platform", platform.String(desc.Platform)),
 			zap.String("digest", desc.Digest.String()),
 		)
 		// or do we want to keep attestation manifests?
 		return true
 	})
+	if convertToOCI {
+		index.indexStart = mutate.IndexMediaType(index.indexStart, types.OCIImageIndex)
+	}
+
+	return index, nil
+}
+
+// discovery is what discover needs at every level of nesting
+type discovery struct {
+	config           schema.ContainConfig
+	matchPlatforms   match.Matcher
+	registry         *registry.RegistryConfig
+	indexMediaType   types.MediaType
+	requireMediaType types.MediaType
+}
+
+// discover adds the manifests we'll append to, flattening nested indexes.
+// A child of a nested index inherits the platform of the nested index's
+// descriptor if it has none of its own, and that descriptor's annotations
+// unless it has the same keys. nesting is the path to manifests, for
+// diagnostics, empty at the top level.
+func (m *IndexManifests) discover(d discovery, manifests []v1.Descriptor, nesting string, parent *v1.Descriptor) error {
+	for i, desc := range manifests {
+		desc = inheritDescriptor(desc, parent)
+		path := fmt.Sprintf("%smanifests[%d]", nesting, i)
+		m.log.Debug("child descriptor",
+			zap.Int("item", i),
+			zap.String("nesting", nesting),
+			zap.String("mediaType", string(desc.MediaType)),
+			zap.String("platform", platform.String(desc.Platform)),
+		)
+		if desc.MediaType.IsIndex() {
+			if desc.MediaType != d.indexMediaType {
+				m.log.Warn("skipping nested index of other media type",
+					zap.String("path", path),
+					zap.String("got", string(desc.MediaType)),
+					zap.String("supported", string(d.indexMediaType)),
+				)
+				continue
+			}
+			nested, err := m.getNestedIndex(desc, d.registry)
+			if err != nil {
+				return fmt.Errorf("nested index %s at %s: %w", desc.Digest, path, err)
+			}
+			m.log.Info("flattening nested index",
+				zap.String("path", path),
+				zap.String("digest", desc.Digest.String()),
+				zap.Int("manifests", len(nested.Manifests)),
+			)
+			if err := m.discover(d, nested.Manifests, path+".", &desc); err != nil {
+				return err
+			}
+			continue
+		}
+		if desc.Platform == nil {
+			m.log.Info("skipping manifest without platform",
+				zap.String("mediaType", string(desc.MediaType)),
+				zap.String("digest", desc.Digest.String()),
+			)
+			continue
+		}
+		if nesting == "" {
+			m.basePlatforms = append(m.basePlatforms, desc.Platform.String())
+		} else {
+			m.basePlatforms = append(m.basePlatforms, fmt.Sprintf("%s (%s)", desc.Platform.String(), path))
+		}
+		if !d.matchPlatforms(desc) {
+			m.log.Info("skipping manifest excluded by platforms config",
+				zap.String("platform", desc.Platform.String()),
+				zap.Strings("config", d.config.Platforms),
+			)
+			continue
+		}
+		if desc.MediaType != d.requireMediaType {
+			m.log.Warn("skipping unsupported media type",
+				zap.String("got", string(desc.MediaType)),
+				zap.String("supported", string(d.requireMediaType)),
+			)
+			continue
+		}
+		if desc.Annotations != nil {
+			if desc.Platform.String() == pushed.AttestationPlatform && desc.Annotations[pushed.ReferenceTypeAnnotation] == pushed.ReferenceTypeAttestation {
+				m.log.Info("skipping attestation manifest",
+					zap.String("reference", desc.Annotations[pushed.ReferenceDigestAnnotation]),
+				)
+				continue
+			}
+		}
+		base := newToAppend(m.baseRef, desc)
+		// we probably don't need prototype or pending (child manifests) given the deprecations below
+		if m.prototype == nil {
+			m.prototype = &base
+		}
+		var err error
+		base.baseManifest, err = m.getChildManifest(m.baseRef, desc, d.registry)
+		if err != nil {
+			m.log.Error("index descriptor to manifest", zap.Error(err))
+			return err
+		}
+		m.toAppend = append(m.toAppend, base)
+	}
+	return nil
+}
+
+// inheritDescriptor returns desc with platform and annotations from the
+// nested index descriptor parent, where desc lacks them.
+func inheritDescriptor(desc v1.Descriptor, parent *v1.Descriptor) v1.Descriptor {
+	if parent == nil {
+		return desc
+	}
+	if desc.Platform == nil && parent.Platform != nil {
+		p := *parent.Platform
+		desc.Platform = &p
+	}
+	if len(parent.Annotations) > 0 {
+		annotations := make(map[string]string, len(parent.Annotations)+len(desc.Annotations))
+		for k, v := range parent.Annotations {
+			annotations[k] = v
+		}
+		for k, v := range desc.Annotations {
+			annotations[k] = v
+		}
+		desc.Annotations = annotations
+	}
+	return desc
+}
+
+// getNestedIndex fetches an index that is a child of the base index.
+func (m *IndexManifests) getNestedIndex(desc v1.Descriptor, config *registry.RegistryConfig) (*v1.IndexManifest, error) {
+	nested, err := remote.Get(m.baseRef.Digest(desc.Digest.String()), config.CraneOptions.Remote...)
+	if err != nil {
+		return nil, err
+	}
+	if !nested.MediaType.IsIndex() {
+		return nil, fmt.Errorf("descriptor says %s, got %s", desc.MediaType, nested.MediaType)
+	}
+	return v1.ParseIndexManifest(bytes.NewReader(nested.Manifest))
+}
+
+// newFromSingleManifestBase reads the platform of an image manifest base
+// from its config. The index we'd push, if any, starts out empty.
+func newFromSingleManifestBase(config schema.ContainConfig, baseRef name.Digest, base *remote.Descriptor, matchPlatforms match.Matcher, convertToOCI bool, log *zap.Logger) (*IndexManifests, error) {
+	img, err := base.Image()
+	if err != nil {
+		return nil, fmt.Errorf("image from %s %s: %w", base.MediaType, config.Base, err)
+	}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("config of base %s: %w", config.Base, err)
+	}
+	p := configFile.Platform()
+	if p == nil || p.OS == "" || p.Architecture == "" {
+		return nil, fmt.Errorf("base %s is a single manifest without os and architecture in its config", config.Base)
+	}
+	manifest, err := v1.ParseManifest(bytes.NewReader(base.Manifest))
+	if err != nil {
+		return nil, err
+	}
+	log.Info("single manifest base",
+		zap.String("mediaType", string(base.MediaType)),
+		zap.String("platform", p.String()),
+	)
 
+	indexMediaType := types.OCIImageIndex
+	if base.MediaType == types.DockerManifestSchema2 && !convertToOCI {
+		indexMediaType = types.DockerManifestList
+	}
+	index := &IndexManifests{
+		baseRef:       baseRef,
+		toAppend:      make([]ToAppend, 0, 1),
+		basePlatforms: []string{p.String()},
+		indexStart:    mutate.IndexMediaType(empty.Index, indexMediaType),
+		convertToOCI:  convertToOCI,
+		log:           log,
+	}
+	d := v1.Descriptor{
+		MediaType: base.MediaType,
+		Digest:    base.Digest,
+		Platform:  p,
+	}
+	if !matchPlatforms(d) {
+		return nil, fmt.Errorf("single manifest base %s is %s, not matched by platforms %v",
+			baseRef, p.String(), config.Platforms)
+	}
+	child := newToAppend(baseRef, d)
+	child.baseManifest = manifest
+	index.toAppend = append(index.toAppend, child)
+	index.prototype = &index.toAppend[0]
 	return index, nil
 }
 
+// newFromBasePerPlatform merges the children of several bases into one
+// index. Each basePerPlatform entry contributes the child for its platform,
+// and base, if set, the children of every other platform, in base order
+// followed by the entries in key order. The index starts from base, or is
+// empty if there is none, and every base must agree on its media type.
+func newFromBasePerPlatform(config schema.ContainConfig, baseRegistry *registry.RegistryConfig) (*IndexManifests, error) {
+	log := baseRegistry.Logger()
+	requested, err := ParseConfigPlatforms(config.Platforms.Requested())
+	if err != nil {
+		return nil, err
+	}
+	var keyed []v1.Platform
+	var parts []*IndexManifests
+	for _, k := range schema.BasePerPlatformKeys(config) {
+		p, err := v1.ParsePlatform(k)
+		if err != nil {
+			return nil, fmt.Errorf("basePerPlatform key %q: %w", k, err)
+		}
+		if len(requested) > 0 && !containsPlatform(requested, *p) {
+			log.Info("skipping basePerPlatform excluded by platforms config",
+				zap.String("platform", k),
+				zap.Strings("config", config.Platforms),
+			)
+			continue
+		}
+		if containsPlatform(keyed, *p) {
+			return nil, fmt.Errorf("basePerPlatform has more than one key for %s", p.String())
+		}
+		keyed = append(keyed, *p)
+		single := config
+		single.Base = config.BasePerPlatform[k]
+		single.BasePerPlatform = nil
+		single.Platforms = schema.Platforms{k}
+		part, err := newFromBase(single, baseRegistry)
+		if err != nil {
+			return nil, fmt.Errorf("basePerPlatform %s: %w", k, err)
+		}
+		parts = append(parts, part)
+	}
+
+	var base *IndexManifests
+	if config.Base != "" {
+		rest := config
+		rest.BasePerPlatform = nil
+		if len(requested) > 0 {
+			rest.Platforms = nil
+			for i, r := range requested {
+				if !containsPlatform(keyed, r) {
+					rest.Platforms = append(rest.Platforms, config.Platforms[i])
+				}
+			}
+		}
+		if len(requested) == 0 || len(rest.Platforms) > 0 {
+			base, err = newFromBase(rest, baseRegistry)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if base == nil && len(parts) == 0 {
+		return nil, fmt.Errorf("no base for platforms %v, basePerPlatform has %v", config.Platforms, schema.BasePerPlatformKeys(config))
+	}
+
+	merged := &IndexManifests{
+		toAppend:      make([]ToAppend, 0),
+		basePlatforms: make([]string, 0),
+		log:           log,
+	}
+	if base != nil {
+		merged.baseRef = base.baseRef
+		merged.indexStart = base.indexStart
+		merged.convertToOCI = base.convertToOCI
+		merged.basePlatforms = append(merged.basePlatforms, base.basePlatforms...)
+		for _, c := range base.toAppend {
+			if containsPlatform(keyed, *c.meta.Platform) {
+				log.Info("base child replaced by basePerPlatform", zap.String("platform", c.meta.Platform.String()))
+				continue
+			}
+			merged.toAppend = append(merged.toAppend, c)
+		}
+	} else {
+		mediaType, err := parts[0].indexStart.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		merged.baseRef = parts[0].baseRef
+		merged.indexStart = mutate.IndexMediaType(empty.Index, mediaType)
+	}
+	want, err := merged.indexStart.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		got, err := part.indexStart.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if got != want {
+			return nil, fmt.Errorf("base %s would be in a %s, not %s like the other bases, set mediaTypes: %s to convert",
+				part.baseRef, got, want, schema.MediaTypesOCI)
+		}
+		merged.convertToOCI = merged.convertToOCI || part.convertToOCI
+		for _, p := range part.basePlatforms {
+			merged.basePlatforms = append(merged.basePlatforms, fmt.Sprintf("%s (basePerPlatform)", p))
+		}
+		merged.toAppend = append(merged.toAppend, part.toAppend...)
+	}
+	if len(merged.toAppend) > 0 {
+		merged.prototype = &merged.toAppend[0]
+	}
+	return merged, nil
+}
+
+func containsPlatform(platforms []v1.Platform, p v1.Platform) bool {
+	for _, c := range platforms {
+		if platform.Equal(c, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaTypesConvert validates the mediaTypes config against the base's
+// media type and returns true if the result should be converted to OCI.
+// A Docker base requires an explicit choice, because either one changes
+// what the result's digests are compared to an equivalent OCI build.
+func mediaTypesConvert(mediaTypes string, base types.MediaType) (bool, error) {
+	switch mediaTypes {
+	case "", schema.MediaTypesPreserve, schema.MediaTypesOCI:
+	default:
+		return false, fmt.Errorf("mediaTypes %q is not %s or %s", mediaTypes, schema.MediaTypesPreserve, schema.MediaTypesOCI)
+	}
+	if base != types.DockerManifestList && base != types.DockerManifestSchema2 {
+		return false, nil
+	}
+	if mediaTypes == "" {
+		return false, fmt.Errorf("%s requires mediaTypes: %s to keep Docker media types, or %s to convert the result",
+			base, schema.MediaTypesPreserve, schema.MediaTypesOCI)
+	}
+	return mediaTypes == schema.MediaTypesOCI, nil
+}
+
+// ConvertToOCI is true if appenders should convert Docker base manifests to
+// OCI, see schema.MediaTypesOCI.
+func (m *IndexManifests) ConvertToOCI() bool {
+	return m.convertToOCI
+}
+
 func (m *IndexManifests) getChildManifest(baseRef name.Digest, manifest v1.Descriptor, config *registry.RegistryConfig) (*v1.Manifest, error) {
 	ref := baseRef.Digest(manifest.Digest.String())
 	// "current" here means the base's child manifest that we want to derive from
 	current, err := remote.Get(ref, config.CraneOptions.Remote...)
 	if err != nil {
-		zap.L().Error("get current",
+		m.log.Error("get current",
 			zap.String("base", baseRef.String()),
 			zap.String("child", ref.String()),
 			zap.String("childtype", string(manifest.MediaType)),
@@ -199,7 +573,7 @@ func (m *IndexManifests) getChildManifest(baseRef name.Digest, manifest v1.Descr
 	// We can't use remote.Image() because of "If the fetched artifact is an index, it will attempt to resolve the index to a child image with the appropriate platform."
 	// i.e. we must check media type using Head/Get first
 	if current.MediaType.IsIndex() {
-		zap.L().Error("get current",
+		m.log.Error("get current",
 			zap.String("base", baseRef.String()),
 			zap.String("child", ref.String()),
 			zap.String("childtype", string(manifest.MediaType)),
@@ -208,7 +582,7 @@ func (m *IndexManifests) getChildManifest(baseRef name.Digest, manifest v1.Descr
 	}
 	currentmanifest, err := v1.ParseManifest(bytes.NewReader(current.Manifest))
 	if err != nil {
-		zap.L().Error("parse current manifest",
+		m.log.Error("parse current manifest",
 			zap.String("base", baseRef.String()),
 			zap.String("child", ref.String()),
 			zap.String("childtype", string(manifest.MediaType)),
@@ -242,6 +616,52 @@ func (m *IndexManifests) MatchedPlatforms() []v1.Platform {
 	return out
 }
 
+// RetainPlatforms narrows the manifests we append to to those whose platform
+// is one of keep, preserving base index order. It is for selection that
+// needs the matched platforms first, such as platforms: auto; the platforms
+// config itself is applied during discovery.
+func (m *IndexManifests) RetainPlatforms(keep []v1.Platform) {
+	retained := make([]ToAppend, 0, len(m.toAppend))
+	for _, c := range m.toAppend {
+		for _, k := range keep {
+			if platform.Equal(*c.meta.Platform, k) {
+				retained = append(retained, c)
+				break
+			}
+		}
+	}
+	m.toAppend = retained
+	m.prototype = nil
+	if len(retained) > 0 {
+		m.prototype = &m.toAppend[0]
+	}
+}
+
+// BaseLayersSize returns the sum of the compressed layer sizes in the base
+// manifest we append to for platform, as declared by that manifest.
+func (m *IndexManifests) BaseLayersSize(p v1.Platform) int64 {
+	var size int64
+	for _, c := range m.toAppend {
+		if !platform.Equal(*c.meta.Platform, p) || c.baseManifest == nil {
+			continue
+		}
+		for _, l := range c.baseManifest.Layers {
+			size += l.Size
+		}
+		break
+	}
+	return size
+}
+
+// ChildTags returns the tag for each manifest we append to, in the same
+// order, or nil if the config has no childTag.
+func (m *IndexManifests) ChildTags(tagRef name.Reference) ([]name.Tag, error) {
+	if m.childTags == nil {
+		return nil, nil
+	}
+	return m.childTags.renderAll(tagRef, m.MatchedPlatforms())
+}
+
 // BasePlatforms returns every platform declared by the base index, including
 // the ones the platforms config excluded. Only for diagnostics.
 func (m *IndexManifests) BasePlatforms() []string {
@@ -262,42 +682,112 @@ func (m *IndexManifests) BuildWithAppend(append EachAppend, tagRef name.Referenc
 	var manifests = make([]mutate.IndexAddendum, len(m.toAppend))
 	for i, c := range m.toAppend {
 		if c.meta.Digest != noDigestYet {
-			zap.L().Fatal("has digest already", zap.Int("item", i), zap.Any("toAppend", c))
+			return nil, nil, fmt.Errorf("index item %d for %s has a digest already", i, platform.String(c.meta.Platform))
 		}
 		var err error
 		manifests[i], err = append(c.base, tagRef, tagRegistry, *c.meta.Platform)
 		if err != nil {
-			zap.L().Error("append", zap.Int("item", i), zap.Any("base", c), zap.Error(err))
+			m.log.Error("append", zap.Int("item", i), zap.Any("base", c), zap.Error(err))
 			return nil, nil, err
 		}
 	}
 	resultIndex := mutate.AppendManifests(m.indexStart, manifests...)
 	if resultIndex == nil {
-		zap.L().Fatal("nil result from AppendManifests")
+		return nil, nil, errors.New("nil result from AppendManifests")
 	}
 	for _, added := range manifests {
-		zap.L().Debug("index entry addded",
+		m.log.Debug("index entry addded",
 			zap.String("platform", platform.String(added.Platform)),
 			zap.String("digest", added.Digest.String()),
 		)
 	}
+	tags, err := m.ChildTags(tagRef)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := resultIndex.Digest()
+	if err != nil {
+		return nil, nil, err
+	}
+	if push && m.beforeTag != nil {
+		children := make([]v1.Hash, len(manifests))
+		for i, added := range manifests {
+			children[i] = added.Digest
+		}
+		if err := m.beforeTag(d, children); err != nil {
+			return nil, nil, err
+		}
+	}
+	existing := registry.Missing
 	if push {
+		existing, err = registry.ExistingPush(tagRef, d, tagRegistry)
+		if err != nil {
+			m.log.Warn("existing index check failed, pushing", zap.Error(err))
+			existing = registry.Missing
+		}
+	}
+	if push && existing != registry.Tagged {
 		resultTaggable, err := NewTaggableIndex(resultIndex)
 		if err != nil {
-			zap.L().Error("taggable", zap.Any("index", resultIndex), zap.Error(err))
+			m.log.Error("taggable", zap.Any("index", resultIndex), zap.Error(err))
 			return nil, nil, err
 		}
-		err = remote.Put(tagRef, resultTaggable, tagRegistry.CraneOptions.Remote...)
+		err = m.putManifest(tagRef, resultTaggable, tagRegistry)
 		if err != nil {
-			zap.L().Error("index put", zap.Any("ref", tagRef), zap.Error(err))
+			m.log.Error("index put", zap.Any("ref", tagRef), zap.Error(err))
 			return nil, nil, err
 		}
 	}
+	if existing != registry.Missing {
+		m.log.Info("index exists", zap.String("digest", d.String()), zap.Bool("tagAdded", existing == registry.Untagged))
+	}
 	// Build artifact from the result index
-	d, err := resultIndex.Digest()
+	artifact, err := pushed.NewIndexImage(tagRef.String(), d, resultIndex, m.baseRef.String())
 	if err != nil {
 		return nil, nil, err
 	}
-	artifact, err := pushed.NewIndexImage(tagRef.String(), d, resultIndex, m.baseRef.String())
-	return resultIndex, artifact, err
+	if push {
+		artifact.SetPushed(existing != registry.Missing)
+	}
+	if len(tags) > 0 {
+		artifact.Children = make([]pushed.Artifact, len(tags))
+	}
+	for i, tag := range tags {
+		child, err := m.tagChild(tag, m.toAppend[i], manifests[i], tagRegistry, push)
+		if err != nil {
+			return nil, nil, err
+		}
+		artifact.Children[i] = *child
+	}
+	return resultIndex, artifact, nil
+}
+
+// tagChild pushes the child tag, after the index so that the index is
+// complete once any of its children is tagged.
+func (m *IndexManifests) tagChild(tag name.Tag, c ToAppend, added mutate.IndexAddendum, tagRegistry *registry.RegistryConfig, push bool) (*pushed.Artifact, error) {
+	img, ok := added.Add.(v1.Image)
+	if !ok {
+		return nil, fmt.Errorf("child %s is not an image", platform.String(c.meta.Platform))
+	}
+	taggable, err := NewTaggableChildFromImage(img)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := taggable.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if push {
+		if err := m.putManifest(tag, taggable, tagRegistry); err != nil {
+			m.log.Error("child tag put", zap.String("tag", tag.String()), zap.Error(err))
+			return nil, err
+		}
+	}
+	m.log.Info("child tag",
+		zap.String("tag", tag.String()),
+		zap.String("platform", c.meta.Platform.String()),
+		zap.String("digest", digest.String()),
+		zap.Bool("pushed", push),
+	)
+	return pushed.NewSingleImage(tag.String(), digest, img, c.meta.Platform, c.base.String())
 }
@@ -1,11 +1,12 @@
 package multiarch
 
 import (
+	"fmt"
+
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/match"
 	"github.com/turbokube/contain/pkg/platform"
 	schema "github.com/turbokube/contain/pkg/schema/v1"
-	"go.uber.org/zap"
 )
 
 // match has utils for matching index member descriptors based on config
@@ -19,8 +20,7 @@ func ParseConfigPlatforms(configPlatforms []string) ([]v1.Platform, error) {
 	for i, c := range configPlatforms {
 		p, err := v1.ParsePlatform(c)
 		if err != nil {
-			zap.L().Error("platform", zap.Int("i", i), zap.String("config", c), zap.Error(err))
-			return nil, err
+			return nil, fmt.Errorf("platforms[%d] %s: %w", i, c, err)
 		}
 		platforms[i] = *p
 	}
@@ -68,8 +68,12 @@ func UnmatchedPlatforms(configPlatforms []string, matched []v1.Platform) ([]stri
 // unset-means-any rule that ko and crane use, under which a linux/arm request
 // would select every arm variant in the base. One requested platform selects
 // at most one base child.
+//
+// platforms: auto matches like an empty list; narrowing to the platforms
+// that have layer sources happens after discovery, see
+// IndexManifests.RetainPlatforms.
 func MatchPlatformsForAppend(config schema.ContainConfig) (match.Matcher, error) {
-	if len(config.Platforms) == 0 {
+	if len(config.Platforms.Requested()) == 0 {
 		return func(desc v1.Descriptor) bool {
 			return true
 		}, nil
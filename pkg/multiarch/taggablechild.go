@@ -3,12 +3,12 @@ package multiarch
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
-	"go.uber.org/zap"
 )
 
 // TaggableChild allows remote.Put of an index's child manifest
@@ -27,13 +27,11 @@ var _ partial.Describable = (*TaggableChild)(nil)
 func NewTaggableChild(manifest v1.Manifest) (TaggableChild, error) {
 	rawManifest, err := json.MarshalIndent(manifest, "", "   ")
 	if err != nil {
-		zap.L().Error("raw manifest", zap.Error(err))
-		return TaggableChild{}, err
+		return TaggableChild{}, fmt.Errorf("raw manifest: %w", err)
 	}
 	digest, size, err := v1.SHA256(bytes.NewReader(rawManifest))
 	if err != nil {
-		zap.L().Error("raw manifest digest", zap.Error(err))
-		return TaggableChild{}, err
+		return TaggableChild{}, fmt.Errorf("raw manifest digest: %w", err)
 	}
 	return TaggableChild{
 		manifest:  rawManifest,
@@ -58,3 +56,26 @@ func (t TaggableChild) Digest() (v1.Hash, error) {
 func (t TaggableChild) Size() (int64, error) {
 	return t.size, nil
 }
+
+// NewTaggableChildFromImage keeps the image's raw manifest, so that the
+// child tag points to the digest the index references
+func NewTaggableChildFromImage(image v1.Image) (TaggableChild, error) {
+	rawManifest, err := image.RawManifest()
+	if err != nil {
+		return TaggableChild{}, fmt.Errorf("raw manifest: %w", err)
+	}
+	mediaType, err := image.MediaType()
+	if err != nil {
+		return TaggableChild{}, fmt.Errorf("media type: %w", err)
+	}
+	digest, size, err := v1.SHA256(bytes.NewReader(rawManifest))
+	if err != nil {
+		return TaggableChild{}, fmt.Errorf("raw manifest digest: %w", err)
+	}
+	return TaggableChild{
+		manifest:  rawManifest,
+		mediaType: mediaType,
+		digest:    digest,
+		size:      size,
+	}, nil
+}
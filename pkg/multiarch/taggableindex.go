@@ -2,12 +2,12 @@ package multiarch
 
 import (
 	"encoding/json"
+	"fmt"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
-	"go.uber.org/zap"
 )
 
 // TaggableIndex wraps v1.ImageIndex so that go-containerregistry remote.Put doesn't treat it as an image
@@ -29,28 +29,23 @@ func NewTaggableIndex(index v1.ImageIndex) (TaggableIndex, error) {
 	// index.RawManifest panic at v0.19.0/pkg/v1/partial/with.go:322 after mutate.AppendManifests
 	manifest, err := index.IndexManifest()
 	if err != nil {
-		zap.L().Error("manifest", zap.Error(err))
-		return TaggableIndex{}, err
+		return TaggableIndex{}, fmt.Errorf("index manifest: %w", err)
 	}
 	manifestjson, err := json.Marshal(manifest)
 	if err != nil {
-		zap.L().Error("marshal", zap.Any("manifest", manifest), zap.Error(err))
-		return TaggableIndex{}, err
+		return TaggableIndex{}, fmt.Errorf("marshal index manifest: %w", err)
 	}
 	digest, err := index.Digest()
 	if err != nil {
-		zap.L().Error("digest", zap.Error(err))
-		return TaggableIndex{}, err
+		return TaggableIndex{}, fmt.Errorf("index digest: %w", err)
 	}
 	mediaType, err := index.MediaType()
 	if err != nil {
-		zap.L().Error("mediatype", zap.Error(err))
-		return TaggableIndex{}, err
+		return TaggableIndex{}, fmt.Errorf("index media type: %w", err)
 	}
 	size, err := index.Size()
 	if err != nil {
-		zap.L().Error("size", zap.Error(err))
-		return TaggableIndex{}, err
+		return TaggableIndex{}, fmt.Errorf("index size: %w", err)
 	}
 	return TaggableIndex{
 		manifest:  manifestjson,
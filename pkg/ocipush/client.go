@@ -3,6 +3,7 @@ package ocipush
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,10 +13,13 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/turbokube/contain/pkg/events"
+	"github.com/turbokube/contain/pkg/immutable"
 	"go.uber.org/zap"
 )
 
@@ -45,6 +49,12 @@ type regClient struct {
 	// ext is the optional direct-to-storage extension, nil when this client
 	// speaks standard OCI only. See directpush.go.
 	ext *directPush
+
+	// immutable are the tags putManifest refuses to move, nil if forced
+	immutable immutable.Tags
+
+	events events.Func
+	log    *zap.Logger
 }
 
 func newRegClient(reg name.Registry, opts Options) (*regClient, error) {
@@ -61,21 +71,35 @@ func newRegClient(reg name.Registry, opts Options) (*regClient, error) {
 		}
 	}
 
+	log := opts.Logger
+	if log == nil {
+		log = zap.L()
+	}
 	threshold := opts.ExtThreshold
 	if threshold == 0 {
 		threshold = DefaultExtThreshold
 	}
+	if err := opts.ImmutableTags.Validate(); err != nil {
+		return nil, err
+	}
+	immutableTags := opts.ImmutableTags
+	if opts.Force {
+		immutableTags = nil
+	}
 	inner := opts.Transport
 	if inner == nil {
 		inner = http.DefaultTransport
 	}
 	return &regClient{
-		base:    fmt.Sprintf("%s://%s", reg.Scheme(), reg.RegistryStr()),
-		reg:     reg,
-		auth:    auth,
-		raw:     &http.Client{Transport: inner},
-		clients: map[string]*http.Client{},
-		ext:     &directPush{threshold: threshold, partSize: opts.PartSize},
+		base:      fmt.Sprintf("%s://%s", reg.Scheme(), reg.RegistryStr()),
+		reg:       reg,
+		auth:      auth,
+		raw:       &http.Client{Transport: inner},
+		clients:   map[string]*http.Client{},
+		ext:       &directPush{threshold: threshold, partSize: opts.PartSize},
+		immutable: immutableTags,
+		events:    opts.Events,
+		log:       log,
 	}, nil
 }
 
@@ -83,14 +107,20 @@ func newRegClient(reg name.Registry, opts Options) (*regClient, error) {
 // performing the auth handshake on first use of each scope and caching the
 // result. action is transport.PullScope or transport.PushScope.
 func (c *regClient) client(ctx context.Context, repo string, action string) (*http.Client, error) {
-	scope := c.reg.Repo(repo).Scope(action)
+	return c.clientFor(ctx, c.reg.Repo(repo).Scope(action))
+}
+
+// clientFor is client for a token that covers several scopes, such as a
+// cross-repository mount's push to one repository and pull from another.
+func (c *regClient) clientFor(ctx context.Context, scopes ...string) (*http.Client, error) {
+	scope := strings.Join(scopes, " ")
 	c.mu.Lock()
 	cached, ok := c.clients[scope]
 	c.mu.Unlock()
 	if ok {
 		return cached, nil
 	}
-	tr, err := transport.NewWithContext(ctx, c.reg, c.auth, c.raw.Transport, []string{scope})
+	tr, err := transport.NewWithContext(ctx, c.reg, c.auth, c.raw.Transport, scopes)
 	if err != nil {
 		return nil, fmt.Errorf("registry auth for %s: %w", scope, err)
 	}
@@ -192,6 +222,70 @@ func (c *regClient) blobExists(ctx context.Context, repo string, digest string,
 	}
 }
 
+// mountBlob asks the registry to link a blob from another of its
+// repositories, which saves the upload. False, without an error, means the
+// registry declined, for example because the credentials can't read from,
+// and the blob must be uploaded.
+func (c *regClient) mountBlob(ctx context.Context, repo string, from string, digest string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v2/%s/blobs/uploads/?mount=%s&from=%s", c.base, repo, url.QueryEscape(digest), url.QueryEscape(from)), nil)
+	if err != nil {
+		return false, err
+	}
+	cl, err := c.clientFor(ctx, c.reg.Repo(repo).Scope(transport.PushScope), c.reg.Repo(from).Scope(transport.PullScope))
+	if err != nil {
+		return false, err
+	}
+	res, err := cl.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("blob mount %s: %w", digest, err)
+	}
+	defer res.Body.Close()
+	// 202 is an upload session instead of the mount, left to expire
+	return res.StatusCode == http.StatusCreated, nil
+}
+
+// manifestDigest is the digest that ref points to, empty if it doesn't exist.
+// Registries answer HEAD with Docker-Content-Digest; without it the manifest
+// is fetched and hashed.
+func (c *regClient) manifestDigest(ctx context.Context, repo string, ref string) (string, error) {
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method,
+			fmt.Sprintf("%s/v2/%s/manifests/%s", c.base, repo, ref), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Accept", manifestAccept)
+		res, err := c.do(repo, transport.PushScope, req)
+		if err != nil {
+			return "", fmt.Errorf("manifest %s %s: %w", strings.ToLower(method), ref, err)
+		}
+		switch res.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			res.Body.Close()
+			return "", nil
+		default:
+			return "", statusError(fmt.Sprintf("manifest %s %s", strings.ToLower(method), ref), res)
+		}
+		if d := res.Header.Get("Docker-Content-Digest"); digestRe.MatchString(d) {
+			res.Body.Close()
+			return d, nil
+		}
+		if method == http.MethodGet {
+			h := sha256.New()
+			_, err := io.Copy(h, res.Body)
+			res.Body.Close()
+			if err != nil {
+				return "", fmt.Errorf("manifest get %s: %w", ref, err)
+			}
+			return digestOfHash(h), nil
+		}
+		res.Body.Close()
+	}
+	return "", nil
+}
+
 // pushBlobFile uploads the blob in the file at path unless it already exists
 // upstream, preferring the direct-to-storage extension for large blobs.
 func (c *regClient) pushBlobFile(ctx context.Context, repo string, d descriptor, path string) error {
@@ -200,17 +294,50 @@ func (c *regClient) pushBlobFile(ctx context.Context, repo string, d descriptor,
 		return err
 	}
 	if exists {
-		zap.L().Debug("blob exists", zap.String("digest", d.Digest), zap.Int64("size", d.Size))
+		c.log.Debug("blob exists", zap.String("digest", d.Digest), zap.Int64("size", d.Size))
+		c.skipped(repo, d, events.ReasonExists)
 		return nil
 	}
+	return c.uploadBlobFile(ctx, repo, d, path)
+}
+
+// uploadBlobFile is pushBlobFile for a blob known to be missing upstream
+func (c *regClient) uploadBlobFile(ctx context.Context, repo string, d descriptor, path string) error {
 	handled, err := c.tryDirectPush(ctx, repo, d, path)
+	if err == nil && !handled {
+		err = c.pushBlobStandard(ctx, repo, d, path)
+	}
 	if err != nil {
 		return err
 	}
-	if handled {
-		return nil
-	}
-	return c.pushBlobStandard(ctx, repo, d, path)
+	c.events.Emit(events.Event{Type: events.BlobUploaded, Repository: repo, Digest: d.Digest, Size: d.Size})
+	return nil
+}
+
+// skipped reports a blob that needed no upload
+func (c *regClient) skipped(repo string, d descriptor, reason string) {
+	c.events.Emit(events.Event{Type: events.BlobSkip, Repository: repo, Digest: d.Digest, Size: d.Size, Reason: reason})
+}
+
+// progressInterval is the least time between blob.progress events for a blob
+const progressInterval = time.Second
+
+// progressReader emits blob.progress while an upload reads it
+type progressReader struct {
+	io.Reader
+	emit     events.Func
+	event    events.Event
+	reported time.Time
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.event.Complete += int64(n)
+	if now := time.Now(); now.Sub(r.reported) >= progressInterval {
+		r.reported = now
+		r.emit.Emit(r.event)
+	}
+	return n, err
 }
 
 // standardUploadRetries is how many times a monolithic blob upload is
@@ -231,7 +358,7 @@ func (c *regClient) pushBlobStandard(ctx context.Context, repo string, d descrip
 	var err error
 	for attempt := 0; attempt <= standardUploadRetries; attempt++ {
 		if attempt > 0 {
-			zap.L().Info("retrying blob upload",
+			c.log.Info("retrying blob upload",
 				zap.String("digest", d.Digest), zap.Int64("size", d.Size),
 				zap.Int("attempt", attempt+1), zap.Error(err))
 		}
@@ -271,7 +398,12 @@ func (c *regClient) putBlobOnce(ctx context.Context, repo string, d descriptor,
 		return err
 	}
 	defer f.Close()
-	put, err := http.NewRequestWithContext(ctx, http.MethodPut, target, f)
+	var body io.Reader = f
+	if c.events != nil {
+		body = &progressReader{Reader: f, emit: c.events, reported: time.Now(),
+			event: events.Event{Type: events.BlobProgress, Repository: repo, Digest: d.Digest, Size: d.Size}}
+	}
+	put, err := http.NewRequestWithContext(ctx, http.MethodPut, target, body)
 	if err != nil {
 		return err
 	}
@@ -289,7 +421,7 @@ func (c *regClient) putBlobOnce(ctx context.Context, repo string, d descriptor,
 		return statusError(fmt.Sprintf("blob put %s", d.Digest), putRes)
 	}
 	putRes.Body.Close()
-	zap.L().Info("blob pushed", zap.String("digest", d.Digest), zap.Int64("size", d.Size))
+	c.log.Info("blob pushed", zap.String("digest", d.Digest), zap.Int64("size", d.Size))
 	return nil
 }
 
@@ -326,6 +458,16 @@ func (c *regClient) postJSON(ctx context.Context, repo string, path string, body
 }
 
 func (c *regClient) putManifest(ctx context.Context, repo string, raw []byte, mediaType string, refOrDigest string) error {
+	if !digestRe.MatchString(refOrDigest) && c.immutable.Match(refOrDigest) {
+		existing, err := c.manifestDigest(ctx, repo, refOrDigest)
+		if err != nil {
+			return err
+		}
+		ref := fmt.Sprintf("%s/%s:%s", c.reg.RegistryStr(), repo, refOrDigest)
+		if err := c.immutable.Check(ref, refOrDigest, existing, digestOf(raw)); err != nil {
+			return err
+		}
+	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
 		fmt.Sprintf("%s/v2/%s/manifests/%s", c.base, repo, refOrDigest), bytes.NewReader(raw))
 	if err != nil {
@@ -340,6 +482,12 @@ func (c *regClient) putManifest(ctx context.Context, repo string, raw []byte, me
 		return statusError(fmt.Sprintf("manifest put %s", refOrDigest), res)
 	}
 	res.Body.Close()
-	zap.L().Info("manifest pushed", zap.String("ref", refOrDigest), zap.String("mediaType", mediaType))
+	c.log.Info("manifest pushed", zap.String("ref", refOrDigest), zap.String("mediaType", mediaType))
+	ref := c.reg.Repo(repo).String() + ":" + refOrDigest
+	if digestRe.MatchString(refOrDigest) {
+		ref = c.reg.Repo(repo).String() + "@" + refOrDigest
+	}
+	c.events.Emit(events.Event{Type: events.ManifestPut, Repository: repo, Ref: ref,
+		Digest: digestOf(raw), MediaType: mediaType, Size: int64(len(raw))})
 	return nil
 }
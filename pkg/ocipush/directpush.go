@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/turbokube/contain/pkg/events"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 )
@@ -82,7 +83,7 @@ func (c *regClient) tryDirectPush(ctx context.Context, repo string, d descriptor
 		return false, err
 	}
 	c.ext.usable.Store(false)
-	zap.L().Warn("registry advertised _directpush but does not honor it, using standard uploads")
+	c.log.Warn("registry advertised _directpush but does not honor it, using standard uploads")
 	return false, nil
 }
 
@@ -112,7 +113,7 @@ func (c *regClient) directpushSupported(ctx context.Context, repo string) bool {
 		}
 		res, err := c.do(repo, transport.PushScope, req)
 		if err != nil {
-			zap.L().Debug("extension discovery failed", zap.Error(err))
+			c.log.Debug("extension discovery failed", zap.Error(err))
 			return
 		}
 		defer res.Body.Close()
@@ -126,7 +127,7 @@ func (c *regClient) directpushSupported(ctx context.Context, repo string) bool {
 		for _, ext := range doc.Extensions {
 			if ext.Name == "_directpush" && slices.Contains(ext.Endpoints, "_directpush/v1/uploads") {
 				c.ext.usable.Store(true)
-				zap.L().Debug("registry supports _directpush/v1")
+				c.log.Debug("registry supports _directpush/v1")
 				return
 			}
 		}
@@ -179,12 +180,15 @@ func (c *regClient) pushBlobExt(ctx context.Context, repo string, d descriptor,
 			return err
 		}
 		if session.Exists {
+			c.skipped(repo, d, events.ReasonExists)
 			return nil
 		}
+		c.events.Emit(events.Event{Type: events.DirectpushSession, Repository: repo,
+			Digest: d.Digest, Size: d.Size, Parts: len(session.Urls)})
 		parts, err := c.extUploadParts(ctx, session, d, f)
 		if err != nil {
 			if errors.Is(err, errExtExpired) && attempt < extSessionRetries {
-				zap.L().Info("direct upload session expired, requesting a fresh one",
+				c.log.Info("direct upload session expired, requesting a fresh one",
 					zap.String("digest", d.Digest),
 					zap.Int64("expiresSeconds", session.ExpiresSeconds))
 				continue
@@ -226,7 +230,7 @@ func (c *regClient) extStartSession(ctx context.Context, repo string, d descript
 		// capability will answer it for every session. Standard upload is a
 		// better answer than failing the push either way, but the reason
 		// would otherwise be lost, so say it once.
-		zap.L().Warn("registry declined the direct upload session, falling back to standard upload",
+		c.log.Warn("registry declined the direct upload session, falling back to standard upload",
 			zap.String("digest", d.Digest), zap.Int64("size", d.Size),
 			zap.String("response", errorBody(res)))
 		return nil, errExtUnsupported
@@ -289,8 +293,10 @@ func (c *regClient) extUploadParts(ctx context.Context, session *extStartRespons
 			// S3-compatible gateways return XML) costs a fresh connection per part
 			io.Copy(io.Discard, res.Body) //nolint:errcheck
 			res.Body.Close()
-			zap.L().Debug("part uploaded", zap.String("digest", d.Digest),
+			c.log.Debug("part uploaded", zap.String("digest", d.Digest),
 				zap.Int("part", i+1), zap.Int("of", len(session.Urls)))
+			c.events.Emit(events.Event{Type: events.DirectpushPart, Digest: d.Digest,
+				Size: length, Part: i + 1, Parts: len(session.Urls)})
 			return nil
 		})
 	}
@@ -314,7 +320,9 @@ func (c *regClient) extCommit(ctx context.Context, repo string, d descriptor, se
 		return fmt.Errorf("direct upload commit %s: status %d: %s", d.Digest, commitRes.StatusCode, errorBody(commitRes))
 	}
 	commitRes.Body.Close()
-	zap.L().Info("blob pushed direct", zap.String("digest", d.Digest),
+	c.log.Info("blob pushed direct", zap.String("digest", d.Digest),
 		zap.Int64("size", d.Size), zap.Int("parts", len(session.Urls)))
+	c.events.Emit(events.Event{Type: events.DirectpushCommit, Repository: repo,
+		Digest: d.Digest, Size: d.Size, Parts: len(session.Urls)})
 	return nil
 }
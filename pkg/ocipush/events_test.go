@@ -0,0 +1,88 @@
+package ocipush_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+
+	"github.com/turbokube/contain/pkg/events"
+	"github.com/turbokube/contain/pkg/ocipush"
+)
+
+// recorder collects events, which directpush parts emit concurrently.
+type recorder struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (r *recorder) emit(e events.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *recorder) count(t events.Type) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, e := range r.events {
+		if e.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+// TestPushEvents pushes through the extension twice and checks the events of
+// both: uploads with session, parts and commit, then skips.
+func TestPushEvents(t *testing.T) {
+	dir, img := layoutWithImage(t, 3500, 2)
+	fake := &extFake{reg: quietRegistry(), staged: map[string]map[int][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	fake.serverURL = server.URL
+	image := hostOf(server) + "/test/events:v1"
+
+	rec := &recorder{}
+	opts := ocipush.Options{Auth: authn.Anonymous, ExtThreshold: 2000, Events: rec.emit}
+	if err := ocipush.Push(context.Background(), dir, image, opts); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if n := rec.count(events.DirectpushSession); n != 2 {
+		t.Errorf("expected a session per layer, got %d", n)
+	}
+	if rec.count(events.DirectpushPart) < 2*4 || rec.count(events.DirectpushCommit) != 2 {
+		t.Errorf("expected parts and commits: %+v", rec.events)
+	}
+	if n := rec.count(events.BlobUploaded); n != 3 {
+		t.Errorf("expected 2 layers and the config uploaded, got %d", n)
+	}
+	if n := rec.count(events.ManifestPut); n != 1 {
+		t.Errorf("expected one manifest put, got %d", n)
+	}
+	first, last := rec.events[0], rec.events[len(rec.events)-1]
+	if first.Type != events.PhaseStart || last.Type != events.PhaseEnd || last.Phase != "push" || last.Error != "" {
+		t.Errorf("expected the push phase around everything, got %+v ... %+v", first, last)
+	}
+	d, _ := img.Digest()
+	for _, e := range rec.events {
+		if e.Type == events.ManifestPut && (e.Digest != d.String() || e.Ref != image) {
+			t.Errorf("manifest put %+v", e)
+		}
+	}
+
+	rec = &recorder{}
+	opts.Events = rec.emit
+	if err := ocipush.Push(context.Background(), dir, image, opts); err != nil {
+		t.Fatalf("push again: %v", err)
+	}
+	if n := rec.count(events.BlobSkip); n != 3 {
+		t.Errorf("expected every blob skipped, got %d", n)
+	}
+	if n := rec.count(events.BlobUploaded); n != 0 {
+		t.Errorf("expected no uploads, got %d", n)
+	}
+}
@@ -0,0 +1,206 @@
+package ocipush
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/turbokube/contain/pkg/events"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// layerUploadConcurrency is how many layers PushImage uploads at a time,
+// like remote.Write's default jobs.
+const layerUploadConcurrency = 4
+
+// PushImage pushes an image that is built in memory, as contain build does,
+// to ref, a tag or a digest in the repository. Its manifest and config are
+// put as the image's raw bytes. Blobs that ref's repository has are skipped,
+// blobs from another repository in the same registry are mounted, and the
+// rest are staged to disk, verified and uploaded like Push does, with the
+// direct-to-storage extension for large blobs. Up to layerUploadConcurrency
+// layers are staged and uploaded at a time, the config after them.
+//
+// progress, if not nil, gets an update per blob with the bytes done so far,
+// counting a layer that the image has twice at each use, and is closed when
+// PushImage returns, like remote.WithProgress.
+func PushImage(ctx context.Context, ref name.Reference, img v1.Image, opts Options, progress chan<- v1.Update) error {
+	if progress != nil {
+		defer close(progress)
+	}
+	c, err := newRegClient(ref.Context().Registry, opts)
+	if err != nil {
+		return err
+	}
+	repo := ref.Context().RepositoryStr()
+	logStagingDir(c.log, opts.StagingDir)
+
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	configRaw, err := img.RawConfigFile()
+	if err != nil {
+		return err
+	}
+	configDigest, err := img.ConfigName()
+	if err != nil {
+		return err
+	}
+	// layers that repeat a digest are uploaded once and reported per use
+	var unique []v1.Layer
+	uses := make(map[v1.Hash]int64)
+	total := int64(len(configRaw))
+	for _, l := range layers {
+		d, err := l.Digest()
+		if err != nil {
+			return err
+		}
+		size, err := l.Size()
+		if err != nil {
+			return err
+		}
+		if uses[d] == 0 {
+			unique = append(unique, l)
+		}
+		uses[d]++
+		total += size
+	}
+	var mu sync.Mutex
+	var complete int64
+	report := func(size int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		complete += size
+		if progress != nil {
+			progress <- v1.Update{Total: total, Complete: complete}
+		}
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(layerUploadConcurrency)
+	for _, l := range unique {
+		g.Go(func() error {
+			d, err := l.Digest()
+			if err != nil {
+				return err
+			}
+			size, err := l.Size()
+			if err != nil {
+				return err
+			}
+			if err := c.pushLayer(gctx, repo, l, descriptor{Digest: d.String(), Size: size}, opts.StagingDir); err != nil {
+				return err
+			}
+			report(size * uses[d])
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	config := descriptor{Digest: configDigest.String(), Size: int64(len(configRaw))}
+	if err := c.pushLayerBytes(ctx, repo, config, configRaw, opts.StagingDir); err != nil {
+		return err
+	}
+	report(config.Size)
+
+	raw, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return err
+	}
+	return c.putManifest(ctx, repo, raw, string(mediaType), ref.Identifier())
+}
+
+// PutManifest puts raw, a manifest whose blobs and children ref's repository
+// has, to ref, for example to tag an image or index that is pushed already.
+// Like every manifest put here it honors ImmutableTags and emits ManifestPut.
+func PutManifest(ctx context.Context, ref name.Reference, raw []byte, mediaType string, opts Options) error {
+	c, err := newRegClient(ref.Context().Registry, opts)
+	if err != nil {
+		return err
+	}
+	return c.putManifest(ctx, ref.Context().RepositoryStr(), raw, mediaType, ref.Identifier())
+}
+
+// pushLayer uploads l unless the repository has it or can mount it
+func (c *regClient) pushLayer(ctx context.Context, repo string, l v1.Layer, d descriptor, stagingDir string) error {
+	exists, err := c.blobExists(ctx, repo, d.Digest, transport.PushScope)
+	if err != nil {
+		return err
+	}
+	if exists {
+		c.log.Debug("blob exists", zap.String("digest", d.Digest), zap.Int64("size", d.Size))
+		c.skipped(repo, d, events.ReasonExists)
+		return nil
+	}
+	if ml, ok := l.(*remote.MountableLayer); ok {
+		from := ml.Reference.Context()
+		if from.RegistryStr() == c.reg.RegistryStr() && from.RepositoryStr() != repo {
+			mounted, err := c.mountBlob(ctx, repo, from.RepositoryStr(), d.Digest)
+			if err != nil {
+				return err
+			}
+			if mounted {
+				c.log.Debug("blob mounted", zap.String("digest", d.Digest), zap.String("from", from.String()))
+				c.skipped(repo, d, events.ReasonMounted)
+				return nil
+			}
+		}
+	}
+	rc, err := l.Compressed()
+	if err != nil {
+		return fmt.Errorf("layer %s: %w", d.Digest, err)
+	}
+	defer rc.Close()
+	return c.stageAndUpload(ctx, repo, d, rc, stagingDir)
+}
+
+// pushLayerBytes is pushLayer for a blob that is in memory, the config
+func (c *regClient) pushLayerBytes(ctx context.Context, repo string, d descriptor, b []byte, stagingDir string) error {
+	exists, err := c.blobExists(ctx, repo, d.Digest, transport.PushScope)
+	if err != nil {
+		return err
+	}
+	if exists {
+		c.skipped(repo, d, events.ReasonExists)
+		return nil
+	}
+	return c.stageAndUpload(ctx, repo, d, bytes.NewReader(b), stagingDir)
+}
+
+// stageAndUpload writes r to a staging file, verifying it against d, because
+// uploads read the blob by offset and retry from the start
+func (c *regClient) stageAndUpload(ctx context.Context, repo string, d descriptor, r io.Reader, stagingDir string) error {
+	file, err := stagingFile(stagingDir, "contain-build-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) //nolint:errcheck
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hasher), r)
+	closeErr := file.Close()
+	if err != nil {
+		return fmt.Errorf("stage blob %s: %w", d.Digest, err)
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if err := verifyBlob(d, size, hasher); err != nil {
+		return err
+	}
+	return c.uploadBlobFile(ctx, repo, d, file.Name())
+}
@@ -0,0 +1,175 @@
+package ocipush_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/turbokube/contain/pkg/ocipush"
+)
+
+// TestPushImage pushes an in-memory image the way contain build does: a
+// remote base's layers are mounted from the base repository, and an
+// appended layer above the threshold goes through the extension.
+func TestPushImage(t *testing.T) {
+	fake := &extFake{reg: quietRegistry(), staged: map[string]map[int][]byte{}}
+	// the in-memory registry's blobs are in every repository, so until
+	// built is pushed once its blobs are hidden and mounts answered here
+	var mounts atomic.Int32
+	var pushed atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		built := strings.HasPrefix(r.URL.Path, "/v2/test/built/blobs/")
+		if q := r.URL.Query(); q.Get("mount") != "" {
+			mounts.Add(1)
+			if q.Get("from") != "test/base" {
+				t.Errorf("mount from %s", q.Get("from"))
+			}
+			w.Header().Set("Location", "/v2/test/built/blobs/"+q.Get("mount"))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if built && r.Method == http.MethodHead && !pushed.Load() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	fake.serverURL = server.URL
+	host := hostOf(server)
+
+	baseRef, err := name.ParseReference(host + "/test/base:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseImg, err := random.Image(512, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(baseRef, baseImg, remote.WithAuth(authn.Anonymous)); err != nil {
+		t.Fatal(err)
+	}
+	base, err := remote.Image(baseRef, remote.WithAuth(authn.Anonymous))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appended, err := random.Layer(3500, "application/vnd.oci.image.layer.v1.tar+gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.AppendLayers(base, appended)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := name.ParseReference(host + "/test/built:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := make(chan v1.Update, 10)
+	uploadsBefore := fake.uploads
+	err = ocipush.PushImage(context.Background(), ref, img, ocipush.Options{
+		Auth:         authn.Anonymous,
+		ExtThreshold: 2000,
+	}, progress)
+	if err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	var last v1.Update
+	for u := range progress {
+		last = u
+	}
+	if last.Total == 0 || last.Complete != last.Total {
+		t.Errorf("progress should end complete, got %+v", last)
+	}
+	if n := mounts.Load(); n != 2 {
+		t.Errorf("expected the 2 base layers mounted, got %d mount requests", n)
+	}
+	if fake.uploads == uploadsBefore {
+		t.Errorf("the appended layer should use the extension")
+	}
+	assertPushedDigest(t, ref.String(), img)
+	assertLayersReadable(t, ref.String())
+
+	// pushed again, everything exists
+	pushed.Store(true)
+	mountsBefore := mounts.Load()
+	if err := ocipush.PushImage(context.Background(), ref, img, ocipush.Options{Auth: authn.Anonymous}, nil); err != nil {
+		t.Fatalf("push again: %v", err)
+	}
+	if mounts.Load() != mountsBefore {
+		t.Errorf("existing blobs should not be mounted again")
+	}
+}
+
+// TestPushImage_Concurrent uploads layers a few at a time, and a layer that
+// the image has twice once.
+func TestPushImage_Concurrent(t *testing.T) {
+	reg := quietRegistry()
+	var mu sync.Mutex
+	var inFlight, most, uploads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/blobs/uploads/") && r.Method == http.MethodPut {
+			mu.Lock()
+			inFlight++
+			uploads++
+			most = max(most, inFlight)
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			defer func() {
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			}()
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	img, err := random.Image(256, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = mutate.AppendLayers(img, layers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(hostOf(server) + "/test/concurrent:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := make(chan v1.Update, 20)
+	if err := ocipush.PushImage(context.Background(), ref, img, ocipush.Options{Auth: authn.Anonymous}, progress); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	var last v1.Update
+	for u := range progress {
+		last = u
+	}
+	if last.Total == 0 || last.Complete != last.Total {
+		t.Errorf("progress should end complete, got %+v", last)
+	}
+	if n := uploads; n != 9 {
+		t.Errorf("expected 8 layers and the config uploaded, got %d uploads", n)
+	}
+	if n := most; n < 2 || n > 4 {
+		t.Errorf("expected 2 to 4 layer uploads at a time, got %d", n)
+	}
+	assertPushedDigest(t, ref.String(), img)
+}
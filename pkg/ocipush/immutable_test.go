@@ -0,0 +1,88 @@
+package ocipush_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/turbokube/contain/pkg/immutable"
+	"github.com/turbokube/contain/pkg/ocipush"
+)
+
+// TestPushImmutableTag pushes two different layouts to a release tag: the
+// second is refused with both digests in the error, unless forced. A mutable
+// tag and a repeat of the same digest are not affected.
+func TestPushImmutableTag(t *testing.T) {
+	server := httptest.NewServer(quietRegistry())
+	defer server.Close()
+	image := hostOf(server) + "/test/immutable:v1.2.3"
+	opts := ocipush.Options{Auth: authn.Anonymous, ImmutableTags: immutable.Tags{"v*"}}
+
+	first, img1 := layoutWithImage(t, 256, 1)
+	second, img2 := layoutWithImage(t, 256, 1)
+	if err := ocipush.Push(context.Background(), first, image, opts); err != nil {
+		t.Fatalf("first push: %v", err)
+	}
+	if err := ocipush.Push(context.Background(), first, image, opts); err != nil {
+		t.Errorf("same digest again: %v", err)
+	}
+	err := ocipush.Push(context.Background(), second, image, opts)
+	var changed *immutable.ChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("expected an immutable tag error, got %v", err)
+	}
+	d1, _ := img1.Digest()
+	d2, _ := img2.Digest()
+	if changed.Existing != d1.String() || changed.Digest != d2.String() {
+		t.Errorf("error should name both digests: %v", err)
+	}
+	assertPushedDigest(t, image, img1)
+
+	if err := ocipush.Push(context.Background(), second, strings.Replace(image, ":v1.2.3", ":latest", 1), opts); err != nil {
+		t.Errorf("mutable tag: %v", err)
+	}
+	opts.Force = true
+	if err := ocipush.Push(context.Background(), second, image, opts); err != nil {
+		t.Fatalf("forced push: %v", err)
+	}
+	assertPushedDigest(t, image, img2)
+}
+
+// TestMirrorImmutableTag refuses to mirror over an immutable destination tag.
+func TestMirrorImmutableTag(t *testing.T) {
+	server := httptest.NewServer(quietRegistry())
+	defer server.Close()
+	host := hostOf(server)
+	for _, tag := range []string{"src1", "src2"} {
+		img, err := random.Image(256, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, err := name.ParseReference(host + "/test/mirror-src:" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(ref, img, remote.WithAuth(authn.Anonymous)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := ocipush.MirrorOptions{
+		Src: ocipush.SourceOptions{Auth: authn.Anonymous},
+		Dst: ocipush.Options{Auth: authn.Anonymous, ImmutableTags: immutable.Tags{"release-*"}},
+	}
+	dst := host + "/test/mirror-dst:release-1"
+	if err := ocipush.Mirror(context.Background(), host+"/test/mirror-src:src1", dst, opts); err != nil {
+		t.Fatalf("first mirror: %v", err)
+	}
+	err := ocipush.Mirror(context.Background(), host+"/test/mirror-src:src2", dst, opts)
+	if err == nil || !strings.Contains(err.Error(), "immutable tag "+dst) {
+		t.Errorf("expected an immutable tag error, got %v", err)
+	}
+}
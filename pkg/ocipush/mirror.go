@@ -14,6 +14,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/turbokube/contain/pkg/events"
 	"go.uber.org/zap"
 )
 
@@ -55,8 +56,12 @@ type MirrorOptions struct {
 // like crane cp but with the direct-to-storage extension on the push side.
 // All manifests and blobs transfer as raw bytes; every node in the tree is
 // digest-verified before it is pushed, so a compromised or plain-http source
-// cannot inject content under a wrong digest.
-func Mirror(ctx context.Context, srcRef string, dstRef string, opts MirrorOptions) error {
+// cannot inject content under a wrong digest. Blobs from another repository
+// in the same registry are mounted.
+func Mirror(ctx context.Context, srcRef string, dstRef string, opts MirrorOptions) (err error) {
+	phases := events.NewPhases(opts.Dst.Events)
+	phases.Start("mirror")
+	defer func() { phases.End(err) }()
 	var srcNameOpts []name.Option
 	if opts.Src.PlainHTTP {
 		srcNameOpts = append(srcNameOpts, name.Insecure)
@@ -69,6 +74,12 @@ func Mirror(ctx context.Context, srcRef string, dstRef string, opts MirrorOption
 	if err != nil {
 		return fmt.Errorf("parse destination %s: %w", dstRef, err)
 	}
+	return Copy(ctx, src, dst, opts)
+}
+
+// Copy is Mirror for parsed references and without the mirror phase, for
+// copies that are a step of a larger push, like contain's additional tags.
+func Copy(ctx context.Context, src name.Reference, dst name.Reference, opts MirrorOptions) error {
 	srcClient, err := newRegClient(src.Context().Registry, opts.Src.access())
 	if err != nil {
 		return err
@@ -82,7 +93,7 @@ func Mirror(ctx context.Context, srcRef string, dstRef string, opts MirrorOption
 		srcRepo: src.Context().RepositoryStr(), dstRepo: dst.Context().RepositoryStr(),
 		stagingDir: opts.Dst.StagingDir,
 	}
-	logStagingDir(m.stagingDir)
+	logStagingDir(dstClient.log, m.stagingDir)
 
 	raw, mediaType, err := m.fetchManifest(ctx, src.Identifier())
 	if err != nil {
@@ -95,7 +106,7 @@ func Mirror(ctx context.Context, srcRef string, dstRef string, opts MirrorOption
 			return fmt.Errorf("source root manifest digest %s does not match requested %s", actual, d.DigestStr())
 		}
 	}
-	zap.L().Info("mirroring", zap.String("src", src.String()), zap.String("dst", dst.String()))
+	m.dst.log.Info("mirroring", zap.String("src", src.String()), zap.String("dst", dst.String()))
 	return m.pushTree(ctx, raw, mediaType, dst.Identifier())
 }
 
@@ -160,16 +171,29 @@ func (m *mirrorer) fetchManifest(ctx context.Context, ref string) ([]byte, strin
 
 // mirrorBlob streams a source blob to a temp file with inline digest
 // verification, then pushes it (direct-to-storage for large blobs). Skipped
-// entirely when the destination already has the digest.
+// entirely when the destination already has the digest, or can mount it
+// from the source repository.
 func (m *mirrorer) mirrorBlob(ctx context.Context, d descriptor) error {
 	exists, err := m.dst.blobExists(ctx, m.dstRepo, d.Digest, transport.PushScope)
 	if err != nil {
 		return err
 	}
 	if exists {
-		zap.L().Debug("blob exists at destination", zap.String("digest", d.Digest))
+		m.dst.log.Debug("blob exists at destination", zap.String("digest", d.Digest))
+		m.dst.skipped(m.dstRepo, d, events.ReasonExists)
 		return nil
 	}
+	if m.src.reg.RegistryStr() == m.dst.reg.RegistryStr() && m.srcRepo != m.dstRepo {
+		mounted, err := m.dst.mountBlob(ctx, m.dstRepo, m.srcRepo, d.Digest)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			m.dst.log.Debug("blob mounted", zap.String("digest", d.Digest), zap.String("from", m.srcRepo))
+			m.dst.skipped(m.dstRepo, d, events.ReasonMounted)
+			return nil
+		}
+	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
 		fmt.Sprintf("%s/v2/%s/blobs/%s", m.src.base, m.srcRepo, d.Digest), nil)
@@ -2,8 +2,10 @@ package ocipush_test
 
 import (
 	"context"
+	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/google/go-containerregistry/pkg/authn"
@@ -11,6 +13,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 
+	"github.com/turbokube/contain/pkg/events"
 	"github.com/turbokube/contain/pkg/ocipush"
 )
 
@@ -98,3 +101,69 @@ func TestMirror(t *testing.T) {
 		t.Errorf("mirror with wrong pinned digest should fail")
 	}
 }
+
+// TestCopyMounts copies an image to another repository in the same
+// registry, which mounts every blob instead of uploading it.
+func TestCopyMounts(t *testing.T) {
+	// the in-memory registry's blobs are in every repository, so those of
+	// yolean/other are hidden and mounts answered here
+	reg := quietRegistry()
+	var mounts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query(); q.Get("mount") != "" {
+			mounts.Add(1)
+			if q.Get("from") != "yolean/app" {
+				t.Errorf("mount from %s", q.Get("from"))
+			}
+			w.Header().Set("Location", "/v2/yolean/other/blobs/"+q.Get("mount"))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/v2/yolean/other/blobs/") && r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := name.ParseReference(host + "/yolean/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(src, img, remote.WithAuth(authn.Anonymous)); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := name.ParseReference(host + "/yolean/other:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	err = ocipush.Copy(context.Background(), src, dst, ocipush.MirrorOptions{
+		Src: ocipush.SourceOptions{Auth: authn.Anonymous},
+		Dst: ocipush.Options{Auth: authn.Anonymous, Events: rec.emit},
+	})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for _, e := range rec.events {
+		if e.Type == events.BlobSkip && e.Reason != events.ReasonMounted {
+			t.Errorf("blob skipped for %q, not mounted", e.Reason)
+		}
+	}
+	if n := rec.count(events.BlobSkip); n != 3 || mounts.Load() != 3 {
+		t.Errorf("expected 2 layers and the config mounted, got %d skips and %d mount requests", n, mounts.Load())
+	}
+	if n := rec.count(events.BlobUploaded); n != 0 {
+		t.Errorf("expected no uploads, got %d", n)
+	}
+	if n := rec.count(events.ManifestPut); n != 1 {
+		t.Errorf("expected one manifest put, got %d", n)
+	}
+	assertPushedDigest(t, dst.String(), img)
+}
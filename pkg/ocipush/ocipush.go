@@ -29,6 +29,8 @@ import (
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/turbokube/contain/pkg/events"
+	"github.com/turbokube/contain/pkg/immutable"
 	"go.uber.org/zap"
 )
 
@@ -59,6 +61,15 @@ type Options struct {
 	// CONTAIN_STAGING_DIR, then CONTAIN_CACHE_DIR/staging, then the system
 	// temp dir. Push does not stage: it reads from the layout in place.
 	StagingDir string
+	// ImmutableTags are glob patterns for tags that a manifest put must not
+	// move to another digest once they exist.
+	ImmutableTags immutable.Tags
+	// Force ignores ImmutableTags.
+	Force bool
+	// Events, if not nil, gets blob, manifest and directpush events.
+	Events events.Func
+	// Logger defaults to zap.L().
+	Logger *zap.Logger
 }
 
 // descriptor is the subset of an OCI content descriptor we need for walking.
@@ -122,7 +133,10 @@ type pusher struct {
 // reference). A single-entry index.json pushes its entry as the image root
 // (the docker buildx -o type=oci convention); a multi-entry index.json is
 // itself pushed as the root index.
-func Push(ctx context.Context, layoutDir string, image string, opts Options) error {
+func Push(ctx context.Context, layoutDir string, image string, opts Options) (err error) {
+	phases := events.NewPhases(opts.Events)
+	phases.Start("push")
+	defer func() { phases.End(err) }()
 	ref, err := name.ParseReference(image)
 	if err != nil {
 		return fmt.Errorf("parse reference %s: %w", image, err)
@@ -147,10 +161,10 @@ func Push(ctx context.Context, layoutDir string, image string, opts Options) err
 
 	if len(index.Manifests) == 1 {
 		root := index.Manifests[0]
-		zap.L().Info("pushing", zap.String("ref", ref.String()), zap.String("root", root.Digest))
+		p.c.log.Info("pushing", zap.String("ref", ref.String()), zap.String("root", root.Digest))
 		return p.pushManifestDescriptor(ctx, root, ref.Identifier())
 	}
-	zap.L().Info("pushing multi-entry layout index as root",
+	p.c.log.Info("pushing multi-entry layout index as root",
 		zap.String("ref", ref.String()), zap.Int("manifests", len(index.Manifests)))
 	return p.pushManifestBytes(ctx, indexBytes, index.MediaType, ref.Identifier())
 }
@@ -112,7 +112,7 @@ func NewProxy(upstreamHost string, prefix string, opts Options) (*Proxy, error)
 	if err != nil {
 		return nil, err
 	}
-	logStagingDir(opts.StagingDir)
+	logStagingDir(c.log, opts.StagingDir)
 	return &Proxy{
 		c:          c,
 		prefix:     prefix,
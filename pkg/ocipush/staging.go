@@ -65,6 +65,6 @@ func stagingDirName(resolved string) string {
 
 // logStagingDir reports once where blobs will be staged, so an operator
 // hitting a full or memory-backed filesystem can see which one it was.
-func logStagingDir(dir string) {
-	zap.L().Debug("staging blobs", zap.String("dir", stagingDirName(resolveStagingDir(dir))))
+func logStagingDir(log *zap.Logger, dir string) {
+	log.Debug("staging blobs", zap.String("dir", stagingDirName(resolveStagingDir(dir))))
 }
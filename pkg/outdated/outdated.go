@@ -0,0 +1,310 @@
+// Package outdated finds contain.yaml files with a base pinned as
+// repo:tag@digest where the tag has since moved, and optionally bumps the
+// digest in place.
+package outdated
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/turbokube/contain/pkg/schema"
+	schemav1 "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+// ConfigName is the file name that Find looks for
+const ConfigName = "contain.yaml"
+
+// skipDirs are not searched for configs
+var skipDirs = map[string]bool{".git": true, "node_modules": true, "vendor": true}
+
+// Registry is what a check needs from the registries of the bases. Both
+// methods get the config the base is from, for its registries settings.
+type Registry interface {
+	// Resolve returns the digest ref currently points to
+	Resolve(config schemav1.ContainConfig, ref name.Reference) (v1.Hash, error)
+	// Platforms returns the platforms of the index or image at ref
+	Platforms(config schemav1.ContainConfig, ref name.Reference) ([]string, error)
+}
+
+// Summary is the machine readable result
+type Summary struct {
+	Configs  []Config `json:"configs"`
+	Outdated int      `json:"outdated"`
+	Bumped   int      `json:"bumped"`
+	Errors   int      `json:"errors"`
+}
+
+// Config is the result for one base of a contain.yaml, base or an entry
+// of basePerPlatform
+type Config struct {
+	Path string `json:"path"`
+	Base string `json:"base"`
+	// Key is the basePerPlatform key, empty for base
+	Key string `json:"key,omitempty"`
+	// Tag is the part of base that is re-resolved
+	Tag       string        `json:"tag,omitempty"`
+	Current   string        `json:"current,omitempty"`
+	Latest    string        `json:"latest,omitempty"`
+	Outdated  bool          `json:"outdated"`
+	Platforms *PlatformDiff `json:"platforms,omitempty"`
+	Bumped    bool          `json:"bumped,omitempty"`
+	// Skipped says why the config was not checked, for example a base
+	// without a tag
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PlatformDiff compares the platforms of the current and the latest digest
+type PlatformDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Find returns the contain.yaml files under root, sorted.
+func Find(root string) ([]string, error) {
+	var found []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && skipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == ConfigName {
+			found = append(found, p)
+		}
+		return nil
+	})
+	sort.Strings(found)
+	return found, err
+}
+
+// Check checks every base of every config in paths, and with bump rewrites
+// the digest of those that are outdated. A failure for one base is reported
+// in its result, not returned.
+func Check(paths []string, registry Registry, bump bool) Summary {
+	summary := Summary{Configs: make([]Config, 0, len(paths))}
+	for _, p := range paths {
+		results := check(p, registry)
+		bumped := map[string]bool{}
+		for _, c := range results {
+			if c.Outdated {
+				summary.Outdated++
+				if bump && !bumped[c.Base] {
+					if err := bumpBase(p, c.Base, c.Tag+"@"+c.Latest, uses(results, c.Base)); err != nil {
+						c.Error = err.Error()
+					} else {
+						bumped[c.Base] = true
+					}
+				}
+				if bumped[c.Base] {
+					c.Bumped = true
+					summary.Bumped++
+				}
+			}
+			if c.Error != "" {
+				summary.Errors++
+			}
+			summary.Configs = append(summary.Configs, c)
+		}
+	}
+	return summary
+}
+
+// uses counts the results for base, which is written once per use
+func uses(results []Config, base string) int {
+	n := 0
+	for _, c := range results {
+		if c.Base == base {
+			n++
+		}
+	}
+	return n
+}
+
+// check returns a result for base and one for each basePerPlatform entry, in
+// key order, or a single result with the error if the config can't be read.
+func check(path string, registry Registry) []Config {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return []Config{{Path: path, Error: err.Error()}}
+	}
+	config, err := schema.Parse(content)
+	if err != nil {
+		return []Config{{Path: path, Error: err.Error()}}
+	}
+	var results []Config
+	if config.Base != "" || len(config.BasePerPlatform) == 0 {
+		results = append(results, checkBase(path, config, "", config.Base, registry))
+	}
+	for _, k := range schemav1.BasePerPlatformKeys(config) {
+		results = append(results, checkBase(path, config, k, config.BasePerPlatform[k], registry))
+	}
+	return results
+}
+
+func checkBase(path string, config schemav1.ContainConfig, key, base string, registry Registry) Config {
+	c := Config{Path: path, Base: base, Key: key}
+	tag, digest, ok := strings.Cut(base, "@")
+	if !ok {
+		c.Skipped = "base has no digest"
+		return c
+	}
+	tagRef, err := name.NewTag(tag)
+	if err != nil || !strings.Contains(tag[strings.LastIndex(tag, "/")+1:], ":") {
+		c.Skipped = "base has no tag"
+		return c
+	}
+	c.Tag, c.Current = tag, digest
+	latest, err := registry.Resolve(config, tagRef)
+	if err != nil {
+		c.Error = fmt.Sprintf("resolve %s: %v", tag, err)
+		return c
+	}
+	c.Latest = latest.String()
+	if c.Latest == c.Current {
+		return c
+	}
+	c.Outdated = true
+	zap.L().Info("outdated", zap.String("path", path), zap.String("key", key), zap.String("tag", tag),
+		zap.String("current", c.Current), zap.String("latest", c.Latest))
+	diff, err := diffPlatforms(config, registry, tagRef, c.Current, c.Latest)
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	c.Platforms = diff
+	return c
+}
+
+func diffPlatforms(config schemav1.ContainConfig, registry Registry, tag name.Tag, current, latest string) (*PlatformDiff, error) {
+	before, err := registry.Platforms(config, tag.Context().Digest(current))
+	if err != nil {
+		return nil, fmt.Errorf("platforms of %s: %w", current, err)
+	}
+	after, err := registry.Platforms(config, tag.Context().Digest(latest))
+	if err != nil {
+		return nil, fmt.Errorf("platforms of %s: %w", latest, err)
+	}
+	diff := &PlatformDiff{Added: missingFrom(before, after), Removed: missingFrom(after, before)}
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 {
+		return nil, nil
+	}
+	return diff, nil
+}
+
+// missingFrom returns the entries of b that a lacks.
+func missingFrom(a, b []string) []string {
+	var out []string
+	for _, s := range b {
+		found := false
+		for _, t := range a {
+			if s == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Bump replaces base with bumped in the config file at path, as text, so
+// that comments and formatting are kept. base must occur exactly once.
+func Bump(path, base, bumped string) error {
+	return bumpBase(path, base, bumped, 1)
+}
+
+// bumpBase is Bump for a base that the config uses n times, for example as
+// base and for a basePerPlatform key
+func bumpBase(path, base, bumped string, n int) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch found := bytes.Count(content, []byte(base)); {
+	case found == n:
+	case found == 0:
+		return errors.New("base not found as written, is it templated?")
+	case n == 1:
+		return fmt.Errorf("base found %d times, expected once", found)
+	default:
+		return fmt.Errorf("base found %d times, expected %d", found, n)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, bytes.ReplaceAll(content, []byte(base), []byte(bumped)), info.Mode())
+}
+
+// RemoteRegistry is Registry over go-containerregistry remote, with
+// options per config and reference, as registry access may differ per base.
+type RemoteRegistry struct {
+	Options func(config schemav1.ContainConfig, ref name.Reference) ([]remote.Option, error)
+}
+
+func (r RemoteRegistry) Resolve(config schemav1.ContainConfig, ref name.Reference) (v1.Hash, error) {
+	options, err := r.Options(config, ref)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	desc, err := remote.Head(ref, options...)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	return desc.Digest, nil
+}
+
+func (r RemoteRegistry) Platforms(config schemav1.ContainConfig, ref name.Reference) ([]string, error) {
+	options, err := r.Options(config, ref)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		m, err := index.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		var platforms []string
+		for _, d := range m.Manifests {
+			// attestation manifests declare unknown/unknown
+			if d.Platform != nil && d.Platform.OS != "unknown" {
+				platforms = append(platforms, d.Platform.String())
+			}
+		}
+		return platforms, nil
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	return []string{cfg.Platform().String()}, nil
+}
@@ -0,0 +1,172 @@
+package outdated
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	schemav1 "github.com/turbokube/contain/pkg/schema/v1"
+)
+
+const (
+	digestOld = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	digestNew = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+type fakeRegistry struct {
+	tags      map[string]string
+	platforms map[string][]string
+	// registries gets the registries settings of each resolved config
+	registries map[string]map[string]schemav1.RegistrySettings
+}
+
+func (f fakeRegistry) Resolve(config schemav1.ContainConfig, ref name.Reference) (v1.Hash, error) {
+	if f.registries != nil {
+		f.registries[ref.String()] = config.Registries
+	}
+	return v1.NewHash(f.tags[ref.String()])
+}
+
+func (f fakeRegistry) Platforms(config schemav1.ContainConfig, ref name.Reference) ([]string, error) {
+	return f.platforms[ref.Identifier()], nil
+}
+
+func writeConfig(t *testing.T, root, rel, content string) string {
+	t.Helper()
+	p := filepath.Join(root, rel)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+const outdatedConfig = `# the api service
+base: example.net/base:22@` + digestOld + ` # pinned by bot
+tag: example.net/api
+layers:
+- localDir:
+    path: .
+`
+
+func TestCheckAndBump(t *testing.T) {
+	root := t.TempDir()
+	api := writeConfig(t, root, "api/contain.yaml", outdatedConfig)
+	writeConfig(t, root, "web/contain.yaml", "base: example.net/base:22@"+digestNew+"\ntag: example.net/web\n")
+	writeConfig(t, root, "lock/contain.yaml", "base: example.net/base:22\ntag: example.net/lock\n")
+	writeConfig(t, root, "node_modules/x/contain.yaml", "base: example.net/ignored:1@"+digestOld+"\n")
+
+	paths, err := Find(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 {
+		t.Fatalf("expected node_modules to be skipped, got %v", paths)
+	}
+	registry := fakeRegistry{
+		tags: map[string]string{"example.net/base:22": digestNew},
+		platforms: map[string][]string{
+			digestOld: {"linux/amd64", "linux/arm/v7"},
+			digestNew: {"linux/amd64", "linux/arm64"},
+		},
+	}
+
+	summary := Check(paths, registry, false)
+	if summary.Outdated != 1 || summary.Bumped != 0 || summary.Errors != 0 {
+		t.Fatalf("summary %+v", summary)
+	}
+	byPath := map[string]Config{}
+	for _, c := range summary.Configs {
+		byPath[c.Path] = c
+	}
+	c := byPath[api]
+	if !c.Outdated || c.Latest != digestNew || c.Tag != "example.net/base:22" {
+		t.Errorf("api %+v", c)
+	}
+	if c.Platforms == nil || len(c.Platforms.Added) != 1 || c.Platforms.Added[0] != "linux/arm64" ||
+		len(c.Platforms.Removed) != 1 || c.Platforms.Removed[0] != "linux/arm/v7" {
+		t.Errorf("platforms %+v", c.Platforms)
+	}
+	if s := byPath[filepath.Join(root, "lock/contain.yaml")].Skipped; s != "base has no digest" {
+		t.Errorf("tag-only base: %q", s)
+	}
+
+	summary = Check(paths, registry, true)
+	if summary.Bumped != 1 {
+		t.Fatalf("summary %+v", summary)
+	}
+	bumped, err := os.ReadFile(api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# the api service
+base: example.net/base:22@` + digestNew + ` # pinned by bot
+tag: example.net/api
+layers:
+- localDir:
+    path: .
+`
+	if string(bumped) != expected {
+		t.Errorf("bump must only change the digest, got\n%s", bumped)
+	}
+	if summary := Check(paths, registry, false); summary.Outdated != 0 {
+		t.Errorf("expected nothing outdated after bump, got %+v", summary)
+	}
+}
+
+func TestCheck_NoTag(t *testing.T) {
+	root := t.TempDir()
+	p := writeConfig(t, root, "contain.yaml", "base: example.net/base@"+digestOld+"\n")
+	summary := Check([]string{p}, fakeRegistry{}, false)
+	if summary.Configs[0].Skipped != "base has no tag" {
+		t.Errorf("got %+v", summary.Configs[0])
+	}
+}
+
+const perPlatformConfig = `base: example.net/base:22@` + digestOld + `
+basePerPlatform:
+  linux/arm64: example.net/base-arm:22@` + digestOld + `
+  linux/s390x: example.net/base:22@` + digestOld + `
+registries:
+  example.net:
+    anonymous: true
+tag: example.net/api
+`
+
+func TestCheckAndBump_BasePerPlatform(t *testing.T) {
+	root := t.TempDir()
+	p := writeConfig(t, root, "contain.yaml", perPlatformConfig)
+	registry := fakeRegistry{
+		tags: map[string]string{
+			"example.net/base:22":     digestNew,
+			"example.net/base-arm:22": digestNew,
+		},
+		registries: map[string]map[string]schemav1.RegistrySettings{},
+	}
+
+	summary := Check([]string{p}, registry, true)
+	if len(summary.Configs) != 3 || summary.Outdated != 3 || summary.Bumped != 3 || summary.Errors != 0 {
+		t.Fatalf("summary %+v", summary)
+	}
+	for i, key := range []string{"", "linux/arm64", "linux/s390x"} {
+		if summary.Configs[i].Key != key || !summary.Configs[i].Bumped {
+			t.Errorf("configs[%d] %+v", i, summary.Configs[i])
+		}
+	}
+	if !registry.registries["example.net/base-arm:22"]["example.net"].Anonymous {
+		t.Errorf("resolve without the config's registries: %v", registry.registries)
+	}
+	bumped, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.ReplaceAll(perPlatformConfig, digestOld, digestNew)
+	if string(bumped) != expected {
+		t.Errorf("got\n%s", bumped)
+	}
+}
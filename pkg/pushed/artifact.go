@@ -9,7 +9,6 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/types"
-	"go.uber.org/zap"
 )
 
 // Artifact represents what we need to know (without manifest fetch) about the result of build+push
@@ -27,6 +26,28 @@ type Artifact struct {
 	BaseRef string `json:"base,omitempty"`
 	// Output is the local path and format where the image was written, if --output was used.
 	Output *ArtifactOutput `json:"output,omitempty"`
+	// PlatformSelection records how Platforms was decided when the config
+	// left it to contain, i.e. platforms: auto.
+	PlatformSelection *PlatformSelection `json:"platformSelection,omitempty"`
+	// LayersPerPlatform is the number of layers appended to each platform's
+	// base, keyed by platform, which differs between platforms when layers
+	// have a platforms selector.
+	LayersPerPlatform map[string]int `json:"layersPerPlatform,omitempty"`
+	// Children are the index's children that were also tagged, see the
+	// childTag config. They are nested rather than additional builds because
+	// skaffold keys builds by imageName, which they share with the index.
+	Children []Artifact `json:"children,omitempty"`
+	// AdditionalTags are the additionalTags config, as tag@digest, that the
+	// result was also pushed to, none for a build that isn't pushed
+	AdditionalTags []string `json:"additionalTags,omitempty"`
+	// AdditionalTagsExist are the AdditionalTags whose repository had the
+	// result already, for which the push was at most a tag, see ReasonExists
+	AdditionalTagsExist []string `json:"additionalTagsExist,omitempty"`
+	// Pushed is set when the build was to be pushed, false if the
+	// destination already had the result, see Reason
+	Pushed *bool `json:"pushed,omitempty"`
+	// Reason is why the result was not pushed
+	Reason string `json:"reason,omitempty"`
 	// reference is kept internally for reuse
 	reference name.Reference
 	// http is kept internally to assist http access
@@ -41,6 +62,19 @@ type ArtifactOutput struct {
 	Format string `json:"format"`
 }
 
+// PlatformSelection is the outcome of a platforms mode that picks platforms
+// from the base, and the reason for each base platform left out.
+type PlatformSelection struct {
+	Mode    string            `json:"mode"`
+	Dropped []DroppedPlatform `json:"dropped,omitempty"`
+}
+
+// DroppedPlatform is a base platform that was not built, and why.
+type DroppedPlatform struct {
+	Platform string   `json:"platform"`
+	Reasons  []string `json:"reasons"`
+}
+
 type ArtifactHttp struct {
 	// Host is the registry host without protocol but with port
 	Host string
@@ -52,6 +86,20 @@ type ArtifactHttp struct {
 	Hash v1.Hash
 }
 
+// ReasonExists is the Reason when the destination already had the result's
+// manifest, and at most the tag was moved
+const ReasonExists = "exists"
+
+// SetPushed records the outcome of pushing, which was a no-op if existed
+func (a *Artifact) SetPushed(existed bool) {
+	pushed := !existed
+	a.Pushed = &pushed
+	a.Reason = ""
+	if existed {
+		a.Reason = ReasonExists
+	}
+}
+
 func (a *Artifact) Reference() name.Reference {
 	return a.reference
 }
@@ -75,19 +123,17 @@ func newRef(tagRef string, hash v1.Hash) (*Artifact, error) {
 
 	ref, err := reference.Parse(full)
 	if err != nil {
-		zap.L().Error("parse", zap.String("ref", full), zap.Error(err))
-		return nil, err
+		return nil, fmt.Errorf("parse %s: %w", full, err)
 	}
-	named := ref.(reference.Named)
-	if named == nil {
-		zap.L().Error("named", zap.String("parsed", full), zap.String("ref", ref.String()))
+	named, ok := ref.(reference.Named)
+	if !ok {
+		return nil, fmt.Errorf("%s has no name", full)
 	}
 
 	// found no way to get default repo and tag from
 	r, err := name.ParseReference(tagRef)
 	if err != nil {
-		zap.L().Error("parse", zap.String("ref", tagRef))
-		return nil, err
+		return nil, fmt.Errorf("parse %s: %w", tagRef, err)
 	}
 
 	// actually we can't use ref because it prepends default registry, skaffold probably doesn't do that
@@ -113,14 +159,14 @@ func NewSingleImage(tagRef string, digest v1.Hash, image v1.Image, platform *v1.
 	// Get the image config digest
 	configHash, err := image.ConfigName()
 	if err != nil {
-		zap.L().Warn("failed to get config digest", zap.Error(err))
+		return nil, fmt.Errorf("config digest of %s: %w", tagRef, err)
 	}
 	a.singleImageConfigHash = configHash
 
 	// Get the manifest for size and media type
 	manifest, err := image.Manifest()
 	if err != nil {
-		zap.L().Warn("failed to get manifest", zap.Error(err))
+		return nil, fmt.Errorf("manifest of %s: %w", tagRef, err)
 	}
 
 	a.MediaType = manifest.MediaType
@@ -144,7 +190,7 @@ func NewIndexImage(tagRef string, digest v1.Hash, image v1.ImageIndex, baseRef s
 	// Get the manifest for size and media type
 	manifest, err := image.IndexManifest()
 	if err != nil {
-		zap.L().Warn("failed to get manifest", zap.Error(err))
+		return nil, fmt.Errorf("index manifest of %s: %w", tagRef, err)
 	}
 
 	a.MediaType = manifest.MediaType
@@ -183,18 +229,18 @@ func (a *Artifact) UnmarshalJSON(data []byte) error {
 	}
 
 	if digestStr != "" {
-		if h, err := v1.NewHash(digestStr); err == nil {
-			a.hash = h
-		} else {
-			zap.L().Warn("failed to parse digest from tag", zap.String("tag", a.TagRef), zap.Error(err))
+		h, err := v1.NewHash(digestStr)
+		if err != nil {
+			return fmt.Errorf("digest of tag %s: %w", a.TagRef, err)
 		}
+		a.hash = h
 	}
 
-	if r, err := name.ParseReference(base); err == nil {
-		a.reference = r
-	} else {
-		zap.L().Warn("failed to parse reference from tag", zap.String("tag", a.TagRef), zap.Error(err))
+	r, err := name.ParseReference(base)
+	if err != nil {
+		return fmt.Errorf("reference of tag %s: %w", a.TagRef, err)
 	}
+	a.reference = r
 
 	// singleImageConfigHash cannot be reconstructed from JSON; leave zero value
 	return nil
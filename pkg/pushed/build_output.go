@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
@@ -54,7 +55,7 @@ func NewBuildOutput(tag string, a *Artifact) (*BuildOutput, error) {
 	// Buildctl metadata
 	md := &MetadataSimilarToBuildctlFile{
 		ContainerImageDigest: a.hash.String(),
-		ImageName:            tag,
+		ImageName:            strings.Join(append([]string{tag}, a.additionalTagNames()...), ","),
 		ContainerImageDescriptor: ContainerImageDescriptor{
 			MediaType: string(a.MediaType),
 			Digest:    a.hash.String(),
@@ -71,6 +72,22 @@ func NewBuildOutput(tag string, a *Artifact) (*BuildOutput, error) {
 		md.ContainerImageConfigDigest = a.singleImageConfigHash.String()
 	}
 
+	for _, c := range a.Children {
+		child := ChildImageMetadata{
+			ContainerImageConfigDigest: c.singleImageConfigHash.String(),
+			ContainerImageDigest:       c.hash.String(),
+			ImageName:                  c.reference.String(),
+			ContainerImageDescriptor: ContainerImageDescriptor{
+				MediaType: string(c.MediaType),
+				Digest:    c.hash.String(),
+			},
+		}
+		if len(c.Platforms) > 0 {
+			child.ContainerImageDescriptor.Platform = Platform{Architecture: c.Platforms[0].Architecture, OS: c.Platforms[0].OS}
+		}
+		md.Children = append(md.Children, child)
+	}
+
 	return &BuildOutput{Skaffold: s, Buildctl: md}, nil
 }
 
@@ -116,3 +133,13 @@ func (b *BuildOutput) WriteJSON(f *os.File) error {
 	_, err = f.Write(j)
 	return err
 }
+
+// additionalTagNames is AdditionalTags without digests, the way buildctl
+// lists every name an image was pushed to in image.name
+func (a *Artifact) additionalTagNames() []string {
+	names := make([]string, len(a.AdditionalTags))
+	for i, t := range a.AdditionalTags {
+		names[i], _, _ = strings.Cut(t, "@")
+	}
+	return names
+}
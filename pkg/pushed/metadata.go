@@ -18,5 +18,16 @@ type MetadataSimilarToBuildctlFile struct {
 	ContainerImageDescriptor   ContainerImageDescriptor `json:"containerimage.descriptor"`
 	ContainerImageDigest       string                   `json:"containerimage.digest"`
 	ImageName                  string                   `json:"image.name"`
+	// Children is not part of buildctl's format, see Artifact.Children
+	Children []ChildImageMetadata `json:"contain.children,omitempty"`
 	// TODO Annotations                map[string]string        `json:"annotations,omitempty"`
 }
+
+// ChildImageMetadata is a tagged child of an index, with the keys buildctl
+// uses for an image
+type ChildImageMetadata struct {
+	ContainerImageConfigDigest string                   `json:"containerimage.config.digest"`
+	ContainerImageDescriptor   ContainerImageDescriptor `json:"containerimage.descriptor"`
+	ContainerImageDigest       string                   `json:"containerimage.digest"`
+	ImageName                  string                   `json:"image.name"`
+}
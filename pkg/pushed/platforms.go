@@ -27,7 +27,7 @@ func platformsFromIndexManifest(idxm *v1.IndexManifest) []v1.Platform {
 		if d.Platform == nil {
 			continue
 		}
-		if d.MediaType != types.OCIManifestSchema1 {
+		if d.MediaType != types.OCIManifestSchema1 && d.MediaType != types.DockerManifestSchema2 {
 			// Skip non-image manifest entries (e.g., referrers/other types)
 			continue
 		}
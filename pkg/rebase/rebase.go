@@ -0,0 +1,369 @@
+// Package rebase moves a contain-built image to a new base, from what the
+// image itself records: its base annotations, the layers on top of the old
+// base, and how its config differs from the old base's.
+package rebase
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/turbokube/contain/pkg/annotate"
+	"github.com/turbokube/contain/pkg/appender"
+	"github.com/turbokube/contain/pkg/multiarch"
+	"github.com/turbokube/contain/pkg/platform"
+	"github.com/turbokube/contain/pkg/pushed"
+	"github.com/turbokube/contain/pkg/registry"
+	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+)
+
+// Options for Rebase
+type Options struct {
+	// Image is the contain-built image or index to rebase
+	Image string
+	// Base is the new base, with a digest
+	Base string
+	// Tag is where the result is pushed, default Image
+	Tag string
+	// OldBase is the old base, for images without the base name annotation.
+	// Its digest, if any, must match the annotation.
+	OldBase string
+	// MediaTypes is as in config, for a Docker new base
+	MediaTypes string
+	// Push is false for a dry run
+	Push bool
+	// Context cancels registry requests. Default context.Background.
+	Context context.Context
+	// Logger defaults to zap.L()
+	Logger *zap.Logger
+}
+
+func (o Options) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+func (o Options) logger() *zap.Logger {
+	if o.Logger == nil {
+		return zap.L()
+	}
+	return o.Logger
+}
+
+// lifted is what contain added to the old base for one platform
+type lifted struct {
+	platform   v1.Platform
+	layers     []v1.Layer
+	envs       []string
+	entrypoint []string
+	args       []string
+	workdir    string
+}
+
+// Rebase appends each platform's lifted layers and config overrides to the
+// matching child of the new base, and pushes the result like a build would.
+func Rebase(opts Options) (*pushed.BuildOutput, error) {
+	if opts.Tag == "" {
+		opts.Tag = opts.Image
+	}
+	log := opts.logger()
+	config := schema.ContainConfig{Base: opts.Base, Tag: opts.Tag, MediaTypes: opts.MediaTypes}
+	reg, err := registry.NewWithLogger(config, log)
+	if err != nil {
+		return nil, err
+	}
+	pushReg, err := registry.NewPushWithLogger(config, log)
+	if err != nil {
+		return nil, err
+	}
+	imageRef, err := name.ParseReference(opts.Image)
+	if err != nil {
+		return nil, err
+	}
+	tagRef, err := name.ParseReference(opts.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	imageReg, err := registry.NewPushWithLogger(schema.ContainConfig{Tag: opts.Image}, log)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range []*registry.RegistryConfig{reg, pushReg, imageReg} {
+		r.WithContext(opts.context())
+	}
+	children, isIndex, err := imageChildren(imageRef, imageReg)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) == 0 {
+		return nil, fmt.Errorf("%s has no image manifests", opts.Image)
+	}
+	lifts := make([]lifted, 0, len(children))
+	for _, c := range children {
+		l, err := lift(c, opts.OldBase, reg, log)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", opts.Image, c.platform.String(), err)
+		}
+		lifts = append(lifts, l)
+		config.Platforms = append(config.Platforms, c.platform.String())
+	}
+	// the result has the shape of the image, even if it has one child
+	config.WrapIndex = isIndex
+
+	index, err := multiarch.NewFromMultiArchBase(config, reg)
+	if err != nil {
+		return nil, err
+	}
+	unmatched, err := multiarch.UnmatchedPlatforms(config.Platforms.Requested(), index.MatchedPlatforms())
+	if err != nil {
+		return nil, err
+	}
+	if len(unmatched) > 0 {
+		return nil, fmt.Errorf("new base %s lacks platforms %v of %s, it has %v",
+			opts.Base, unmatched, opts.Image, index.BasePlatforms())
+	}
+
+	var existing registry.Existing
+	each := func(b name.Digest, t name.Reference, tr *registry.RegistryConfig, p v1.Platform) (mutate.IndexAddendum, error) {
+		var l *lifted
+		for i := range lifts {
+			if platform.Equal(lifts[i].platform, p) {
+				l = &lifts[i]
+				break
+			}
+		}
+		if l == nil {
+			return mutate.IndexAddendum{}, fmt.Errorf("nothing lifted for %s", p.String())
+		}
+		a, err := appender.New(b, reg, t)
+		if err != nil {
+			return mutate.IndexAddendum{}, err
+		}
+		a.WithPushConfig(tr)
+		a.WithSkipPush(!opts.Push)
+		a.WithPushByDigest(isIndex)
+		a.WithConvertToOCI(index.ConvertToOCI())
+		a.WithEnvs(l.envs)
+		a.WithEntrypointArgs(l.entrypoint, l.args)
+		a.WithWorkdir(l.workdir)
+		ann, err := annotate.NewBaseImageAnnotations(opts.Base)
+		if err != nil {
+			return mutate.IndexAddendum{}, err
+		}
+		a.WithAnnotate(ann)
+		log.Info("rebasing", zap.String("platform", p.String()), zap.Int("layers", len(l.layers)))
+		r, err := a.Append(l.layers...)
+		if err != nil {
+			return mutate.IndexAddendum{}, err
+		}
+		existing = r.Existing
+		return r.Pushed, nil
+	}
+
+	var result *pushed.Artifact
+	if isIndex {
+		_, result, err = index.BuildWithAppend(each, tagRef, pushReg, opts.Push)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		prototype, err := index.GetPrototypeBase()
+		if err != nil {
+			return nil, err
+		}
+		added, err := each(prototype, tagRef, pushReg, index.PrototypePlatform())
+		if err != nil {
+			return nil, err
+		}
+		img, _ := added.Add.(v1.Image)
+		result, err = pushed.NewSingleImage(tagRef.String(), added.Descriptor.Digest, img, added.Descriptor.Platform, opts.Base)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Push {
+			result.SetPushed(existing != registry.Missing)
+		}
+	}
+	return pushed.NewBuildOutput(tagRef.String(), result)
+}
+
+type child struct {
+	platform v1.Platform
+	image    v1.Image
+}
+
+// imageChildren returns the image manifests of ref, skipping attestations,
+// and whether ref is an index.
+func imageChildren(ref name.Reference, reg *registry.RegistryConfig) ([]child, bool, error) {
+	desc, err := remote.Get(ref, reg.CraneOptions.Remote...)
+	if err != nil {
+		return nil, false, err
+	}
+	if !desc.MediaType.IsIndex() {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, false, err
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return nil, false, err
+		}
+		return []child{{platform: *cfg.Platform(), image: img}}, false, nil
+	}
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, true, err
+	}
+	m, err := index.IndexManifest()
+	if err != nil {
+		return nil, true, err
+	}
+	var children []child
+	for _, d := range m.Manifests {
+		if d.Platform == nil || !d.MediaType.IsImage() || d.Platform.String() == pushed.AttestationPlatform {
+			continue
+		}
+		img, err := index.Image(d.Digest)
+		if err != nil {
+			return nil, true, err
+		}
+		children = append(children, child{platform: *d.Platform, image: img})
+	}
+	return children, true, nil
+}
+
+// lift finds the old base child for c from c's base annotations, checks
+// that c starts with its layers, and returns what c adds.
+func lift(c child, oldBase string, reg *registry.RegistryConfig, log *zap.Logger) (lifted, error) {
+	manifest, err := c.image.Manifest()
+	if err != nil {
+		return lifted{}, err
+	}
+	baseRef, err := oldBaseRef(manifest.Annotations, oldBase)
+	if err != nil {
+		return lifted{}, err
+	}
+	base, err := baseChild(baseRef, c.platform, reg)
+	if err != nil {
+		return lifted{}, fmt.Errorf("old base %s: %w", baseRef, err)
+	}
+	baseManifest, err := base.Manifest()
+	if err != nil {
+		return lifted{}, err
+	}
+	if len(manifest.Layers) < len(baseManifest.Layers) {
+		return lifted{}, fmt.Errorf("has %d layers, fewer than old base %s", len(manifest.Layers), baseRef)
+	}
+	for i, l := range baseManifest.Layers {
+		if manifest.Layers[i].Digest != l.Digest {
+			return lifted{}, fmt.Errorf("layer %d is %s, not old base %s layer %s", i, manifest.Layers[i].Digest, baseRef, l.Digest)
+		}
+	}
+	layers, err := c.image.Layers()
+	if err != nil {
+		return lifted{}, err
+	}
+	cfg, err := c.image.ConfigFile()
+	if err != nil {
+		return lifted{}, err
+	}
+	baseCfg, err := base.ConfigFile()
+	if err != nil {
+		return lifted{}, err
+	}
+	l := lifted{
+		platform: c.platform,
+		layers:   layers[len(baseManifest.Layers):],
+		envs:     envOverrides(baseCfg.Config.Env, cfg.Config.Env),
+	}
+	if !slices.Equal(cfg.Config.Entrypoint, baseCfg.Config.Entrypoint) {
+		l.entrypoint = cfg.Config.Entrypoint
+	}
+	if !slices.Equal(cfg.Config.Cmd, baseCfg.Config.Cmd) {
+		l.args = cfg.Config.Cmd
+	}
+	if cfg.Config.WorkingDir != baseCfg.Config.WorkingDir {
+		l.workdir = cfg.Config.WorkingDir
+	}
+	log.Info("lifted",
+		zap.String("platform", c.platform.String()),
+		zap.String("oldBase", baseRef.String()),
+		zap.Int("layers", len(l.layers)),
+		zap.Strings("env", l.envs),
+	)
+	return l, nil
+}
+
+// oldBaseRef is the base from the annotations that contain sets, or
+// oldBase if the image has no name annotation.
+func oldBaseRef(annotations map[string]string, oldBase string) (name.Digest, error) {
+	digest := annotations[specsv1.AnnotationBaseImageDigest]
+	baseName := annotations[specsv1.AnnotationBaseImageName]
+	if oldBase != "" {
+		ref, err := name.ParseReference(oldBase)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		if d, ok := ref.(name.Digest); ok {
+			if digest != "" && d.DigestStr() != digest {
+				return name.Digest{}, fmt.Errorf("old base %s does not match annotation %s %s", oldBase, specsv1.AnnotationBaseImageDigest, digest)
+			}
+			return d, nil
+		}
+		baseName = oldBase
+	}
+	if digest == "" {
+		return name.Digest{}, fmt.Errorf("no %s annotation, was the image built by contain?", specsv1.AnnotationBaseImageDigest)
+	}
+	if baseName == "" {
+		return name.Digest{}, fmt.Errorf("no %s annotation, use --old-base to name the old base repository", specsv1.AnnotationBaseImageName)
+	}
+	repo, err := name.ParseReference(baseName)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return repo.Context().Digest(digest), nil
+}
+
+// baseChild returns the image for p in the old base, an index or an image.
+func baseChild(ref name.Digest, p v1.Platform, reg *registry.RegistryConfig) (v1.Image, error) {
+	desc, err := remote.Get(ref, reg.CraneOptions.Remote...)
+	if err != nil {
+		return nil, err
+	}
+	if !desc.MediaType.IsIndex() {
+		return desc.Image()
+	}
+	m, err := v1.ParseIndexManifest(bytes.NewReader(desc.Manifest))
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range m.Manifests {
+		if d.Platform != nil && d.MediaType.IsImage() && platform.Equal(*d.Platform, p) {
+			return remote.Image(ref.Context().Digest(d.Digest.String()), reg.CraneOptions.Remote...)
+		}
+	}
+	return nil, fmt.Errorf("no manifest for %s", p.String())
+}
+
+// envOverrides returns the entries of env that base does not have as is.
+func envOverrides(base, env []string) []string {
+	var overrides []string
+	for _, e := range env {
+		if !slices.Contains(base, e) && strings.Contains(e, "=") {
+			overrides = append(overrides, e)
+		}
+	}
+	return overrides
+}
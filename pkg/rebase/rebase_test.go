@@ -0,0 +1,181 @@
+package rebase_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/turbokube/contain/pkg/rebase"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var platforms = []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}
+
+func testRegistry(t *testing.T) string {
+	t.Helper()
+	s := httptest.NewServer(ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(s.Close)
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// pushBase pushes a linux/amd64 + linux/arm64 index and returns it as
+// repo:tag@digest.
+func pushBase(t *testing.T, host string, tag string) string {
+	t.Helper()
+	idx := v1.ImageIndex(empty.Index)
+	for _, p := range platforms {
+		img, err := random.Image(64, 1)
+		Expect(err).NotTo(HaveOccurred())
+		cfg, err := img.ConfigFile()
+		Expect(err).NotTo(HaveOccurred())
+		cfg = cfg.DeepCopy()
+		cfg.OS, cfg.Architecture = p.OS, p.Architecture
+		cfg.Config.Env = []string{"PATH=/usr/bin"}
+		img, err = mutate.ConfigFile(img, cfg)
+		Expect(err).NotTo(HaveOccurred())
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add:        mutate.ConfigMediaType(mutate.MediaType(img, types.OCIManifestSchema1), types.OCIConfigJSON),
+			Descriptor: v1.Descriptor{MediaType: types.OCIManifestSchema1, Platform: &p},
+		})
+	}
+	ref, err := name.NewTag(host + "/base:" + tag)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(remote.WriteIndex(ref, idx)).To(Succeed())
+	digest, err := idx.Digest()
+	Expect(err).NotTo(HaveOccurred())
+	return ref.String() + "@" + digest.String()
+}
+
+// pushBuilt pushes what contain would build on base: a layer, env,
+// entrypoint and workdir, with the base annotations.
+func pushBuilt(t *testing.T, host string, base string) name.Reference {
+	t.Helper()
+	baseRef, err := name.NewDigest(base)
+	Expect(err).NotTo(HaveOccurred())
+	idx := v1.ImageIndex(empty.Index)
+	for _, p := range platforms {
+		img, err := remote.Image(baseRef, remote.WithPlatform(p))
+		Expect(err).NotTo(HaveOccurred())
+		layer, err := random.Layer(64, types.OCILayer)
+		Expect(err).NotTo(HaveOccurred())
+		img, err = mutate.AppendLayers(img, layer)
+		Expect(err).NotTo(HaveOccurred())
+		cfg, err := img.ConfigFile()
+		Expect(err).NotTo(HaveOccurred())
+		cfg = cfg.DeepCopy()
+		cfg.Config.Env = append(cfg.Config.Env, "APP_MODE=production")
+		cfg.Config.Entrypoint = []string{"/app/run"}
+		cfg.Config.WorkingDir = "/app"
+		img, err = mutate.ConfigFile(img, cfg)
+		Expect(err).NotTo(HaveOccurred())
+		img = mutate.Annotations(img, map[string]string{
+			specsv1.AnnotationBaseImageDigest: baseRef.DigestStr(),
+			specsv1.AnnotationBaseImageName:   strings.Split(base, "@")[0],
+		}).(v1.Image)
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{MediaType: types.OCIManifestSchema1, Platform: &p},
+		})
+	}
+	ref, err := name.NewTag(host + "/app:built")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(remote.WriteIndex(ref, idx)).To(Succeed())
+	return ref
+}
+
+func TestRebase(t *testing.T) {
+	RegisterTestingT(t)
+	host := testRegistry(t)
+	built := pushBuilt(t, host, pushBase(t, host, "old"))
+	newBase := pushBase(t, host, "new")
+
+	core, logs := observer.New(zap.InfoLevel)
+	out, err := rebase.Rebase(rebase.Options{
+		Image:   built.String(),
+		Base:    newBase,
+		Tag:     host + "/app:rebased",
+		Push:    true,
+		Context: context.Background(),
+		Logger:  zap.New(core),
+	})
+	Expect(err).NotTo(HaveOccurred())
+	rebased := out.Artifact()
+	Expect(rebased.Platforms).To(Equal(platforms))
+	Expect(logs.FilterMessage("lifted").Len()).To(Equal(2))
+	Expect(logs.FilterMessage("rebasing").Len()).To(Equal(2))
+
+	newBaseRef, err := name.NewDigest(newBase)
+	Expect(err).NotTo(HaveOccurred())
+	for _, p := range platforms {
+		before, err := remote.Image(built, remote.WithPlatform(p))
+		Expect(err).NotTo(HaveOccurred())
+		after, err := remote.Image(rebased.Reference(), remote.WithPlatform(p))
+		Expect(err).NotTo(HaveOccurred())
+		base, err := remote.Image(newBaseRef, remote.WithPlatform(p))
+		Expect(err).NotTo(HaveOccurred())
+
+		beforeM, err := before.Manifest()
+		Expect(err).NotTo(HaveOccurred())
+		afterM, err := after.Manifest()
+		Expect(err).NotTo(HaveOccurred())
+		baseM, err := base.Manifest()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(afterM.Layers).To(HaveLen(len(baseM.Layers) + 1))
+		Expect(afterM.Layers[0].Digest).To(Equal(baseM.Layers[0].Digest), "base layers are the new base's")
+		Expect(afterM.Layers[len(afterM.Layers)-1].Digest).To(Equal(beforeM.Layers[len(beforeM.Layers)-1].Digest), "the appended layer is lifted as is")
+		Expect(afterM.Annotations[specsv1.AnnotationBaseImageDigest]).To(Equal(newBaseRef.DigestStr()))
+
+		afterCfg, err := after.ConfigFile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(afterCfg.Config.Env).To(ContainElement("APP_MODE=production"))
+		Expect(afterCfg.Config.Entrypoint).To(Equal([]string{"/app/run"}))
+		Expect(afterCfg.Config.WorkingDir).To(Equal("/app"))
+	}
+}
+
+func TestRebase_NotOnOldBase(t *testing.T) {
+	RegisterTestingT(t)
+	host := testRegistry(t)
+	built := pushBuilt(t, host, pushBase(t, host, "old"))
+
+	_, err := rebase.Rebase(rebase.Options{
+		Image:   built.String(),
+		Base:    pushBase(t, host, "new"),
+		Tag:     host + "/app:rebased",
+		OldBase: pushBase(t, host, "other"),
+		Logger:  zap.NewNop(),
+	})
+	Expect(err).To(HaveOccurred())
+	Expect(err.Error()).To(ContainSubstring("does not match annotation"))
+}
+
+func TestRebase_Context(t *testing.T) {
+	RegisterTestingT(t)
+	host := testRegistry(t)
+	built := pushBuilt(t, host, pushBase(t, host, "old"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := rebase.Rebase(rebase.Options{
+		Image:   built.String(),
+		Base:    pushBase(t, host, "new"),
+		Push:    true,
+		Context: ctx,
+		Logger:  zap.NewNop(),
+	})
+	Expect(err).To(MatchError(ContainSubstring("context canceled")))
+}
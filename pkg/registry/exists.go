@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Existing is what a push destination has of a result manifest
+type Existing int
+
+const (
+	// Missing means the manifest must be pushed, with its blobs
+	Missing Existing = iota
+	// Untagged means the manifest exists in the repository, so only the tag
+	// needs to be put
+	Untagged
+	// Tagged means the tag already points to the manifest
+	Tagged
+)
+
+// ExistingPush checks by digest if tag's repository already has the manifest,
+// and if so whether tag points to it. Errors other than not found are
+// returned, for the caller to fall back to a regular push.
+func ExistingPush(tag name.Reference, digest v1.Hash, config *RegistryConfig) (Existing, error) {
+	_, err := remote.Head(tag.Context().Digest(digest.String()), config.CraneOptions.Remote...)
+	if isNotFound(err) {
+		return Missing, nil
+	}
+	if err != nil {
+		return Missing, err
+	}
+	current, err := remote.Head(tag, config.CraneOptions.Remote...)
+	if isNotFound(err) {
+		return Untagged, nil
+	}
+	if err != nil {
+		return Untagged, err
+	}
+	if current.Digest != digest {
+		return Untagged, nil
+	}
+	return Tagged, nil
+}
+
+// CheckImmutable fails with an immutable.ChangedError if tag is one of
+// config's ImmutableTags and exists with another digest than digest
+func CheckImmutable(tag name.Reference, digest v1.Hash, config *RegistryConfig) error {
+	t, ok := tag.(name.Tag)
+	if !ok || !config.ImmutableTags.Match(t.TagStr()) {
+		return nil
+	}
+	current, err := remote.Head(tag, config.CraneOptions.Remote...)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("immutable tag %s: %w", tag, err)
+	}
+	return config.ImmutableTags.Check(tag.String(), t.TagStr(), current.Digest.String(), digest.String())
+}
+
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.uber.org/zap"
+)
+
+// digestPath matches the pulls a mirror may serve, those that name a digest
+var digestPath = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs)/(sha256:[a-f0-9]{64})$`)
+
+// parseMirror accepts host[:port][/path] with an optional scheme, https by
+// default.
+func parseMirror(mirror string) (*url.URL, error) {
+	if !strings.Contains(mirror, "://") {
+		mirror = "https://" + mirror
+	}
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("expected host[:port][/path], got %s", mirror)
+	}
+	u.Path = strings.Trim(u.Path, "/")
+	return u, nil
+}
+
+// fromMirror tries req's mirrors, in order, and returns nil if none served
+// it. The registry's credentials are not sent to a mirror. A manifest is
+// read and verified against its digest, so a mirror that has something else
+// is skipped. Blobs stream as they are, go-containerregistry verifies them
+// while reading and fails the pull on a mismatch. A redirect is returned
+// with its Location resolved against the mirror.
+func (t *hostTransport) fromMirror(req *http.Request, mirrors []*url.URL) *http.Response {
+	m := digestPath.FindStringSubmatch(req.URL.Path)
+	if req.Method != http.MethodGet || m == nil {
+		return nil
+	}
+	repo, kind, digest := m[1], m[2], m[3]
+	for _, mirror := range mirrors {
+		mreq := req.Clone(req.Context())
+		mreq.Host = ""
+		mreq.Header.Del("Authorization")
+		mreq.URL.Scheme = mirror.Scheme
+		mreq.URL.Host = mirror.Host
+		mreq.URL.Path = "/" + path.Join("v2", mirror.Path, repo, kind, digest)
+		mreq.URL.RawPath = ""
+		log := t.log().With(zap.String("mirror", mirror.Host), zap.String("url", mreq.URL.String()))
+		resp, err := t.direct(mreq)
+		if err != nil {
+			log.Warn("mirror failed", zap.Error(err))
+			continue
+		}
+		if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+			// the client resolves a relative Location against req's URL
+			if loc := resp.Header.Get("Location"); loc != "" {
+				if u, err := mreq.URL.Parse(loc); err == nil {
+					resp.Header.Set("Location", u.String())
+				}
+			}
+			log.Debug("mirror redirect", zap.Int("status", resp.StatusCode), zap.String("location", resp.Header.Get("Location")))
+			return resp
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Debug("mirror miss", zap.Int("status", resp.StatusCode))
+			io.Copy(io.Discard, resp.Body) //nolint:errcheck
+			resp.Body.Close()
+			continue
+		}
+		if kind == "manifests" {
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				log.Warn("mirror failed", zap.Error(err))
+				continue
+			}
+			got, _, err := v1.SHA256(bytes.NewReader(body))
+			if err != nil || got.String() != digest {
+				log.Warn("mirror manifest digest mismatch", zap.String("expected", digest), zap.String("got", got.String()))
+				continue
+			}
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			resp.ContentLength = int64(len(body))
+		}
+		log.Debug("mirror hit", zap.String("kind", kind))
+		return resp
+	}
+	return nil
+}
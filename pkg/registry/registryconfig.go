@@ -1,25 +1,127 @@
 package registry
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
 	"regexp"
+	"sort"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/invopop/yaml"
+	"github.com/turbokube/contain/pkg/immutable"
+	"github.com/turbokube/contain/pkg/ocipush"
 	schema "github.com/turbokube/contain/pkg/schema/v1"
 	"go.uber.org/zap"
 )
 
+// SettingsFileEnv names a YAML file with a registries: section like the
+// config's, for settings shared by every config on a machine or CI runner
+const SettingsFileEnv = "CONTAIN_REGISTRIES_CONFIG"
+
 var (
 	insecureAccessRefs = regexp.MustCompile(`^((localhost|127\.0\.0\.1)(:\d+)?|[^/]+\.local)/`)
 )
 
 type RegistryConfig struct {
 	CraneOptions crane.Options
+	// ImmutableTags are the config's for push, cleared to force
+	ImmutableTags immutable.Tags
+	// ctx and log are the caller's, see WithContext and WithLogger
+	ctx context.Context
+	log *zap.Logger
+}
+
+// WithContext makes requests that use c honor ctx, those through
+// CraneOptions.Remote and those of clients that ask for Context
+func (c *RegistryConfig) WithContext(ctx context.Context) {
+	c.ctx = ctx
+	c.CraneOptions.Remote = append(c.CraneOptions.Remote, remote.WithContext(ctx))
+}
+
+// Context is the one from WithContext, or context.Background
+func (c *RegistryConfig) Context() context.Context {
+	if c == nil || c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// WithLogger sets the logger for work that uses c
+func (c *RegistryConfig) WithLogger(log *zap.Logger) {
+	c.log = log
 }
 
+// Logger is the one from WithLogger, or zap.L()
+func (c *RegistryConfig) Logger() *zap.Logger {
+	if c == nil || c.log == nil {
+		return zap.L()
+	}
+	return c.log
+}
+
+// UploadOptions are upload with c's credentials, transport and immutable
+// tags, for pushes through ocipush. Logger defaults to c's.
+func (c *RegistryConfig) UploadOptions(upload ocipush.Options) ocipush.Options {
+	upload.Keychain = c.CraneOptions.Keychain
+	upload.Transport = c.CraneOptions.Transport
+	upload.ImmutableTags = c.ImmutableTags
+	if upload.Logger == nil {
+		upload.Logger = c.Logger()
+	}
+	return upload
+}
+
+// New returns the config for pulling the config's bases
 func New(config schema.ContainConfig) (*RegistryConfig, error) {
-	c := &RegistryConfig{}
+	return NewWithLogger(config, zap.L())
+}
+
+// NewWithLogger is New with log, for the config and as if WithLogger(log)
+func NewWithLogger(config schema.ContainConfig, log *zap.Logger) (*RegistryConfig, error) {
+	return newFor("base", config.Bases(), config.Registries, true, log)
+}
+
+// NewPush returns the config for pushing to the config's tag and additional
+// tags. Tags in localhost or *.local registries are pushed anonymously, and
+// the others with credentials, regardless of where the base is.
+func NewPush(config schema.ContainConfig) (*RegistryConfig, error) {
+	return NewPushWithLogger(config, zap.L())
+}
+
+// NewPushWithLogger is NewPush with log, for the config and as if
+// WithLogger(log)
+func NewPushWithLogger(config schema.ContainConfig, log *zap.Logger) (*RegistryConfig, error) {
+	refs := append([]string{config.Tag}, config.AdditionalTags...)
+	immutableTags := immutable.Tags(config.ImmutableTags)
+	if err := immutableTags.Validate(); err != nil {
+		return nil, err
+	}
+	c, err := newFor("tag", refs, config.Registries, false, log)
+	if err != nil {
+		return nil, err
+	}
+	c.ImmutableTags = immutableTags
+	return c, nil
+}
+
+// newFor returns the config for refs, with mirrors only if pull is true.
+// Registries of refs that match insecureAccessRefs and have no settings are
+// accessed anonymously without TLS verification, each by its host, so that
+// a local ref doesn't affect access to the others.
+func newFor(role string, refs []string, configured map[string]schema.RegistrySettings, pull bool, log *zap.Logger) (*RegistryConfig, error) {
+	settings, err := loadSettings(configured)
+	if err != nil {
+		return nil, err
+	}
+	c := &RegistryConfig{log: log}
 	// https://github.com/google/go-containerregistry/blob/v0.13.0/pkg/crane/options.go#L43
 	c.CraneOptions = crane.Options{
 		Remote: []remote.Option{
@@ -28,11 +130,201 @@ func New(config schema.ContainConfig) (*RegistryConfig, error) {
 		Keychain: authn.DefaultKeychain,
 	}
 
-	if insecureAccessRefs.Match([]byte(config.Base)) {
-		zap.L().Debug("insecure access enabled", zap.String("base", config.Base))
-		c.CraneOptions.Remote = []remote.Option{remote.WithAuth(authn.Anonymous)}
+	insecure := make(map[string]bool)
+	local := 0
+	for _, ref := range refs {
+		if !insecureAccessRefs.Match([]byte(ref)) {
+			continue
+		}
+		r, err := name.ParseReference(ref)
+		if err != nil {
+			continue
+		}
+		host := r.Context().RegistryStr()
+		if _, ok := settings[host]; ok && !insecure[host] {
+			continue
+		}
+		log.Debug("insecure access enabled", zap.String(role, ref))
+		insecure[host] = true
+		local++
+	}
+	for host := range insecure {
+		settings[host] = schema.RegistrySettings{Anonymous: true, Insecure: true}
+	}
+	// names, which are for all registries, may use http only if every ref is local
+	if local > 0 && local == len(refs) {
 		crane.Insecure(&c.CraneOptions)
 	}
 
+	if len(settings) > 0 {
+		transport, err := newHostTransport(settings, pull, log)
+		if err != nil {
+			return nil, err
+		}
+		transport.log = c.Logger
+		keychain := hostKeychain{settings: settings, fallback: authn.DefaultKeychain}
+		c.CraneOptions.Keychain = keychain
+		c.CraneOptions.Transport = transport
+		c.CraneOptions.Remote = []remote.Option{
+			remote.WithAuthFromKeychain(keychain),
+			remote.WithTransport(transport),
+		}
+	}
+
+	return c, nil
+}
+
+// loadSettings reads the SettingsFileEnv file, if any, with configured
+// entries replacing the file's for the same registry. Keys are normalized,
+// so that docker.io and index.docker.io are the same registry.
+func loadSettings(configured map[string]schema.RegistrySettings) (map[string]schema.RegistrySettings, error) {
+	settings := make(map[string]schema.RegistrySettings)
+	if path := os.Getenv(SettingsFileEnv); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", SettingsFileEnv, err)
+		}
+		var file struct {
+			Registries map[string]schema.RegistrySettings `json:"registries"`
+		}
+		if err := yaml.Unmarshal(content, &file); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", SettingsFileEnv, path, err)
+		}
+		if err := addSettings(settings, file.Registries); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", SettingsFileEnv, path, err)
+		}
+	}
+	if err := addSettings(settings, configured); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func addSettings(to map[string]schema.RegistrySettings, from map[string]schema.RegistrySettings) error {
+	keys := make([]string, 0, len(from))
+	for k := range from {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		r, err := name.NewRegistry(k)
+		if err != nil {
+			return fmt.Errorf("registries: %w", err)
+		}
+		to[r.RegistryStr()] = from[k]
+	}
+	return nil
+}
+
+// hostKeychain is anonymous for the registries whose settings say so
+type hostKeychain struct {
+	settings map[string]schema.RegistrySettings
+	fallback authn.Keychain
+}
+
+func (k hostKeychain) Resolve(r authn.Resource) (authn.Authenticator, error) {
+	if k.settings[r.RegistryStr()].Anonymous {
+		return authn.Anonymous, nil
+	}
+	return k.fallback.Resolve(r)
+}
+
+// hostTransport applies settings by request host. Registries that are
+// plain HTTP get their requests downgraded from https, which is also how
+// go-containerregistry's ping learns to use http for the rest.
+type hostTransport struct {
+	settings   map[string]schema.RegistrySettings
+	transports map[string]http.RoundTripper
+	fallback   http.RoundTripper
+	// mirrors are by registry host, for pulls
+	mirrors map[string][]*url.URL
+	// log is the RegistryConfig's Logger, for mirror requests
+	log func() *zap.Logger
+}
+
+func newHostTransport(settings map[string]schema.RegistrySettings, pull bool, log *zap.Logger) (*hostTransport, error) {
+	t := &hostTransport{
+		settings:   settings,
+		transports: make(map[string]http.RoundTripper),
+		fallback:   remote.DefaultTransport,
+		mirrors:    make(map[string][]*url.URL),
+		log:        func() *zap.Logger { return log },
+	}
+	for host, s := range settings {
+		if !pull {
+			break
+		}
+		for _, m := range s.Mirrors {
+			mirror, err := parseMirror(m)
+			if err != nil {
+				return nil, fmt.Errorf("registries %s mirrors: %w", host, err)
+			}
+			t.mirrors[host] = append(t.mirrors[host], mirror)
+		}
+	}
+	for host, s := range settings {
+		if !s.Insecure && s.CA == "" && s.ClientCert == "" && s.ClientKey == "" {
+			continue
+		}
+		tlsConfig, err := newTLSConfig(s, log)
+		if err != nil {
+			return nil, fmt.Errorf("registries %s: %w", host, err)
+		}
+		transport := remote.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		t.transports[host] = transport
+	}
+	return t, nil
+}
+
+func newTLSConfig(s schema.RegistrySettings, log *zap.Logger) (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: s.Insecure} //nolint:gosec // opt-in per registry
+	if s.CA != "" {
+		pem, err := os.ReadFile(s.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			log.Warn("system cert pool", zap.Error(err))
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in ca %s", s.CA)
+		}
+		c.RootCAs = pool
+	}
+	if s.ClientCert != "" || s.ClientKey != "" {
+		if s.ClientCert == "" || s.ClientKey == "" {
+			return nil, fmt.Errorf("clientCert and clientKey are required together")
+		}
+		cert, err := tls.LoadX509KeyPair(s.ClientCert, s.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
 	return c, nil
 }
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if mirrors := t.mirrors[req.URL.Host]; len(mirrors) > 0 {
+		if resp := t.fromMirror(req, mirrors); resp != nil {
+			return resp, nil
+		}
+	}
+	return t.direct(req)
+}
+
+// direct sends req to its host, with that host's settings
+func (t *hostTransport) direct(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if t.settings[host].PlainHTTP && req.URL.Scheme == "https" {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+	}
+	if transport, ok := t.transports[host]; ok {
+		return transport.RoundTrip(req)
+	}
+	return t.fallback.RoundTrip(req)
+}
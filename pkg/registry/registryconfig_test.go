@@ -1,12 +1,24 @@
 package registry_test
 
 import (
+	"crypto/sha256"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
 	. "github.com/onsi/gomega"
 	"github.com/turbokube/contain/pkg/registry"
 	schema "github.com/turbokube/contain/pkg/schema/v1"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestLocal(t *testing.T) {
@@ -24,3 +36,247 @@ func TestLocal(t *testing.T) {
 	Expect(fmt.Sprintf("%v", c2)).To(ContainSubstring("false 0 false"))
 
 }
+
+func TestNewWithLogger(t *testing.T) {
+	RegisterTestingT(t)
+	core, logs := observer.New(zap.DebugLevel)
+	c, err := registry.NewWithLogger(schema.ContainConfig{Base: "localhost:5000/my/img"}, zap.New(core))
+	Expect(err).To(BeNil())
+	Expect(logs.FilterMessage("insecure access enabled").Len()).To(Equal(1))
+	Expect(c.Logger().Core()).To(Equal(core))
+}
+
+func TestPushSeparateFromPull(t *testing.T) {
+	RegisterTestingT(t)
+
+	config := schema.ContainConfig{
+		Base: "registry.example.net/my/img",
+		Tag:  "localhost:5000/my/result",
+	}
+	pull, err := registry.New(config)
+	Expect(err).To(BeNil())
+	Expect(fmt.Sprintf("%v", pull)).To(ContainSubstring("false 0 false"))
+	push, err := registry.NewPush(config)
+	Expect(err).To(BeNil())
+	Expect(fmt.Sprintf("%v", push)).To(ContainSubstring("true 0 false"))
+}
+
+func TestLocalPerRegistry(t *testing.T) {
+	RegisterTestingT(t)
+	dockerConfig := t.TempDir()
+	Expect(os.WriteFile(filepath.Join(dockerConfig, "config.json"),
+		[]byte(`{"auths":{"ghcr.io":{"auth":"dXNlcjpwYXNz"}}}`), 0o600)).To(Succeed())
+	t.Setenv("DOCKER_CONFIG", dockerConfig)
+
+	push, err := registry.NewPush(schema.ContainConfig{
+		Base:           "registry.example.net/my/img",
+		Tag:            "localhost:5000/app",
+		AdditionalTags: []string{"ghcr.io/org/app:v1"},
+	})
+	Expect(err).To(BeNil())
+	Expect(fmt.Sprintf("%v", push)).To(ContainSubstring("false 0 false"))
+	auth, err := push.CraneOptions.Keychain.Resolve(mustRegistry("localhost:5000"))
+	Expect(err).To(BeNil())
+	Expect(auth).To(Equal(authn.Anonymous))
+	auth, err = push.CraneOptions.Keychain.Resolve(mustRegistry("ghcr.io"))
+	Expect(err).To(BeNil())
+	Expect(auth).NotTo(Equal(authn.Anonymous))
+
+	pull, err := registry.New(schema.ContainConfig{
+		Base:            "ghcr.io/org/base",
+		BasePerPlatform: map[string]string{"linux/arm64": "localhost:5000/base-arm64"},
+	})
+	Expect(err).To(BeNil())
+	auth, err = pull.CraneOptions.Keychain.Resolve(mustRegistry("ghcr.io"))
+	Expect(err).To(BeNil())
+	Expect(auth).NotTo(Equal(authn.Anonymous))
+	auth, err = pull.CraneOptions.Keychain.Resolve(mustRegistry("localhost:5000"))
+	Expect(err).To(BeNil())
+	Expect(auth).To(Equal(authn.Anonymous))
+}
+
+func get(t *testing.T, c *registry.RegistryConfig, url string) (*http.Response, error) {
+	t.Helper()
+	return (&http.Client{Transport: c.CraneOptions.Transport}).Get(url)
+}
+
+func TestSettings_PlainHTTP(t *testing.T) {
+	RegisterTestingT(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	c, err := registry.New(schema.ContainConfig{
+		Base:       host + "/my/img",
+		Registries: map[string]schema.RegistrySettings{host: {PlainHTTP: true, Anonymous: true}},
+	})
+	Expect(err).To(BeNil())
+	resp, err := get(t, c, "https://"+host+"/v2/")
+	Expect(err).To(BeNil())
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	Expect(resp.Request.URL.Scheme).To(Equal("http"))
+
+	auth, err := c.CraneOptions.Keychain.Resolve(mustRegistry(host))
+	Expect(err).To(BeNil())
+	Expect(auth).To(Equal(authn.Anonymous))
+}
+
+func TestSettings_CA(t *testing.T) {
+	RegisterTestingT(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	Expect(os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644)).To(Succeed())
+
+	// from the file, which the config overrides per registry
+	settingsFile := filepath.Join(t.TempDir(), "registries.yaml")
+	Expect(os.WriteFile(settingsFile, []byte("registries:\n  "+host+":\n    ca: "+ca+"\n"), 0o644)).To(Succeed())
+	t.Setenv(registry.SettingsFileEnv, settingsFile)
+	c, err := registry.NewPush(schema.ContainConfig{Tag: host + "/my/result"})
+	Expect(err).To(BeNil())
+	resp, err := get(t, c, server.URL+"/v2/")
+	Expect(err).To(BeNil())
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	c, err = registry.NewPush(schema.ContainConfig{
+		Tag:        host + "/my/result",
+		Registries: map[string]schema.RegistrySettings{host: {Anonymous: true}},
+	})
+	Expect(err).To(BeNil())
+	_, err = get(t, c, server.URL+"/v2/")
+	Expect(err).NotTo(BeNil(), "the config entry replaces the file's, without the ca")
+
+	_, err = registry.NewPush(schema.ContainConfig{
+		Tag:        host + "/my/result",
+		Registries: map[string]schema.RegistrySettings{host: {ClientCert: ca}},
+	})
+	Expect(err).To(MatchError(ContainSubstring("clientCert and clientKey are required together")))
+}
+
+func mustRegistry(host string) name.Registry {
+	r, err := name.NewRegistry(host)
+	Expect(err).To(BeNil())
+	return r
+}
+
+func TestSettings_Mirrors(t *testing.T) {
+	RegisterTestingT(t)
+	manifest := []byte(`{"schemaVersion":2}`)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	canonicalHits := 0
+	canonical := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		canonicalHits++
+		w.Write(manifest) //nolint:errcheck
+	}))
+	defer canonical.Close()
+	host := strings.TrimPrefix(canonical.URL, "http://")
+	var mirrorPath, mirrorAuth string
+	serve := manifest
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorPath, mirrorAuth = r.URL.Path, r.Header.Get("Authorization")
+		w.Write(serve) //nolint:errcheck
+	}))
+	defer mirror.Close()
+
+	config := schema.ContainConfig{
+		Base: host + "/my/img@" + digest,
+		Tag:  host + "/my/result",
+		Registries: map[string]schema.RegistrySettings{host: {
+			PlainHTTP: true,
+			Mirrors:   []string{"http://" + strings.TrimPrefix(mirror.URL, "http://") + "/proxy/"},
+		}},
+	}
+	c, err := registry.New(config)
+	Expect(err).To(BeNil())
+	req, err := http.NewRequest(http.MethodGet, "https://"+host+"/v2/my/img/manifests/"+digest, nil)
+	Expect(err).To(BeNil())
+	req.Header.Set("Authorization", "Bearer canonical")
+	resp, err := c.CraneOptions.Transport.RoundTrip(req)
+	Expect(err).To(BeNil())
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	Expect(mirrorPath).To(Equal("/v2/proxy/my/img/manifests/" + digest))
+	Expect(mirrorAuth).To(BeEmpty(), "credentials are for the registry itself")
+	Expect(canonicalHits).To(Equal(0))
+
+	// tags are resolved by the registry
+	resp, err = get(t, c, "https://"+host+"/v2/my/img/manifests/latest")
+	Expect(err).To(BeNil())
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	Expect(canonicalHits).To(Equal(1))
+
+	// a mirror can't substitute content
+	serve = []byte(`{"schemaVersion":2,"substituted":true}`)
+	resp, err = get(t, c, "https://"+host+"/v2/my/img/manifests/"+digest)
+	Expect(err).To(BeNil())
+	body, err := io.ReadAll(resp.Body)
+	Expect(err).To(BeNil())
+	Expect(body).To(Equal(manifest))
+	Expect(canonicalHits).To(Equal(2))
+
+	// pushes don't use mirrors
+	push, err := registry.NewPush(config)
+	Expect(err).To(BeNil())
+	serve = manifest
+	_, err = get(t, push, "https://"+host+"/v2/my/img/manifests/"+digest)
+	Expect(err).To(BeNil())
+	Expect(canonicalHits).To(Equal(3))
+
+	// mirror warnings go to the config's logger
+	core, logs := observer.New(zap.InfoLevel)
+	c.WithLogger(zap.New(core))
+	serve = []byte(`{"schemaVersion":2,"substituted":true}`)
+	_, err = get(t, c, "https://"+host+"/v2/my/img/manifests/"+digest)
+	Expect(err).To(BeNil())
+	Expect(logs.FilterMessage("mirror manifest digest mismatch").Len()).To(Equal(1))
+
+	config.Registries[host] = schema.RegistrySettings{Mirrors: []string{"mirror.example.net?x=y"}}
+	_, err = registry.New(config)
+	Expect(err).To(MatchError(ContainSubstring("mirrors: expected host[:port][/path]")))
+}
+
+func TestSettings_MirrorRedirect(t *testing.T) {
+	RegisterTestingT(t)
+	blob := []byte("layer")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+	canonicalHits := 0
+	canonical := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		canonicalHits++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer canonical.Close()
+	host := strings.TrimPrefix(canonical.URL, "http://")
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/storage/"+digest {
+			w.Write(blob) //nolint:errcheck
+			return
+		}
+		// no host, as registries that serve blobs from another path do
+		w.Header().Set("Location", "/storage/"+digest)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	defer mirror.Close()
+
+	c, err := registry.New(schema.ContainConfig{
+		Base: host + "/my/img@" + digest,
+		Registries: map[string]schema.RegistrySettings{host: {
+			PlainHTTP: true,
+			Mirrors:   []string{mirror.URL + "/proxy"},
+		}},
+	})
+	Expect(err).To(BeNil())
+	req, err := http.NewRequest(http.MethodGet, "https://"+host+"/v2/my/img/blobs/"+digest, nil)
+	Expect(err).To(BeNil())
+	resp, err := c.CraneOptions.Transport.RoundTrip(req)
+	Expect(err).To(BeNil())
+	Expect(resp.StatusCode).To(Equal(http.StatusTemporaryRedirect))
+	Expect(resp.Header.Get("Location")).To(Equal(mirror.URL + "/storage/" + digest))
+	resp.Body.Close()
+
+	resp, err = get(t, c, "https://"+host+"/v2/my/img/blobs/"+digest)
+	Expect(err).To(BeNil())
+	body, err := io.ReadAll(resp.Body)
+	Expect(err).To(BeNil())
+	Expect(body).To(Equal(blob))
+	Expect(canonicalHits).To(Equal(0))
+}
@@ -49,14 +49,10 @@ func TemplateApp(base string) v1.ContainConfig {
 }
 
 func TemplateSync(runNamespace string, runSelector string) v1.ContainConfigSync {
-	defaultWait, err := time.ParseDuration("3s")
-	if err != nil {
-		zap.L().Fatal("parse default duration", zap.Error(err))
-	}
 	return v1.ContainConfigSync{
 		Namespace:       runNamespace,
 		PodSelector:     runSelector,
 		GetAttemptsMax:  20,
-		GetAttemptsWait: defaultWait,
+		GetAttemptsWait: 3 * time.Second,
 	}
 }
@@ -0,0 +1,51 @@
+package v1
+
+import (
+	"sort"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/turbokube/contain/pkg/platform"
+)
+
+// ResolveBase returns the base reference for this platform: the
+// BasePerPlatform entry whose key denotes the same platform once normalized,
+// or Base. Unlike ResolveLocalFilePath there is no fallback from a variant to
+// its architecture, because a base decides which child is published.
+func ResolveBase(config ContainConfig, p v1.Platform) string {
+	for _, k := range BasePerPlatformKeys(config) {
+		kp, err := v1.ParsePlatform(k)
+		if err != nil {
+			continue
+		}
+		if platform.Equal(*kp, p) && config.BasePerPlatform[k] != "" {
+			return config.BasePerPlatform[k]
+		}
+	}
+	return config.Base
+}
+
+// BasePerPlatformKeys returns the keys of BasePerPlatform sorted, so that
+// two keys normalizing to the same platform resolve the same way every run.
+func BasePerPlatformKeys(config ContainConfig) []string {
+	keys := make([]string, 0, len(config.BasePerPlatform))
+	for k := range config.BasePerPlatform {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Bases returns Base, if set, followed by the BasePerPlatform references in
+// key order.
+func (c ContainConfig) Bases() []string {
+	var bases []string
+	if c.Base != "" {
+		bases = append(bases, c.Base)
+	}
+	for _, k := range BasePerPlatformKeys(c) {
+		if c.BasePerPlatform[k] != "" {
+			bases = append(bases, c.BasePerPlatform[k])
+		}
+	}
+	return bases
+}
@@ -0,0 +1,31 @@
+package v1
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// validateCommand is ValidateLayers for a command layer.
+func validateCommand(i int, c Command) []string {
+	var errs []string
+	switch c.Output {
+	case "", CommandOutputDir, CommandOutputTar:
+	default:
+		errs = append(errs, fmt.Sprintf("layers[%d].command: output must be %s or %s, got %q", i, CommandOutputDir, CommandOutputTar, c.Output))
+	}
+	if c.ContainerPath != "" && (!strings.HasPrefix(c.ContainerPath, "/") || strings.HasSuffix(c.ContainerPath, "/")) {
+		errs = append(errs, fmt.Sprintf("layers[%d].command: containerPath must be an absolute path without trailing slash, got %q", i, c.ContainerPath))
+	}
+	if c.Timeout != "" {
+		if d, err := time.ParseDuration(c.Timeout); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("layers[%d].command: timeout must be a positive duration such as 90s, got %q", i, c.Timeout))
+		}
+	}
+	for j, e := range c.Env {
+		if e.Name == "" || strings.Contains(e.Name, "=") {
+			errs = append(errs, fmt.Sprintf("layers[%d].command.env[%d]: invalid name %q", i, j, e.Name))
+		}
+	}
+	return errs
+}
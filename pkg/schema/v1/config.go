@@ -1,22 +1,62 @@
 package v1
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type ContainConfig struct {
 	Status ContainConfigStatus `json:"-"`
 	// Base is the base image reference
 	Base string `json:"base,omitempty" skaffold:"template"`
+	// BasePerPlatform is a base for the listed "<os>/<arch>[/<variant>]"
+	// platforms, for example a vendor's arm64 image. Each contributes the
+	// child for its platform, and Base, if set, every other platform.
+	BasePerPlatform map[string]string `json:"basePerPlatform,omitempty"`
 	// Tag is the result reference to be pushed
-	Tag        string            `json:"tag,omitempty" skaffold:"template"`
-	Platforms  []string          `json:"platforms,omitempty"`
-	Layers     []Layer           `json:"layers,omitempty"`
-	Env        []Env             `json:"env,omitempty"`
-	WorkingDir string            `json:"workingDir,omitempty" skaffold:"template"`
-	Entrypoint []string          `json:"entrypoint,omitempty"`
-	Args       []string          `json:"args,omitempty"`
-	Sync       ContainConfigSync `json:"-"`
+	Tag string `json:"tag,omitempty" skaffold:"template"`
+	// AdditionalTags are more references the result is pushed to, in any
+	// repository or registry
+	AdditionalTags []string  `json:"additionalTags,omitempty" skaffold:"template"`
+	Platforms      Platforms `json:"platforms,omitempty"`
+	Layers         []Layer   `json:"layers,omitempty"`
+	Env            []Env     `json:"env,omitempty"`
+	WorkingDir     string    `json:"workingDir,omitempty" skaffold:"template"`
+	Entrypoint     []string  `json:"entrypoint,omitempty"`
+	Args           []string  `json:"args,omitempty"`
+	// MaxImageSize is a budget for each resulting image, base layers plus
+	// appended layers, compressed as pushed. Bytes or a Kubernetes quantity
+	// such as 500Mi.
+	MaxImageSize string `json:"maxImageSize,omitempty" skaffold:"template"`
+	// MediaTypes is required for a base with Docker v2 schema2 media types:
+	// "preserve" keeps them in the result, "oci" converts the result's
+	// manifests and index to OCI. An OCI base is not affected.
+	MediaTypes string `json:"mediaTypes,omitempty"`
+	// WrapIndex pushes an index also when the result has one platform, as
+	// it always has from a single-manifest base
+	WrapIndex bool `json:"wrapIndex,omitempty"`
+	// ChildTag is a Go template for a tag that each child of a resulting
+	// index is also pushed to, in the same repository, for example
+	// "{{.Tag}}-{{.Arch}}". See multiarch.ChildTagData for the fields.
+	ChildTag string `json:"childTag,omitempty"`
+	// ImmutableTags are glob patterns, such as "v*", for tag names that
+	// must not be moved once they exist. A push that would point a matching
+	// tag to another digest fails, unless forced.
+	ImmutableTags []string `json:"immutableTags,omitempty"`
+	// Registries are settings per registry host, for example localhost:5000
+	// or registry.example.com, merged over those in the file that the
+	// CONTAIN_REGISTRIES_CONFIG env names
+	Registries map[string]RegistrySettings `json:"registries,omitempty"`
+	Sync       ContainConfigSync           `json:"-"`
 }
 
+const (
+	// MediaTypesPreserve keeps a Docker base's media types in the result
+	MediaTypesPreserve = "preserve"
+	// MediaTypesOCI converts the result to OCI media types
+	MediaTypesOCI = "oci"
+)
+
 type ContainConfigStatus struct {
 	Template  bool   // true if config is from a template
 	Md5       string // config source md5 (not for template)
@@ -26,6 +66,9 @@ type ContainConfigStatus struct {
 
 type ContainConfigOverrides struct {
 	Base bool
+	// AutoPlatforms, if set, narrows platforms: auto to these platforms,
+	// for example from the PLATFORMS env
+	AutoPlatforms []string
 }
 
 type Env struct {
@@ -42,9 +85,24 @@ type ContainConfigSync struct {
 
 type Layer struct {
 	Attributes LayerAttributes `json:"layerAttributes,omitempty"`
+	// Platforms limits the layer to the listed "<os>/<arch>[/<variant>]"
+	// platforms, for example an agent that only exists for linux/amd64.
+	// Children for other platforms are built without it. Empty means all.
+	Platforms []string `json:"platforms,omitempty"`
+	// MaxCompressedSize is a budget for the layer as pushed, in bytes or as
+	// a Kubernetes quantity such as 100Mi, checked for every platform.
+	MaxCompressedSize string `json:"maxCompressedSize,omitempty" skaffold:"template"`
 	// exactly one of the following
-	LocalDir  LocalDir  `json:"localDir,omitempty"`
-	LocalFile LocalFile `json:"localFile,omitempty"`
+	LocalDir  LocalDir      `json:"localDir,omitempty"`
+	LocalFile LocalFile     `json:"localFile,omitempty"`
+	Files     []FileMapping `json:"files,omitempty"`
+	GoBuild   GoBuild       `json:"goBuild,omitempty"`
+	JavaApp   JavaApp       `json:"javaApp,omitempty"`
+	NodeApp   NodeApp       `json:"nodeApp,omitempty"`
+	Command   Command       `json:"command,omitempty"`
+	// Extensions is the raw config of layer types that embedders register,
+	// by key, see layers.Register
+	Extensions map[string]json.RawMessage `json:"-"`
 }
 
 // LayerAttributes defines is generic and some layer types may ignore some of the fields.
@@ -81,6 +139,126 @@ type LocalFile struct {
 	MaxSize         string            `json:"maxSize,omitempty" skaffold:"template"`
 }
 
+// FileMapping is one entry of a files layer, which puts any number of loose
+// files into a single layer, for example ./target/runner to /app/runner and
+// ./config/*.yaml to /etc/app/.
+//
+// Src is a file or a glob. A glob, or a Dst with a trailing slash, makes Dst
+// a directory that receives each matched file under its own name; otherwise
+// Dst is the file's path in the container. PathPerPlatform works as it does
+// for LocalFile, with Src as the fallback.
+type FileMapping struct {
+	Src             string            `json:"src,omitempty" skaffold:"filepath,template"`
+	PathPerPlatform map[string]string `json:"pathPerPlatform,omitempty"`
+	Dst             string            `json:"dst" skaffold:"template"`
+	// Mode bits for the entry's files, overriding layerAttributes.mode.
+	// YAML accepts both octal and decimal values, JSON requires decimal values for mode bits.
+	Mode int32 `json:"mode,omitempty"`
+}
+
+// GoBuild compiles a Go main package with the local toolchain, once for each
+// platform being built, and appends the binary at ContainerPath. The build
+// runs in the context dir, or Dir, with GOOS, GOARCH and the variant's
+// GOARM, GOAMD64 or GOARM64 set from the platform, and always with
+// -ldflags=-buildid= so the same sources give the same layer.
+type GoBuild struct {
+	// Package is the main package to build, for example ./cmd/server
+	Package       string `json:"package" skaffold:"template"`
+	ContainerPath string `json:"containerPath" skaffold:"template"`
+	// Ldflags are passed to the linker, one flag with its value per item, for
+	// example -s or -X main.version=1.0. Values with whitespace are quoted.
+	Ldflags []string `json:"ldflags,omitempty" skaffold:"template"`
+	Tags    []string `json:"tags,omitempty"`
+	// Trimpath removes file system paths from the binary, default true.
+	Trimpath *bool `json:"trimpath,omitempty"`
+	// CgoEnabled sets CGO_ENABLED, default false so that binaries are static
+	// and cross-compilation needs no C toolchain.
+	CgoEnabled bool `json:"cgoEnabled,omitempty"`
+	// Dir is where go build runs, for a module in a subdirectory of the
+	// context dir. Package is relative to it.
+	Dir string `json:"dir,omitempty" skaffold:"filepath,template"`
+}
+
+// JavaApp is a Java application split into layers by how often they
+// change, like Jib does: release dependencies, snapshot dependencies,
+// resources and classes, in that order, so that a code change pushes only
+// the last layer and dependency layers stay cached in registries and on
+// nodes. Layers with nothing in them are left out.
+//
+// The application is either Jar, a fat jar that is unpacked, or Dir, an
+// exploded application: an unpacked Spring Boot jar (with BOOT-INF/), or a
+// Maven or Gradle build output dir with compiled classes (classes/ or
+// classes/java/main/ plus resources/main/) and dependency jars (dependency/,
+// as written by mvn dependency:copy-dependencies, or lib/).
+//
+// Files go to ContainerPath/libs, ContainerPath/resources and
+// ContainerPath/classes. With no entrypoint configured the image gets
+// java [jvmFlags] -cp <those three> <mainClass>.
+type JavaApp struct {
+	Jar string `json:"jar,omitempty" skaffold:"filepath,template"`
+	Dir string `json:"dir,omitempty" skaffold:"filepath,template"`
+	// ContainerPath defaults to /app
+	ContainerPath string `json:"containerPath,omitempty" skaffold:"template"`
+	// MainClass defaults to Start-Class or Main-Class from the manifest
+	MainClass string   `json:"mainClass,omitempty" skaffold:"template"`
+	JvmFlags  []string `json:"jvmFlags,omitempty" skaffold:"template"`
+}
+
+// NodeApp is a Node.js application with its production dependencies, taken
+// from an installed node_modules according to the lockfile in Dir,
+// package-lock.json or pnpm-lock.yaml. Nothing is installed or downloaded.
+// It produces three layers: dependencies, native dependencies and the app,
+// which is Dir without node_modules.
+//
+// Native dependencies are packages the lockfile restricts by os or cpu,
+// packages with install scripts, and packages with a binding.gyp. Those
+// that do not run on a platform are left out of its image, and those that
+// do are read from NodeModulesPerPlatform, resolved like
+// LocalFile.PathPerPlatform with NodeModules as the fallback, so that each
+// platform can get addons built for it.
+type NodeApp struct {
+	Dir string `json:"dir" skaffold:"filepath,template"`
+	// ContainerPath defaults to /app
+	ContainerPath string `json:"containerPath,omitempty" skaffold:"template"`
+	// Ignore patterns for app files, in addition to node_modules
+	Ignore []string `json:"ignore,omitempty" skaffold:"template"`
+	// NodeModules is relative to Dir and defaults to node_modules
+	NodeModules            string            `json:"nodeModules,omitempty" skaffold:"filepath,template"`
+	NodeModulesPerPlatform map[string]string `json:"nodeModulesPerPlatform,omitempty"`
+}
+
+// Command runs an executable once per platform, which writes the layer's
+// content to the path in CONTAIN_OUTPUT: a directory, or with Output tar a
+// tar file. Its environment also has CONTAIN_PLATFORM, such as
+// linux/arm64/v8, and CONTAIN_CONTEXT_DIR. Contain normalizes the content
+// like a localDir's, so a command that writes the same files gives the same
+// layer regardless of timestamps, owners and order. A non-zero exit or the
+// timeout fails the build.
+type Command struct {
+	// Path is the executable, relative to Dir if it has a slash, otherwise
+	// looked up in PATH. It does not run in a shell.
+	Path string   `json:"path" skaffold:"template"`
+	Args []string `json:"args,omitempty" skaffold:"template"`
+	// Dir is where the command runs, relative to the context dir, default
+	// the context dir. CONTAIN_CONTEXT_DIR is the context dir either way.
+	Dir string `json:"dir,omitempty" skaffold:"filepath,template"`
+	// Env is added to contain's environment
+	Env []Env `json:"env,omitempty"`
+	// Output is dir, the default, or tar
+	Output string `json:"output,omitempty"`
+	// ContainerPath is a prefix for the output's paths, default none
+	ContainerPath string `json:"containerPath,omitempty" skaffold:"template"`
+	// Timeout is a duration such as 90s, default 10m
+	Timeout string `json:"timeout,omitempty"`
+}
+
+const (
+	// CommandOutputDir is a command that writes a directory
+	CommandOutputDir = "dir"
+	// CommandOutputTar is a command that writes a tar file
+	CommandOutputTar = "tar"
+)
+
 // LocalDir is a directory structure that should be appended as-is to base
 // with an optional path prefix, for example ./target/app to /app
 type LocalDir struct {
@@ -0,0 +1,54 @@
+package v1
+
+import "path/filepath"
+
+// InDir returns config with every relative layer source path joined to dir,
+// and goBuild and command layers run in dir, so that the config builds the same
+// regardless of the current directory. NodeApp.NodeModules stays relative
+// to NodeApp.Dir, and Extensions are left as they are. Config is not
+// modified.
+func InDir(config ContainConfig, dir string) ContainConfig {
+	join := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	joinAll := func(m map[string]string) map[string]string {
+		if m == nil {
+			return nil
+		}
+		joined := make(map[string]string, len(m))
+		for k, p := range m {
+			joined[k] = join(p)
+		}
+		return joined
+	}
+	layers := make([]Layer, len(config.Layers))
+	for i, l := range config.Layers {
+		l.LocalDir.Path = join(l.LocalDir.Path)
+		l.LocalFile.Path = join(l.LocalFile.Path)
+		l.LocalFile.PathPerPlatform = joinAll(l.LocalFile.PathPerPlatform)
+		if l.Files != nil {
+			files := make([]FileMapping, len(l.Files))
+			for j, f := range l.Files {
+				f.Src = join(f.Src)
+				f.PathPerPlatform = joinAll(f.PathPerPlatform)
+				files[j] = f
+			}
+			l.Files = files
+		}
+		if l.GoBuild.Package != "" && !filepath.IsAbs(l.GoBuild.Dir) {
+			l.GoBuild.Dir = filepath.Join(dir, l.GoBuild.Dir)
+		}
+		if l.Command.Path != "" && !filepath.IsAbs(l.Command.Dir) {
+			l.Command.Dir = filepath.Join(dir, l.Command.Dir)
+		}
+		l.JavaApp.Jar = join(l.JavaApp.Jar)
+		l.JavaApp.Dir = join(l.JavaApp.Dir)
+		l.NodeApp.Dir = join(l.NodeApp.Dir)
+		layers[i] = l
+	}
+	config.Layers = layers
+	return config
+}
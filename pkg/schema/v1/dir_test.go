@@ -0,0 +1,37 @@
+package v1
+
+import "testing"
+
+func TestInDir(t *testing.T) {
+	config := ContainConfig{Layers: []Layer{
+		{LocalDir: LocalDir{Path: "target/app"}},
+		{LocalFile: LocalFile{PathPerPlatform: map[string]string{"linux/amd64": "bin/amd64", "linux/arm64": "/abs/arm64"}}},
+		{Files: []FileMapping{{Src: "config/*.yaml", Dst: "/etc/app/"}}},
+		{GoBuild: GoBuild{Package: "./cmd/server"}},
+		{GoBuild: GoBuild{Package: "./cmd/api", Dir: "services/api"}},
+		{NodeApp: NodeApp{Dir: "web", NodeModules: "node_modules"}},
+		{Command: Command{Path: "./gen.sh"}},
+	}}
+	got := InDir(config, "/ctx")
+	for _, c := range []struct{ got, want string }{
+		{got.Layers[0].LocalDir.Path, "/ctx/target/app"},
+		{got.Layers[1].LocalFile.PathPerPlatform["linux/amd64"], "/ctx/bin/amd64"},
+		{got.Layers[1].LocalFile.PathPerPlatform["linux/arm64"], "/abs/arm64"},
+		{got.Layers[1].LocalFile.Path, ""},
+		{got.Layers[2].Files[0].Src, "/ctx/config/*.yaml"},
+		{got.Layers[3].GoBuild.Dir, "/ctx"},
+		{got.Layers[3].GoBuild.Package, "./cmd/server"},
+		{got.Layers[4].GoBuild.Dir, "/ctx/services/api"},
+		{got.Layers[5].NodeApp.Dir, "/ctx/web"},
+		{got.Layers[5].NodeApp.NodeModules, "node_modules"},
+		{got.Layers[6].Command.Dir, "/ctx"},
+		{got.Layers[6].Command.Path, "./gen.sh"},
+	} {
+		if c.got != c.want {
+			t.Errorf("got %q, want %q", c.got, c.want)
+		}
+	}
+	if config.Layers[0].LocalDir.Path != "target/app" || config.Layers[1].LocalFile.PathPerPlatform["linux/amd64"] != "bin/amd64" {
+		t.Errorf("config was modified: %+v", config.Layers)
+	}
+}
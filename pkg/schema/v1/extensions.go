@@ -0,0 +1,135 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+)
+
+// layerKeys are the JSON names of Layer's fields, the keys that are not
+// extensions
+var layerKeys = func() map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(Layer{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}()
+
+// IsBuiltinLayerKey is true for the keys of a layers item that contain
+// itself handles, which an extension can't use
+func IsBuiltinLayerKey(key string) bool {
+	return layerKeys[key]
+}
+
+// ClosestLayerKey returns the key of a layers item field that key looks
+// like a typo of, such as localFile for localfile, or "" if there is none.
+// Such keys are not taken for extensions.
+func ClosestLayerKey(key string) string {
+	if layerKeys[key] {
+		return ""
+	}
+	lower := strings.ToLower(key)
+	closest, best := "", -1
+	for k := range layerKeys {
+		d := editDistance(lower, strings.ToLower(k))
+		if d > len(k)/5 && d > 1 {
+			continue
+		}
+		if best == -1 || d < best || (d == best && k < closest) {
+			closest, best = k, d
+		}
+	}
+	return closest
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// layer has Layer's fields without its methods
+type layer Layer
+
+// UnmarshalJSON keeps every key that isn't a Layer field as an extension,
+// and fails for a key that looks like a typo of a field, see ClosestLayerKey
+func (l *Layer) UnmarshalJSON(b []byte) error {
+	var known layer
+	if err := json.Unmarshal(b, &known); err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+	known.Extensions = nil
+	for k, raw := range all {
+		if layerKeys[k] {
+			continue
+		}
+		if field := ClosestLayerKey(k); field != "" {
+			return fmt.Errorf("layers item key %q is not a layer type or field, did you mean %q?", k, field)
+		}
+		if known.Extensions == nil {
+			known.Extensions = map[string]json.RawMessage{}
+		}
+		known.Extensions[k] = raw
+	}
+	*l = Layer(known)
+	return nil
+}
+
+// MarshalJSON writes extensions next to the fields, as they were read
+func (l Layer) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(layer(l))
+	if err != nil || len(l.Extensions) == 0 {
+		return b, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	for k, raw := range l.Extensions {
+		all[k] = raw
+	}
+	return json.Marshal(all)
+}
+
+// JSONSchemaExtend allows extension keys, which the schema can't know
+func (Layer) JSONSchemaExtend(s *jsonschema.Schema) {
+	s.AdditionalProperties = jsonschema.TrueSchema
+}
+
+// extensionTypes returns the extension keys of layer, sorted
+func extensionTypes(layer Layer) []string {
+	types := make([]string, 0, len(layer.Extensions))
+	for k := range layer.Extensions {
+		types = append(types, k)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,52 @@
+package v1
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/invopop/yaml"
+)
+
+func TestLayer_Extensions(t *testing.T) {
+	var c ContainConfig
+	if err := yaml.Unmarshal([]byte("layers:\n- artifactStore:\n    id: abc\n  platforms: [linux/amd64]\n- localDir: {path: app}\n"), &c); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got := string(c.Layers[0].Extensions["artifactStore"]); got != `{"id":"abc"}` {
+		t.Errorf("raw extension config, got %s", got)
+	}
+	if len(c.Layers[0].Platforms) != 1 {
+		t.Errorf("fields are read as usual, got %v", c.Layers[0].Platforms)
+	}
+	if got := LayerTypes(c.Layers[0]); len(got) != 1 || got[0] != "artifactStore" {
+		t.Errorf("got types %v", got)
+	}
+	if c.Layers[1].Extensions != nil {
+		t.Errorf("no extensions, got %v", c.Layers[1].Extensions)
+	}
+	b, err := json.Marshal(c.Layers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again Layer
+	if err := json.Unmarshal(b, &again); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(again.Extensions["artifactStore"]); got != `{"id":"abc"}` {
+		t.Errorf("extension after marshal, got %s in %s", got, b)
+	}
+}
+
+func TestLayer_ExtensionTypo(t *testing.T) {
+	var c ContainConfig
+	err := yaml.Unmarshal([]byte("layers:\n- localfile: {path: app, containerPath: /app}\n"), &c)
+	if err == nil || !strings.Contains(err.Error(), `layers item key "localfile" is not a layer type or field, did you mean "localFile"?`) {
+		t.Errorf("got %v", err)
+	}
+	for key, want := range map[string]string{"localDirs": "localDir", "platform": "platforms", "artifactStore": "", "localDir": ""} {
+		if got := ClosestLayerKey(key); got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+}
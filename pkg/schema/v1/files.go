@@ -0,0 +1,49 @@
+package v1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ResolveFileMappingSrc returns the source path or glob of a files entry for
+// this platform, using the matching order of ResolveLocalFilePath with Src
+// as the fallback. An empty return value means the entry has no source for
+// this platform.
+func ResolveFileMappingSrc(m FileMapping, p v1.Platform) string {
+	return ResolveLocalFilePath(LocalFile{Path: m.Src, PathPerPlatform: m.PathPerPlatform}, p)
+}
+
+// validateFiles is ValidateLayers for a files layer.
+func validateFiles(i int, layer Layer, platforms []v1.Platform) []string {
+	var errs []string
+	for j, m := range layer.Files {
+		if !strings.HasPrefix(m.Dst, "/") {
+			errs = append(errs, fmt.Sprintf("layers[%d].files[%d]: dst must be an absolute path, got %q", i, j, m.Dst))
+		}
+		if m.Mode < 0 || m.Mode > 0777 {
+			errs = append(errs, fmt.Sprintf("layers[%d].files[%d]: mode must be between 0 and 0777, got %o", i, j, m.Mode))
+		}
+		keys := make([]string, 0, len(m.PathPerPlatform))
+		for k := range m.PathPerPlatform {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !isValidPlatformKey(key) {
+				errs = append(errs, fmt.Sprintf(`layers[%d].files[%d].pathPerPlatform: invalid key %q (expected "<os>/<arch>" or "<os>/<arch>/<variant>")`, i, j, key))
+			}
+		}
+		for _, p := range platforms {
+			if !LayerAppliesTo(layer, p) {
+				continue
+			}
+			if ResolveFileMappingSrc(m, p) == "" {
+				errs = append(errs, fmt.Sprintf(`layers[%d].files[%d]: no src for platform %s (add pathPerPlatform[%q] or src)`, i, j, p.String(), p.OS+"/"+p.Architecture))
+			}
+		}
+	}
+	return errs
+}
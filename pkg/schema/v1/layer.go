@@ -0,0 +1,42 @@
+package v1
+
+import v1 "github.com/google/go-containerregistry/pkg/v1"
+
+// LayerTypes names the layer types that layer sets, which for a valid
+// config is exactly one. Extension keys are included, registered or not.
+func LayerTypes(layer Layer) []string {
+	var types []string
+	if layer.LocalFile.Path != "" || len(layer.LocalFile.PathPerPlatform) > 0 {
+		types = append(types, "localFile")
+	}
+	if layer.LocalDir.Path != "" {
+		types = append(types, "localDir")
+	}
+	if len(layer.Files) > 0 {
+		types = append(types, "files")
+	}
+	if layer.GoBuild.Package != "" {
+		types = append(types, "goBuild")
+	}
+	if layer.JavaApp.Jar != "" || layer.JavaApp.Dir != "" {
+		types = append(types, "javaApp")
+	}
+	if layer.NodeApp.Dir != "" {
+		types = append(types, "nodeApp")
+	}
+	if layer.Command.Path != "" {
+		types = append(types, "command")
+	}
+	return append(types, extensionTypes(layer)...)
+}
+
+// ResolveNodeModules returns the node_modules dir, relative to Dir, to read
+// native dependencies for platform from, with the matching order of
+// ResolveLocalFilePath.
+func ResolveNodeModules(app NodeApp, p v1.Platform) string {
+	fallback := app.NodeModules
+	if fallback == "" {
+		fallback = "node_modules"
+	}
+	return ResolveLocalFilePath(LocalFile{Path: fallback, PathPerPlatform: app.NodeModulesPerPlatform}, p)
+}
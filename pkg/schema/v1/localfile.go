@@ -64,23 +64,66 @@ func ResolveLocalFilePath(lf LocalFile, p v1.Platform) string {
 }
 
 // ValidateLayers checks that every layer has a resolvable source for every
-// platform in platforms. Returns a nil error if everything resolves, or an
-// error naming each offending layer and platform. The error is suitable
-// for early-exit before any registry push.
+// platform in platforms that its platforms selector includes. Returns a nil
+// error if everything resolves, or an error naming each offending layer and
+// platform. The error is suitable for early-exit before any registry push.
 func ValidateLayers(config ContainConfig, platforms []v1.Platform) error {
 	var errs []string
 	for i, layer := range config.Layers {
-		hasLocalFile := layer.LocalFile.Path != "" || len(layer.LocalFile.PathPerPlatform) > 0
-		hasLocalDir := layer.LocalDir.Path != ""
-		if hasLocalFile && hasLocalDir {
-			errs = append(errs, fmt.Sprintf("layers[%d]: each layer item must have exactly one type, got localFile and localDir", i))
+		types := LayerTypes(layer)
+		if len(types) > 1 {
+			errs = append(errs, fmt.Sprintf("layers[%d]: each layer item must have exactly one type, got %s", i, strings.Join(types, " and ")))
 			continue
 		}
-		if !hasLocalFile && !hasLocalDir {
-			errs = append(errs, fmt.Sprintf("layers[%d]: no layer builder config found (set localFile.path, localFile.pathPerPlatform, or localDir.path)", i))
+		if len(types) == 0 {
+			errs = append(errs, fmt.Sprintf("layers[%d]: no layer builder config found (set localFile.path, localFile.pathPerPlatform, localDir.path, files, goBuild.package, javaApp, nodeApp, command.path, or a registered layer type)", i))
 			continue
 		}
-		if !hasLocalFile {
+		for _, key := range layer.Platforms {
+			if !isValidPlatformKey(key) {
+				errs = append(errs, fmt.Sprintf(`layers[%d].platforms: invalid entry %q (expected "<os>/<arch>" or "<os>/<arch>/<variant>")`, i, key))
+			}
+		}
+		if _, ok := layer.Extensions[types[0]]; ok {
+			// the registered type validates its config, see layers.ValidateLayers
+			continue
+		}
+		switch types[0] {
+		case "files":
+			errs = append(errs, validateFiles(i, layer, platforms)...)
+			continue
+		case "goBuild":
+			if !strings.HasPrefix(layer.GoBuild.ContainerPath, "/") {
+				errs = append(errs, fmt.Sprintf("layers[%d].goBuild: containerPath must be an absolute path, got %q", i, layer.GoBuild.ContainerPath))
+			}
+			continue
+		case "javaApp":
+			if layer.JavaApp.Jar != "" && layer.JavaApp.Dir != "" {
+				errs = append(errs, fmt.Sprintf("layers[%d].javaApp: set jar or dir, not both", i))
+			}
+			if layer.JavaApp.ContainerPath != "" && !strings.HasPrefix(layer.JavaApp.ContainerPath, "/") {
+				errs = append(errs, fmt.Sprintf("layers[%d].javaApp: containerPath must be an absolute path, got %q", i, layer.JavaApp.ContainerPath))
+			}
+			continue
+		case "nodeApp":
+			if layer.NodeApp.ContainerPath != "" && !strings.HasPrefix(layer.NodeApp.ContainerPath, "/") {
+				errs = append(errs, fmt.Sprintf("layers[%d].nodeApp: containerPath must be an absolute path, got %q", i, layer.NodeApp.ContainerPath))
+			}
+			keys := make([]string, 0, len(layer.NodeApp.NodeModulesPerPlatform))
+			for k := range layer.NodeApp.NodeModulesPerPlatform {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if !isValidPlatformKey(key) {
+					errs = append(errs, fmt.Sprintf(`layers[%d].nodeApp.nodeModulesPerPlatform: invalid key %q (expected "<os>/<arch>" or "<os>/<arch>/<variant>")`, i, key))
+				}
+			}
+			continue
+		case "command":
+			errs = append(errs, validateCommand(i, layer.Command)...)
+			continue
+		case "localDir":
 			continue
 		}
 		keys := make([]string, 0, len(layer.LocalFile.PathPerPlatform))
@@ -94,6 +137,9 @@ func ValidateLayers(config ContainConfig, platforms []v1.Platform) error {
 			}
 		}
 		for _, p := range platforms {
+			if !LayerAppliesTo(layer, p) {
+				continue
+			}
 			if ResolveLocalFilePath(layer.LocalFile, p) == "" {
 				errs = append(errs, fmt.Sprintf(`layers[%d].localFile: no path for platform %s (add pathPerPlatform[%q] or a top-level path fallback)`, i, p.String(), p.OS+"/"+p.Architecture))
 			}
@@ -302,3 +302,70 @@ func TestResolveLocalFilePath_ExactKeyWinsOverNormalized(t *testing.T) {
 		t.Errorf("exact plain key got %q want arm64-generic", got)
 	}
 }
+
+func TestLayerAppliesTo(t *testing.T) {
+	layer := Layer{Platforms: []string{"linux/amd64", "linux/arm64/v8"}}
+	if !LayerAppliesTo(layer, amd64()) {
+		t.Error("linux/amd64 is listed")
+	}
+	if !LayerAppliesTo(layer, arm64()) {
+		t.Error("linux/arm64 is linux/arm64/v8 once normalized")
+	}
+	if LayerAppliesTo(layer, v1.Platform{OS: "linux", Architecture: "s390x"}) {
+		t.Error("linux/s390x is not listed")
+	}
+	if !LayerAppliesTo(layer, v1.Platform{}) {
+		t.Error("the zero platform selects every layer")
+	}
+	if !LayerAppliesTo(Layer{}, arm64()) {
+		t.Error("no selector selects every platform")
+	}
+}
+
+func TestValidateLayers_SkipsPlatformsOutsideSelector(t *testing.T) {
+	cfg := ContainConfig{Layers: []Layer{{
+		Platforms: []string{"linux/amd64"},
+		LocalFile: LocalFile{PathPerPlatform: map[string]string{"linux/amd64": "agent"}},
+	}}}
+	if err := ValidateLayers(cfg, []v1.Platform{amd64(), arm64()}); err != nil {
+		t.Errorf("arm64 has no path but is not selected, got %v", err)
+	}
+	cfg.Layers[0].Platforms = []string{"amd64"}
+	err := ValidateLayers(cfg, []v1.Platform{amd64()})
+	if err == nil || !strings.Contains(err.Error(), `layers[0].platforms: invalid entry "amd64"`) {
+		t.Errorf("expected invalid entry error, got %v", err)
+	}
+}
+
+func TestValidateLayers_Files(t *testing.T) {
+	cfg := ContainConfig{Layers: []Layer{{Files: []FileMapping{
+		{Src: "config/*.yaml", Dst: "/etc/app/"},
+		{PathPerPlatform: map[string]string{"linux/amd64": "amd64/run"}, Dst: "/usr/local/bin/run"},
+	}}}}
+	if err := ValidateLayers(cfg, []v1.Platform{amd64()}); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+	err := ValidateLayers(cfg, []v1.Platform{amd64(), arm64()})
+	if err == nil || !strings.Contains(err.Error(), "layers[0].files[1]: no src for platform linux/arm64") {
+		t.Errorf("expected missing src for arm64, got %v", err)
+	}
+	cfg.Layers[0].LocalDir.Path = "dir"
+	err = ValidateLayers(cfg, []v1.Platform{amd64()})
+	if err == nil || !strings.Contains(err.Error(), "got localDir and files") {
+		t.Errorf("expected exactly one type error, got %v", err)
+	}
+}
+
+func TestValidateLayers_Command(t *testing.T) {
+	cfg := ContainConfig{Layers: []Layer{{Command: Command{Path: "./gen.sh", Output: "tar", Timeout: "90s"}}}}
+	if err := ValidateLayers(cfg, []v1.Platform{amd64()}); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+	cfg.Layers[0].Command = Command{Path: "./gen.sh", Output: "zip", ContainerPath: "app/", Timeout: "soon"}
+	err := ValidateLayers(cfg, []v1.Platform{amd64()})
+	for _, want := range []string{`output must be dir or tar, got "zip"`, `containerPath must be an absolute path`, `timeout must be a positive duration`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q, got %v", want, err)
+		}
+	}
+}
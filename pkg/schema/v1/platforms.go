@@ -0,0 +1,59 @@
+package v1
+
+import (
+	"encoding/json"
+
+	"github.com/invopop/jsonschema"
+)
+
+// PlatformsAuto is the platforms value that builds every platform of the
+// base for which each per-platform layer source exists, see Platforms.Auto.
+const PlatformsAuto = "auto"
+
+// Platforms is the platforms config: a list of "<os>/<arch>[/<variant>]"
+// values, or the single value auto. YAML accepts auto as a scalar,
+// platforms: auto, which reads as a one-item list.
+//
+// An empty list means every platform in the base index.
+type Platforms []string
+
+// Auto reports whether platforms is the auto mode. With auto the base index
+// decides as it does for an empty list, and then any platform lacking a
+// per-platform layer source on disk is dropped instead of failing the build.
+func (p Platforms) Auto() bool {
+	return len(p) == 1 && p[0] == PlatformsAuto
+}
+
+// Requested returns the platforms the config names explicitly, which is
+// nothing for an empty list and for auto.
+func (p Platforms) Requested() []string {
+	if p.Auto() {
+		return nil
+	}
+	return p
+}
+
+// UnmarshalJSON accepts a list, or a scalar that becomes a one-item list.
+func (p *Platforms) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*p = Platforms{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*p = l
+	return nil
+}
+
+// JSONSchema describes both accepted shapes to the schema generator.
+func (Platforms) JSONSchema() *jsonschema.Schema {
+	return &jsonschema.Schema{
+		OneOf: []*jsonschema.Schema{
+			{Type: "array", Items: &jsonschema.Schema{Type: "string"}},
+			{Type: "string", Enum: []any{PlatformsAuto}},
+		},
+	}
+}
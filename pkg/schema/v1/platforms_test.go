@@ -0,0 +1,42 @@
+package v1
+
+import (
+	"testing"
+
+	"github.com/invopop/yaml"
+)
+
+func TestPlatforms_ScalarAuto(t *testing.T) {
+	var c ContainConfig
+	if err := yaml.Unmarshal([]byte("platforms: auto\n"), &c); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !c.Platforms.Auto() {
+		t.Errorf("platforms: auto should be auto, got %v", c.Platforms)
+	}
+	if got := c.Platforms.Requested(); len(got) != 0 {
+		t.Errorf("auto requests no explicit platforms, got %v", got)
+	}
+}
+
+func TestPlatforms_List(t *testing.T) {
+	var c ContainConfig
+	if err := yaml.Unmarshal([]byte("platforms:\n- linux/amd64\n- linux/arm64\n"), &c); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if c.Platforms.Auto() {
+		t.Error("a list is not auto")
+	}
+	if got := c.Platforms.Requested(); len(got) != 2 || got[1] != "linux/arm64" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestPlatforms_AutoOnlyAlone(t *testing.T) {
+	// auto among other values is a platform string that will fail to match,
+	// not a mode
+	p := Platforms{"auto", "linux/amd64"}
+	if p.Auto() {
+		t.Error("auto is a mode only as the single value")
+	}
+}
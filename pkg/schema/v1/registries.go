@@ -0,0 +1,24 @@
+package v1
+
+// RegistrySettings is how to reach one registry, for pulls and pushes alike.
+// Registries without settings use https, the system CA pool and the docker
+// keychain, except that bases in localhost and *.local registries are pulled
+// anonymously as before.
+type RegistrySettings struct {
+	// PlainHTTP talks http instead of https to the registry
+	PlainHTTP bool `json:"plainHTTP,omitempty"`
+	// Insecure skips TLS certificate verification
+	Insecure bool `json:"insecure,omitempty"`
+	// CA is a PEM file with certificates to trust in addition to the system's
+	CA string `json:"ca,omitempty"`
+	// ClientCert and ClientKey are PEM files for mutual TLS
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+	// Anonymous skips the docker keychain
+	Anonymous bool `json:"anonymous,omitempty"`
+	// Mirrors are tried in order before the registry itself when pulling by
+	// digest, as host[:port][/path], with an optional http:// or https://.
+	// The path is prepended to the repository, as for a pull-through
+	// project. Tags are always resolved by the registry itself.
+	Mirrors []string `json:"mirrors,omitempty"`
+}
@@ -0,0 +1,33 @@
+package v1
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/turbokube/contain/pkg/platform"
+)
+
+// LayerAppliesTo reports whether layer belongs on platform according to its
+// Platforms selector. A layer without a selector applies to every platform.
+//
+// Entries are compared with platform.Equal, the comparison base index
+// children get, so linux/arm64 selects a child declaring linux/arm64/v8 and
+// the other way round. Unlike pathPerPlatform keys an entry without a
+// variant does not also select other variants: linux/arm selects linux/arm/v7
+// only, not linux/arm/v6.
+//
+// The zero platform, which sync to a running container passes because it
+// has no base index to choose from, selects every layer.
+func LayerAppliesTo(layer Layer, p v1.Platform) bool {
+	if len(layer.Platforms) == 0 || p.OS == "" {
+		return true
+	}
+	for _, s := range layer.Platforms {
+		sp, err := v1.ParsePlatform(s)
+		if err != nil {
+			continue
+		}
+		if platform.Equal(*sp, p) {
+			return true
+		}
+	}
+	return false
+}